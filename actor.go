@@ -0,0 +1,280 @@
+package antnet
+
+import (
+	"sync"
+	"time"
+)
+
+// 串行执行的邮箱，投递到同一个Actor的函数按顺序在协程池中执行，同一时刻只有一个在运行，逻辑不需要加锁
+type Actor struct {
+	app     *App
+	key     interface{}
+	keyed   bool //由GetActor创建，停止时从实例中删除
+	lock    sync.Mutex
+	queue   []func()
+	size    int           //邮箱容量，0表示使用DefActorMailboxSize
+	cspace  chan struct{} //邮箱满时等待空位
+	running bool
+	stop    bool
+	timers  timerOwner
+}
+
+var DefActorMailboxSize int = 10000 //Actor邮箱的默认容量，小于等于0表示不限制
+var DefActorPostTimeout int = 3000  //消息队列投递到已满的Actor时最多等待的时间，单位ms
+
+type IActorUser interface {
+	GetActor() *Actor
+}
+
+type actorMap struct {
+	sync.Mutex
+	M map[interface{}]*Actor
+}
+
+// 创建DefApp的Actor
+func NewActor() *Actor {
+	return DefApp.NewActor()
+}
+
+func GetActor(key interface{}) *Actor {
+	return DefApp.GetActor(key)
+}
+
+func FindActor(key interface{}) *Actor {
+	return DefApp.FindActor(key)
+}
+
+func DelActor(key interface{}) {
+	DefApp.DelActor(key)
+}
+
+// 创建属于实例的Actor，投递的函数在实例的协程池中执行
+func (r *App) NewActor() *Actor {
+	return &Actor{app: r}
+}
+
+// 获取key对应的Actor，不存在则创建，一般用玩家id作为key，每个实例的key互不影响
+func (r *App) GetActor(key interface{}) *Actor {
+	r.actors.Lock()
+	a, ok := r.actors.M[key]
+	if !ok {
+		a = &Actor{app: r, key: key, keyed: true}
+		r.actors.M[key] = a
+	}
+	r.actors.Unlock()
+	return a
+}
+
+func (r *App) FindActor(key interface{}) *Actor {
+	r.actors.Lock()
+	a := r.actors.M[key]
+	r.actors.Unlock()
+	return a
+}
+
+// 删除并停止key对应的Actor，已经投递的函数不再执行
+func (r *App) DelActor(key interface{}) {
+	r.actors.Lock()
+	a, ok := r.actors.M[key]
+	delete(r.actors.M, key)
+	r.actors.Unlock()
+	if ok {
+		a.Stop()
+	}
+}
+
+// 零值的Actor属于DefApp
+func (r *Actor) getApp() *App {
+	if r.app == nil {
+		return DefApp
+	}
+	return r.app
+}
+
+func (r *Actor) Key() interface{} {
+	return r.key
+}
+
+// 停止Actor，已经投递的函数不再执行，由GetActor创建的Actor同时从实例中删除
+func (r *Actor) Stop() {
+	r.lock.Lock()
+	r.stop = true
+	r.queue = nil
+	r.wakeup()
+	r.lock.Unlock()
+	r.timers.stopTimers()
+	if r.keyed {
+		app := r.getApp()
+		app.actors.Lock()
+		if app.actors.M[r.key] == r {
+			delete(app.actors.M, r.key)
+		}
+		app.actors.Unlock()
+	}
+}
+
+func (r *Actor) IsStop() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stop
+}
+
+// 设置邮箱容量，小于等于0表示不限制
+func (r *Actor) SetMailboxSize(size int) {
+	r.lock.Lock()
+	if size <= 0 {
+		size = -1
+	}
+	r.size = size
+	r.wakeup()
+	r.lock.Unlock()
+}
+
+func (r *Actor) full() bool {
+	size := r.size
+	if size == 0 {
+		size = DefActorMailboxSize
+	}
+	return size > 0 && len(r.queue) >= size
+}
+
+func (r *Actor) wakeup() {
+	if r.cspace != nil {
+		close(r.cspace)
+		r.cspace = nil
+	}
+}
+
+func (r *Actor) Len() int {
+	r.lock.Lock()
+	n := len(r.queue)
+	r.lock.Unlock()
+	return n
+}
+
+// 投递函数，Actor停止或者邮箱已满返回false
+func (r *Actor) Post(fn func()) bool {
+	return r.post(fn, 0, true) == nil
+}
+
+// 投递函数，邮箱已满时最多等待timeout毫秒，用于对投递者施加背压
+func (r *Actor) PostWait(fn func(), timeout int) error {
+	return r.post(fn, timeout, true)
+}
+
+// limit为false时不检查邮箱容量，用于定时器等内部投递
+func (r *Actor) post(fn func(), timeout int, limit bool) error {
+	var tick *time.Timer
+	r.lock.Lock()
+	for limit && !r.stop && r.full() {
+		if timeout <= 0 {
+			r.lock.Unlock()
+			return ErrActorFull
+		}
+		if tick == nil {
+			tick = time.NewTimer(time.Millisecond * time.Duration(timeout))
+			defer tick.Stop()
+		}
+		if r.cspace == nil {
+			r.cspace = make(chan struct{})
+		}
+		c := r.cspace
+		r.lock.Unlock()
+		select {
+		case <-c:
+		case <-tick.C:
+			r.getApp().LogWarn("actor mailbox full key:%v", r.key)
+			return ErrActorFull
+		}
+		r.lock.Lock()
+	}
+	if r.stop {
+		r.lock.Unlock()
+		return ErrNetClosed
+	}
+	r.queue = append(r.queue, fn)
+	if r.running {
+		r.lock.Unlock()
+		return nil
+	}
+	r.running = true
+	r.lock.Unlock()
+	r.getApp().Go(r.run)
+	return nil
+}
+
+func (r *Actor) run() {
+	for {
+		r.lock.Lock()
+		if len(r.queue) == 0 {
+			r.running = false
+			r.lock.Unlock()
+			return
+		}
+		fn := r.queue[0]
+		r.queue[0] = nil
+		r.queue = r.queue[1:]
+		r.wakeup()
+		r.lock.Unlock()
+		Try(fn, nil)
+	}
+}
+
+// 投递函数并等待执行完毕，timeout为0使用DefCallTimeout，不能在自己的Actor里面调用自己，否则会等到超时
+func (r *Actor) Call(fn func(), timeout int) error {
+	if timeout <= 0 {
+		timeout = DefCallTimeout
+	}
+	c := make(chan struct{})
+	if err := r.PostWait(func() {
+		defer close(c)
+		fn()
+	}, timeout); err != nil {
+		return err
+	}
+	tick := time.NewTimer(time.Millisecond * time.Duration(timeout))
+	defer tick.Stop()
+	select {
+	case <-c:
+		return nil
+	case <-tick.C:
+		r.getApp().LogWarn("actor call timeout key:%v", r.key)
+		return ErrNetTimeout
+	}
+}
+
+// 定时器在Actor里面执行，返回值大于0表示间隔多少毫秒再次执行，Actor停止后定时器自动取消
+func (r *Actor) SetTimeout(inteval int, fn func(...interface{}) int, args ...interface{}) *Timer {
+	return newTimeout(inteval, fn, args, r, &r.timers)
+}
+
+func (r *Actor) SetInterval(inteval int, fn func(...interface{}), args ...interface{}) *Timer {
+	return newInterval(inteval, fn, args, r, &r.timers)
+}
+
+func (r *Actor) SetCron(spec string, fn func(...interface{}), args ...interface{}) (*Timer, error) {
+	return newCron(spec, fn, args, r, &r.timers)
+}
+
+// 设置消息队列的Actor，设置后消息在Actor中串行处理，未设置时如果user实现了IActorUser则使用user的Actor
+func (r *msgQue) SetActor(a *Actor) {
+	r.actor = a
+}
+
+func (r *msgQue) AddTimer(inteval int, fn func(...interface{}) int, args ...interface{}) *Timer {
+	return newTimeout(inteval, fn, args, r.GetActor(), &r.timers)
+}
+
+func (r *msgQue) AddCron(spec string, fn func(...interface{}), args ...interface{}) (*Timer, error) {
+	return newCron(spec, fn, args, r.GetActor(), &r.timers)
+}
+
+func (r *msgQue) GetActor() *Actor {
+	if r.actor != nil {
+		return r.actor
+	}
+	if u, ok := r.user.(IActorUser); ok {
+		return u.GetActor()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package antnet
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ActorMailbox(t *testing.T) {
+	a := NewActor()
+	a.SetMailboxSize(1)
+	cstart := make(chan struct{})
+	cblock := make(chan struct{})
+	a.Post(func() {
+		close(cstart)
+		<-cblock
+	})
+	<-cstart
+
+	c := make(chan int, 3)
+	if !a.Post(func() { c <- 1 }) {
+		t.Fatal("post to actor with space failed")
+	}
+	if a.Post(func() { c <- 2 }) {
+		t.Fatal("post to full actor should fail")
+	}
+	if err := a.PostWait(func() { c <- 3 }, 10); err != ErrActorFull {
+		t.Fatalf("post wait to full actor err:%v", err)
+	}
+
+	//邮箱有空位后等待中的投递继续执行
+	cerr := make(chan error, 1)
+	go func() {
+		cerr <- a.PostWait(func() { c <- 4 }, 2000)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(cblock)
+	if err := <-cerr; err != nil {
+		t.Fatalf("post wait err:%v", err)
+	}
+	for _, want := range []int{1, 4} {
+		select {
+		case v := <-c:
+			if v != want {
+				t.Fatalf("got %v want %v", v, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait actor run timeout")
+		}
+	}
+
+	a.Stop()
+	if !a.IsStop() || a.PostWait(func() {}, 10) != ErrNetClosed {
+		t.Fatal("post to stopped actor should fail")
+	}
+}
+
+func Test_ActorStopRemove(t *testing.T) {
+	a := GetActor("actor_test")
+	if FindActor("actor_test") != a {
+		t.Fatal("actor not found")
+	}
+	a.Stop()
+	if FindActor("actor_test") != nil {
+		t.Fatal("stopped actor should be removed")
+	}
+	if b := GetActor("actor_test"); b == a || b.IsStop() {
+		t.Fatal("get actor after stop should create a new one")
+	}
+	DelActor("actor_test")
+}
+
+func Test_ActorApp(t *testing.T) {
+	app, other := NewApp(nil), NewApp(nil)
+	defer app.Stop()
+	defer other.Stop()
+	a := app.GetActor("actor_test")
+	if other.FindActor("actor_test") != nil || FindActor("actor_test") == a {
+		t.Fatal("actor leaked to other app")
+	}
+	if b := other.GetActor("actor_test"); b == a {
+		t.Fatal("apps should not share actors")
+	}
+	c := make(chan struct{})
+	a.Post(func() { close(c) })
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatal("wait actor run timeout")
+	}
+	app.DelActor("actor_test")
+	if app.FindActor("actor_test") != nil || other.FindActor("actor_test") == nil {
+		t.Fatal("del actor should only remove from its own app")
+	}
+}
+
+func Test_ActorPostDrop(t *testing.T) {
+	old, oldTimeout := Config.EnableMetrics, DefActorPostTimeout
+	Config.EnableMetrics, DefActorPostTimeout = true, 10
+	defer func() { Config.EnableMetrics, DefActorPostTimeout = old, oldTimeout }()
+
+	msgque, _ := newSendTestMsgQue(t)
+	a := msgque.app.NewActor()
+	defer a.Stop()
+	a.SetMailboxSize(1)
+	cstart := make(chan struct{})
+	cblock := make(chan struct{})
+	defer close(cblock)
+	a.Post(func() {
+		close(cstart)
+		<-cblock
+	})
+	<-cstart
+	a.Post(func() {})
+	msgque.SetActor(a)
+
+	//Actor邮箱满时等待超时后丢弃消息并计入统计
+	m := NewMsg(1, 1, 0, 0, nil)
+	if !msgque.dispatchMsg(msgque, m) {
+		t.Fatal("dispatch to full actor should not close msgque")
+	}
+	if n := atomic.LoadInt64(&msgque.app.getMetrics(msgque.netType, m.CmdAct()).discard); n != 1 {
+		t.Fatalf("discard:%v", n)
+	}
+}
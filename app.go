@@ -0,0 +1,378 @@
+package antnet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// 一个独立的antnet实例，拥有自己的协程池，消息队列，全局消息，定时器，Actor，统计和日志，包级别的函数使用DefApp
+// 以下是进程级的，所有实例共享：Config等配置，时间轮的时钟，全局中间件，panic统计，日志的后台协程，
+// redis的订阅协程使用DefApp的协程池，跟随包级别的Stop停止
+type App struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	stop          int32 //停止标志
+	draining      int32 //排空标志，停止前先排空消息队列
+	stopChanForGo chan struct{}
+	log           *Log
+
+	waitAll     WaitGroup //等待所有goroutine
+	poolChan    chan func()
+	poolGoCount int32
+	gocount     int32 //goroutine数量
+
+	msgqueMapSync sync.Mutex
+	msgqueMap     map[uint32]IMsgQue
+
+	gmsgId      uint16
+	gmsgMapSync sync.Mutex
+	gmsgArray   [65536]*gMsg
+
+	udpMapLock sync.Mutex
+	udpMap     map[string]*udpMsgQueHelper
+
+	stopCheckIndex uint64
+	stopCheckMap   struct {
+		sync.Mutex
+		M map[uint64]string
+	}
+
+	atexitId      uint32
+	atexitMapSync sync.Mutex
+	atexitMap     map[uint32]func()
+
+	timers  timerOwner //实例的定时器，停止时自动取消
+	metrics metricsMap //按网络类型和消息号的统计
+	actors  actorMap   //GetActor创建的Actor
+}
+
+var DefApp *App
+
+// 创建新的实例，log为nil时使用DefLog
+func NewApp(log *Log) *App {
+	if log == nil {
+		log = DefLog
+	}
+	r := &App{
+		log:           log,
+		stopChanForGo: make(chan struct{}),
+		poolChan:      make(chan func()),
+		msgqueMap:     map[uint32]IMsgQue{},
+		udpMap:        map[string]*udpMsgQueHelper{},
+		atexitMap:     map[uint32]func(){},
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.stopCheckMap.M = map[uint64]string{}
+	r.metrics.M = map[int]*metricsCounter{}
+	r.actors.M = map[interface{}]*Actor{}
+	r.gmsgArray[r.gmsgId] = &gMsg{c: make(chan struct{})}
+	return r
+}
+
+// 实例停止时取消
+func (r *App) Context() context.Context {
+	return r.ctx
+}
+
+func (r *App) SetLog(log *Log) {
+	r.log = log
+}
+
+func (r *App) GetLog() *Log {
+	return r.log
+}
+
+func (r *App) LogWith(kv ...interface{}) *FieldLog {
+	return r.log.With(kv...)
+}
+
+func (r *App) LogTrace(v ...interface{}) {
+	r.log.Trace(v...)
+}
+
+func (r *App) LogDebug(v ...interface{}) {
+	r.log.Debug(v...)
+}
+
+func (r *App) LogInfo(v ...interface{}) {
+	r.log.Info(v...)
+}
+
+func (r *App) LogWarn(v ...interface{}) {
+	r.log.Warn(v...)
+}
+
+func (r *App) LogError(v ...interface{}) {
+	r.log.Error(v...)
+}
+
+func (r *App) LogFatal(v ...interface{}) {
+	r.log.Fatal(v...)
+}
+
+func (r *App) IsStop() bool {
+	return atomic.LoadInt32(&r.stop) == 1
+}
+
+func (r *App) IsRuning() bool {
+	return atomic.LoadInt32(&r.stop) == 0
+}
+
+func (r *App) IsDraining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+func (r *App) AddStopCheck(cs string) uint64 {
+	id := atomic.AddUint64(&r.stopCheckIndex, 1)
+	if id == 0 {
+		id = atomic.AddUint64(&r.stopCheckIndex, 1)
+	}
+	r.stopCheckMap.Lock()
+	r.stopCheckMap.M[id] = cs
+	r.stopCheckMap.Unlock()
+	return id
+}
+
+func (r *App) RemoveStopCheck(id uint64) {
+	r.stopCheckMap.Lock()
+	delete(r.stopCheckMap.M, id)
+	r.stopCheckMap.Unlock()
+}
+
+func (r *App) AtExit(fun func()) {
+	id := atomic.AddUint32(&r.atexitId, 1)
+	if id == 0 {
+		id = atomic.AddUint32(&r.atexitId, 1)
+	}
+
+	r.atexitMapSync.Lock()
+	r.atexitMap[id] = fun
+	r.atexitMapSync.Unlock()
+}
+
+func (r *App) runAtExit() {
+	r.atexitMapSync.Lock()
+	for _, v := range r.atexitMap {
+		v()
+	}
+	r.atexitMapSync.Unlock()
+}
+
+func (r *App) GetStatis() *Statis {
+	r.msgqueMapSync.Lock()
+	n := len(r.msgqueMap)
+	r.msgqueMapSync.Unlock()
+	return &Statis{
+		GoCount:     int(atomic.LoadInt32(&r.gocount)),
+		MsgqueCount: n,
+		StartTime:   statis.StartTime,
+		LastPanic:   statis.LastPanic,
+		PanicCount:  atomic.LoadInt32(&statis.PanicCount),
+		PoolGoCount: atomic.LoadInt32(&r.poolGoCount),
+	}
+}
+
+// 发送全局消息，fun为nil表示发给所有消息队列
+func (r *App) Send(msg *Message, fun func(msgque IMsgQue) bool) {
+	if msg == nil {
+		return
+	}
+	c := make(chan struct{})
+	r.gmsgMapSync.Lock()
+	gmsg := r.gmsgArray[r.gmsgId]
+	r.gmsgArray[r.gmsgId+1] = &gMsg{c: c}
+	r.gmsgId++
+	r.gmsgMapSync.Unlock()
+	gmsg.msg = msg
+	gmsg.fun = fun
+	close(gmsg.c)
+}
+
+func (r *App) getGMsgId() uint16 {
+	r.gmsgMapSync.Lock()
+	defer r.gmsgMapSync.Unlock()
+	return r.gmsgId
+}
+
+func (r *App) SendGroup(group string, msg *Message) {
+	r.Send(msg, func(msgque IMsgQue) bool {
+		return msgque.IsInGroup(group)
+	})
+}
+
+func (r *App) addMsgQue(msgque IMsgQue) {
+	r.msgqueMapSync.Lock()
+	r.msgqueMap[msgque.Id()] = msgque
+	r.msgqueMapSync.Unlock()
+}
+
+func (r *App) getMsgQue(id uint32) IMsgQue {
+	r.msgqueMapSync.Lock()
+	msgque := r.msgqueMap[id]
+	r.msgqueMapSync.Unlock()
+	return msgque
+}
+
+func (r *App) delMsgQue(id uint32) {
+	r.msgqueMapSync.Lock()
+	delete(r.msgqueMap, id)
+	r.msgqueMapSync.Unlock()
+}
+
+// 停止实例，先排空消息队列，再停止所有goroutine，然后执行AtExit注册的函数
+func (r *App) Stop() {
+	if r.shutdown() {
+		r.runAtExit()
+	}
+}
+
+func (r *App) shutdown() bool {
+	if !atomic.CompareAndSwapInt32(&r.draining, 0, 1) {
+		return false
+	}
+
+	r.drainMsgQue()
+	atomic.StoreInt32(&r.stop, 1)
+	r.timers.stopTimers()
+	r.cancel()
+	close(r.stopChanForGo)
+	for sc := 0; !r.waitAll.TryWait(); sc++ {
+		Sleep(1)
+		if sc >= Config.StopTimeout {
+			r.LogError("Server Stop Timeout")
+			r.stopCheckMap.Lock()
+			for _, v := range r.stopCheckMap.M {
+				r.LogError("Server Stop Timeout:%v", v)
+			}
+			r.stopCheckMap.Unlock()
+			break
+		}
+	}
+	return true
+}
+
+// 停止服务时先关闭监听，等待消息队列发送完毕，然后关闭所有消息队列，保证OnDelMsgQue都被调用
+func (r *App) drainMsgQue() {
+	checkId := r.AddStopCheck("drain msgque")
+	defer func() {
+		r.RemoveStopCheck(checkId)
+	}()
+	report := func(s string, n int) {
+		r.RemoveStopCheck(checkId)
+		checkId = r.AddStopCheck(fmt.Sprintf("%s left:%d", s, n))
+		r.LogInfo("%s left:%d", s, n)
+	}
+
+	r.msgqueMapSync.Lock()
+	for _, v := range r.msgqueMap {
+		if d, ok := v.(msgQueDrainer); ok {
+			d.closeListen()
+		}
+	}
+	r.msgqueMapSync.Unlock()
+
+	for sc := 0; ; sc++ {
+		n := 0
+		r.msgqueMapSync.Lock()
+		for _, v := range r.msgqueMap {
+			if d, ok := v.(msgQueDrainer); ok && !d.drained() {
+				n++
+			}
+		}
+		r.msgqueMapSync.Unlock()
+		if n == 0 {
+			break
+		}
+		if sc >= Config.DrainTimeout {
+			r.LogError("drain msgque timeout left:%d", n)
+			break
+		}
+		if sc%1000 == 0 {
+			report("drain msgque", n)
+		}
+		Sleep(1)
+	}
+
+	for sc := 0; ; sc++ {
+		r.msgqueMapSync.Lock()
+		n := len(r.msgqueMap)
+		for _, v := range r.msgqueMap {
+			v.Stop()
+		}
+		r.msgqueMapSync.Unlock()
+		if n == 0 {
+			break
+		}
+		if sc >= Config.StopTimeout {
+			r.LogError("close msgque timeout left:%d", n)
+			break
+		}
+		if sc%1000 == 0 {
+			report("close msgque", n)
+		}
+		Sleep(1)
+	}
+}
+
+func (r *App) Go(fn func()) {
+	pc := Config.PoolSize + 1
+	select {
+	case r.poolChan <- fn:
+		return
+	default:
+		pc = atomic.AddInt32(&r.poolGoCount, 1)
+		if pc > Config.PoolSize {
+			atomic.AddInt32(&r.poolGoCount, -1)
+		}
+	}
+
+	r.waitAll.Add(1)
+	var debugStr string
+	id := atomic.AddUint32(&goid, 1)
+	c := atomic.AddInt32(&r.gocount, 1)
+	if r.log.Level() <= LogLevelDebug {
+		debugStr = LogSimpleStack()
+		r.LogTrace("goroutine start id:%d count:%d from:%s", id, c, debugStr)
+	}
+	go func() {
+		Try(fn, nil)
+		for pc <= Config.PoolSize {
+			select {
+			case <-r.stopChanForGo:
+				pc = Config.PoolSize + 1
+			case nfn := <-r.poolChan:
+				Try(nfn, nil)
+			}
+		}
+
+		r.waitAll.Done()
+		c = atomic.AddInt32(&r.gocount, -1)
+
+		if r.log.Level() <= LogLevelDebug {
+			r.LogTrace("goroutine end id:%d count:%d from:%s", id, c, debugStr)
+		}
+	}()
+}
+
+func (r *App) Go2(fn func(cstop chan struct{})) {
+	r.Go(func() {
+		fn(r.stopChanForGo)
+	})
+}
+
+func (r *App) GoArgs(fn func(...interface{}), args ...interface{}) {
+	r.Go(func() {
+		fn(args...)
+	})
+}
+
+// 定时器在实例的协程池中执行，fn返回值大于0表示间隔多少毫秒再次执行，实例停止后定时器自动取消
+func (r *App) SetTimeout(inteval int, fn func(...interface{}) int, args ...interface{}) *Timer {
+	if inteval < 0 {
+		r.LogError("new timeout inteval:%v ms", inteval)
+		return &Timer{stop: 1}
+	}
+	return startTimer(&Timer{fn: fn, args: args, app: r, owner: &r.timers}, inteval)
+}
@@ -0,0 +1,131 @@
+package antnet
+
+import (
+	"testing"
+	"time"
+)
+
+type reconnectTestHandler struct {
+	DefMsgHandler
+	cfail chan struct{}
+}
+
+func (r *reconnectTestHandler) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	if !ok {
+		select {
+		case r.cfail <- struct{}{}:
+		default:
+		}
+	}
+	return ok
+}
+
+func (r *reconnectTestHandler) OnDelMsgQue(msgque IMsgQue) {
+	msgque.Reconnect(10)
+}
+
+func Test_AppStopReconnecting(t *testing.T) {
+	for _, v := range [][2]string{{"tcp", "127.0.0.1:1"}, {"ws", "ws://127.0.0.1:1/ws"}} {
+		app := NewApp(nil)
+		handler := &reconnectTestHandler{cfail: make(chan struct{}, 1)}
+		app.StartConnect(v[0], v[1], MsgTypeMsg, handler, nil, nil)
+		select {
+		case <-handler.cfail:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%v wait connect fail timeout", v[0])
+		}
+		Sleep(100)
+
+		//等待重连的消息队列应该在停止时直接关闭，不需要等到超时
+		start := time.Now()
+		app.Stop()
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("stop app with reconnecting %v msgque took %v", v[0], d)
+		}
+		select {
+		case <-handler.cfail:
+			t.Fatalf("%v reconnect fired after app stop", v[0])
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func Test_AppDrainedWriting(t *testing.T) {
+	app := NewApp(nil)
+	msgque := newTcpConn(app, "tcp", "127.0.0.1:1", nil, MsgTypeMsg, &DefMsgHandler{}, nil, nil)
+	defer app.Stop()
+	defer msgque.Stop()
+	if !msgque.drained() {
+		t.Fatal("empty msgque should be drained")
+	}
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	if msgque.drained() {
+		t.Fatal("msgque with queued msg should not be drained")
+	}
+
+	//写协程已经取出消息但还没有写完
+	m := <-msgque.cwrite
+	if msgque.drained() {
+		t.Fatal("msgque with msg in flight should not be drained")
+	}
+	msgque.doneWriting(m)
+	if !msgque.drained() {
+		t.Fatal("msgque should be drained after write")
+	}
+}
+
+type appTestClient struct {
+	DefMsgHandler
+	cconn chan bool
+}
+
+func (r *appTestClient) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	r.cconn <- ok
+	return ok
+}
+
+func appTestEcho(t *testing.T, app *App, addr string) {
+	handler := &appTestClient{cconn: make(chan bool, 1)}
+	msgque := app.StartConnect("tcp", addr, MsgTypeMsg, handler, nil, nil)
+	defer msgque.Stop()
+	if !<-handler.cconn {
+		t.Fatalf("connect to %v failed", addr)
+	}
+	c := make(chan *Message, 1)
+	msgque.SendCallback(NewMsg(1, 1, 1, 0, []byte("echo")), c)
+	select {
+	case m := <-c:
+		if m == nil || string(m.Data) != "echo" {
+			t.Fatalf("bad echo from %v %v", addr, m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("echo from %v timeout", addr)
+	}
+}
+
+func Test_AppIndependent(t *testing.T) {
+	app1, app2 := NewApp(nil), NewApp(nil)
+	addr1 := testStartServer(t, app1, "tcp", MsgTypeMsg, &EchoMsgHandler{}, nil)
+	addr2 := testStartServer(t, app2, "tcp", MsgTypeMsg, &EchoMsgHandler{}, nil)
+	appTestEcho(t, app2, addr1)
+	appTestEcho(t, app1, addr2)
+
+	tm := app1.SetTimeout(60000, func(...interface{}) int { return 0 })
+	app1.Stop()
+	if !app1.IsStop() || !tm.IsStop() {
+		t.Fatal("app1 and its timers should be stopped")
+	}
+	if app2.IsStop() || DefApp.IsStop() {
+		t.Fatal("stop app1 should not stop other apps")
+	}
+	appTestEcho(t, app2, addr2)
+
+	//停止后可以在相同的地址上重新启动新的实例
+	app3 := NewApp(nil)
+	if err := app3.StartServer("tcp://"+addr1, MsgTypeMsg, &EchoMsgHandler{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	appTestEcho(t, app2, addr1)
+	app3.Stop()
+	app2.Stop()
+}
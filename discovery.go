@@ -0,0 +1,381 @@
+package antnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务信息，Net为tcp tls udp rudp ws wss，Addr为StartConnect使用的地址
+type ServiceInfo struct {
+	Type   string
+	Id     int
+	Net    string
+	Addr   string
+	Expire int64 `json:",omitempty"` //注册过期时间，单位秒，由注册中心维护
+}
+
+func (r *ServiceInfo) Key() string {
+	return fmt.Sprintf("%s:%d", r.Type, r.Id)
+}
+
+// 服务注册中心，Services返回当前所有存活的服务
+type IRegistry interface {
+	Register(info *ServiceInfo) error
+	Unregister(info *ServiceInfo) error
+	Services() ([]*ServiceInfo, error)
+}
+
+// 基于redis的注册中心，所有服务保存在一个hash里面，注册后需要在TTL秒内刷新，否则视为下线
+type RedisRegistry struct {
+	Key   string
+	TTL   int
+	redis *RedisManager
+}
+
+func NewRedisRegistry(redis *RedisManager) *RedisRegistry {
+	return &RedisRegistry{Key: "antnet:service", TTL: 10, redis: redis}
+}
+
+func (r *RedisRegistry) Register(info *ServiceInfo) error {
+	ninfo := *info
+	ninfo.Expire = timestamp() + int64(r.TTL)
+	data, err := json.Marshal(&ninfo)
+	if err != nil {
+		return ErrJsonPack
+	}
+	if err := r.redis.GetGlobal().HSet(r.Key, info.Key(), data).Err(); RedisError(err) {
+		LogError("redis registry register failed service:%v err:%v", info.Key(), err)
+		return ErrDBErr
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Unregister(info *ServiceInfo) error {
+	if err := r.redis.GetGlobal().HDel(r.Key, info.Key()).Err(); RedisError(err) {
+		LogError("redis registry unregister failed service:%v err:%v", info.Key(), err)
+		return ErrDBErr
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Services() ([]*ServiceInfo, error) {
+	m, err := r.redis.GetGlobal().HGetAll(r.Key).Result()
+	if RedisError(err) {
+		LogError("redis registry get services failed err:%v", err)
+		return nil, ErrDBErr
+	}
+	infos := make([]*ServiceInfo, 0, len(m))
+	for k, v := range m {
+		info := &ServiceInfo{}
+		if json.Unmarshal([]byte(v), info) != nil {
+			LogError("redis registry bad service:%v data:%v", k, v)
+			continue
+		}
+		if info.Expire < timestamp() {
+			r.expire(k, v)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// 只有值没有变化时才删除，避免删掉服务刚刚重新注册的数据
+var redisRegistryExpireScript = NewRedisScript("registry expire", `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (r *RedisRegistry) expire(key, data string) {
+	if _, err := r.redis.GetGlobal().ScriptInt64(redisRegistryExpireScript, []string{r.Key}, key, data); err != nil {
+		LogError("redis registry expire failed service:%v err:%v", key, err)
+	}
+}
+
+// 基于静态文件的注册中心，文件内容为ServiceInfo的json数组，每次获取时重新读取，修改文件即可增删服务
+type FileRegistry struct {
+	path string
+}
+
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{path: path}
+}
+
+func (r *FileRegistry) Register(info *ServiceInfo) error {
+	return nil
+}
+
+func (r *FileRegistry) Unregister(info *ServiceInfo) error {
+	return nil
+}
+
+func (r *FileRegistry) Services() ([]*ServiceInfo, error) {
+	infos := []*ServiceInfo{}
+	if err := ReadConfigFromJson(r.path, &infos); err != nil {
+		LogError("file registry read failed path:%v err:%v", r.path, err)
+		return nil, err
+	}
+	return infos, nil
+}
+
+type discoveryPeer struct {
+	info   *ServiceInfo
+	msgque IMsgQue
+}
+
+type discoveryType struct {
+	peers []*discoveryPeer
+	index uint32
+	ring  []uint32
+	nodes map[uint32]*discoveryPeer
+}
+
+// 服务发现，定时从注册中心拉取服务列表，自动连接关注的服务，断线后通过Reconnect自动重连
+type Discovery struct {
+	Interval int //刷新间隔，单位毫秒
+	Replicas int //一致性哈希每个服务的虚拟节点数
+
+	app      *App
+	registry IRegistry
+	self     *ServiceInfo
+	watch    map[string]bool
+	msgTyp   MsgType
+	handler  IMsgHandler
+	parser   IParserFactory
+
+	lock  sync.RWMutex
+	peers map[string]*discoveryPeer
+	types map[string]*discoveryType
+}
+
+// 创建服务发现，app为nil时使用DefApp，handler和parser用于连接其他服务
+func NewDiscovery(app *App, registry IRegistry, typ MsgType, handler IMsgHandler, parser IParserFactory) *Discovery {
+	if app == nil {
+		app = DefApp
+	}
+	return &Discovery{
+		Interval: 3000,
+		Replicas: 64,
+		app:      app,
+		registry: registry,
+		watch:    map[string]bool{},
+		msgTyp:   typ,
+		handler:  handler,
+		parser:   parser,
+		peers:    map[string]*discoveryPeer{},
+		types:    map[string]*discoveryType{},
+	}
+}
+
+// 注册自己，实例停止时自动注销
+func (r *Discovery) Register(self *ServiceInfo) {
+	r.self = self
+}
+
+// 关注的服务类型，只会连接这些类型的服务
+func (r *Discovery) Watch(types ...string) {
+	for _, v := range types {
+		r.watch[v] = true
+	}
+}
+
+func (r *Discovery) Start() {
+	r.refresh()
+	r.app.Go2(func(cstop chan struct{}) {
+		ticker := time.NewTicker(time.Millisecond * time.Duration(r.Interval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cstop:
+				if r.self != nil {
+					r.registry.Unregister(r.self)
+				}
+				return
+			case <-ticker.C:
+				r.refresh()
+			}
+		}
+	})
+}
+
+func (r *Discovery) refresh() {
+	if r.self != nil {
+		r.registry.Register(r.self)
+	}
+	infos, err := r.registry.Services()
+	if err != nil {
+		return
+	}
+
+	alive := map[string]*ServiceInfo{}
+	for _, v := range infos {
+		if !r.watch[v.Type] || (r.self != nil && v.Key() == r.self.Key()) {
+			continue
+		}
+		alive[v.Key()] = v
+	}
+
+	var adds, dels []*discoveryPeer
+	r.lock.Lock()
+	for k, p := range r.peers {
+		if info, ok := alive[k]; !ok || info.Net != p.info.Net || info.Addr != p.info.Addr {
+			delete(r.peers, k)
+			dels = append(dels, p)
+		}
+	}
+	for k, v := range alive {
+		if _, ok := r.peers[k]; !ok {
+			p := &discoveryPeer{info: v}
+			r.peers[k] = p
+			adds = append(adds, p)
+		}
+	}
+	r.lock.Unlock()
+
+	for _, p := range dels {
+		LogInfo("discovery service removed service:%v addr:%v://%v", p.info.Key(), p.info.Net, p.info.Addr)
+		if p.msgque != nil {
+			p.msgque.Stop()
+		}
+	}
+	for _, p := range adds {
+		LogInfo("discovery service added service:%v addr:%v://%v", p.info.Key(), p.info.Net, p.info.Addr)
+		msgque := r.app.StartConnect(p.info.Net, p.info.Addr, r.msgTyp, &discoveryHandler{r.handler, r, p}, r.parser, nil)
+		r.lock.Lock()
+		p.msgque = msgque
+		r.lock.Unlock()
+	}
+	if len(adds) > 0 || len(dels) > 0 {
+		r.rebuild()
+	}
+}
+
+func (r *Discovery) rebuild() {
+	types := map[string]*discoveryType{}
+	r.lock.Lock()
+	for _, p := range r.peers {
+		if p.msgque == nil {
+			continue
+		}
+		t, ok := types[p.info.Type]
+		if !ok {
+			t = &discoveryType{nodes: map[uint32]*discoveryPeer{}}
+			types[p.info.Type] = t
+		}
+		t.peers = append(t.peers, p)
+		for i := 0; i < r.Replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", p.info.Key(), i)))
+			if _, ok := t.nodes[h]; !ok {
+				t.nodes[h] = p
+				t.ring = append(t.ring, h)
+			}
+		}
+	}
+	for _, t := range types {
+		sort.Slice(t.peers, func(i, j int) bool { return t.peers[i].info.Id < t.peers[j].info.Id })
+		sort.Slice(t.ring, func(i, j int) bool { return t.ring[i] < t.ring[j] })
+	}
+	r.types = types
+	r.lock.Unlock()
+}
+
+// 获取服务的消息队列，服务不存在返回nil
+func (r *Discovery) Get(typ string, id int) IMsgQue {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if p, ok := r.peers[(&ServiceInfo{Type: typ, Id: id}).Key()]; ok {
+		return p.msgque
+	}
+	return nil
+}
+
+// 轮询选择一个可用的服务
+func (r *Discovery) GetByRoundRobin(typ string) IMsgQue {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	t, ok := r.types[typ]
+	if !ok {
+		return nil
+	}
+	for i := 0; i < len(t.peers); i++ {
+		p := t.peers[atomic.AddUint32(&t.index, 1)%uint32(len(t.peers))]
+		if p.msgque.Available() {
+			return p.msgque
+		}
+	}
+	return nil
+}
+
+// 一致性哈希选择服务，相同的key总是落到同一个服务上，服务不可用时顺延到下一个
+func (r *Discovery) GetByHash(typ string, key string) IMsgQue {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	t, ok := r.types[typ]
+	if !ok || len(t.ring) == 0 {
+		return nil
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	index := sort.Search(len(t.ring), func(i int) bool { return t.ring[i] >= h })
+	for i := 0; i < len(t.ring); i++ {
+		p := t.nodes[t.ring[(index+i)%len(t.ring)]]
+		if p.msgque.Available() {
+			return p.msgque
+		}
+	}
+	return nil
+}
+
+func (r *Discovery) Send(typ string, m *Message) error {
+	if msgque := r.GetByRoundRobin(typ); msgque != nil && msgque.Send(m) {
+		return nil
+	}
+	return ErrServiceNotFound
+}
+
+func (r *Discovery) SendByHash(typ string, key string, m *Message) error {
+	if msgque := r.GetByHash(typ, key); msgque != nil && msgque.Send(m) {
+		return nil
+	}
+	return ErrServiceNotFound
+}
+
+// 发给某个类型的所有服务
+func (r *Discovery) SendAll(typ string, m *Message) {
+	r.lock.RLock()
+	t, ok := r.types[typ]
+	r.lock.RUnlock()
+	if !ok {
+		return
+	}
+	for _, p := range t.peers {
+		p.msgque.Send(m)
+	}
+}
+
+type discoveryHandler struct {
+	IMsgHandler
+	discovery *Discovery
+	peer      *discoveryPeer
+}
+
+func (r *discoveryHandler) Unwrap() IMsgHandler {
+	return r.IMsgHandler
+}
+
+// 服务还在注册中心时断线重连
+func (r *discoveryHandler) OnDelMsgQue(msgque IMsgQue) {
+	r.IMsgHandler.OnDelMsgQue(msgque)
+	r.discovery.lock.RLock()
+	p, ok := r.discovery.peers[r.peer.info.Key()]
+	r.discovery.lock.RUnlock()
+	if ok && p == r.peer {
+		msgque.Reconnect(1)
+	}
+}
@@ -0,0 +1,122 @@
+package antnet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_FileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+	r := NewFileRegistry(path)
+	if _, err := r.Services(); err == nil {
+		t.Fatal("missing file should fail")
+	}
+
+	data, _ := json.Marshal([]*ServiceInfo{{Type: "game", Id: 1, Net: "tcp", Addr: "127.0.0.1:6666"}, {Type: "game", Id: 2, Net: "ws", Addr: "ws://127.0.0.1:6667"}})
+	ioutil.WriteFile(path, data, 0644)
+	infos, err := r.Services()
+	if err != nil || len(infos) != 2 || infos[0].Key() != "game:1" || infos[1].Addr != "ws://127.0.0.1:6667" {
+		t.Fatalf("bad services %v err:%v", infos, err)
+	}
+}
+
+// 需要设置ANTNET_TEST_REDIS为redis地址才会运行
+func Test_RedisRegistry(t *testing.T) {
+	addr := os.Getenv("ANTNET_TEST_REDIS")
+	if addr == "" {
+		t.Skip("ANTNET_TEST_REDIS not set")
+	}
+	r := NewRedisRegistry(NewRedisManager(&RedisConfig{Addr: addr}))
+	r.Key = "antnet:test:service"
+	db := r.redis.GetGlobal()
+	db.Del(r.Key)
+	defer db.Del(r.Key)
+
+	info := &ServiceInfo{Type: "game", Id: 1, Net: "tcp", Addr: "127.0.0.1:6666"}
+	if err := r.Register(info); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := r.Services(); err != nil || len(infos) != 1 || infos[0].Key() != info.Key() {
+		t.Fatalf("bad services %v err:%v", infos, err)
+	}
+
+	//过期的数据在读取时删除
+	old := *info
+	old.Expire = timestamp() - 1
+	data, _ := json.Marshal(&old)
+	db.HSet(r.Key, info.Key(), data)
+	if infos, _ := r.Services(); len(infos) != 0 || db.HExists(r.Key, info.Key()).Val() {
+		t.Fatalf("expired service not removed %v", infos)
+	}
+
+	//读取后服务重新注册，不能删除新的数据
+	r.Register(info)
+	r.expire(info.Key(), string(data))
+	if infos, _ := r.Services(); len(infos) != 1 {
+		t.Fatal("re-registered service removed")
+	}
+	r.Unregister(info)
+	if infos, _ := r.Services(); len(infos) != 0 {
+		t.Fatal("unregister failed")
+	}
+}
+
+type discoveryTestHandler struct {
+	DefMsgHandler
+}
+
+func Test_DiscoveryMiddleware(t *testing.T) {
+	server := NewApp(nil)
+	defer server.Stop()
+	addr := testStartServer(t, server, "tcp", MsgTypeMsg, &EchoMsgHandler{}, nil)
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+	data, _ := json.Marshal([]*ServiceInfo{{Type: "echo", Id: 1, Net: "tcp", Addr: addr}})
+	ioutil.WriteFile(path, data, 0644)
+
+	c := make(chan string, 2)
+	handler := &discoveryTestHandler{}
+	handler.Use(func(msgque IMsgQue, msg *Message, next HandlerFunc) bool {
+		c <- "middleware"
+		return next(msgque, msg)
+	})
+	handler.Register(1, 2, func(msgque IMsgQue, msg *Message) bool {
+		c <- string(msg.Data)
+		return true
+	})
+
+	app := NewApp(nil)
+	defer app.Stop()
+	d := NewDiscovery(app, NewFileRegistry(path), MsgTypeMsg, handler, nil)
+	d.Watch("echo")
+	d.Start()
+	for i := 0; i < 100 && (d.Get("echo", 1) == nil || !d.Get("echo", 1).Available()); i++ {
+		Sleep(10)
+	}
+	if err := d.Send("echo", NewMsg(1, 2, 0, 0, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"middleware", "hello"} {
+		select {
+		case got := <-c:
+			if got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("wait %v timeout", want)
+		}
+	}
+}
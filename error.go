@@ -41,12 +41,26 @@ var (
 	ErrGobUnPack      = NewError("gob解析错误", 16)
 	ErrServePanic     = NewError("服务器内部错误", 17)
 	ErrNeedIntraNet   = NewError("需要内网环境", 18)
+	ErrMsgEncrypt     = NewError("消息加密错误", 19)
+	ErrMsgDecrypt     = NewError("消息解密错误", 20)
+	ErrMsgUnCompress  = NewError("消息解压错误", 21)
+	ErrMsgRateLimit   = NewError("消息发送过快", 22)
+	ErrTimerCron      = NewError("定时器cron表达式错误", 23)
 	ErrConfigPath     = NewError("配置路径错误", 50)
 
-	ErrFileRead       = NewError("文件读取错误", 100)
-	ErrDBDataType     = NewError("数据库数据类型错误", 101)
-	ErrNetTimeout     = NewError("网络超时", 200)
-	ErrNetUnreachable = NewError("网络不可达", 201)
+	ErrFileRead        = NewError("文件读取错误", 100)
+	ErrDBDataType      = NewError("数据库数据类型错误", 101)
+	ErrNetTimeout      = NewError("网络超时", 200)
+	ErrNetUnreachable  = NewError("网络不可达", 201)
+	ErrRudpData        = NewError("可靠udp数据错误", 202)
+	ErrNetClosed       = NewError("网络连接已关闭", 203)
+	ErrServiceNotFound = NewError("没有可用的服务", 204)
+	ErrProxyProtocol   = NewError("PROXY协议头错误", 205)
+	ErrWsPathConflict  = NewError("websocket路径冲突", 206)
+	ErrSendQueueFull   = NewError("发送队列已满", 207)
+	ErrSendDropOldest  = NewError("发送队列已满，丢弃了最早的消息", 208)
+	ErrSlowConsumer    = NewError("对方接收过慢，连接已关闭", 209)
+	ErrActorFull       = NewError("Actor邮箱已满", 210)
 
 	ErrClientReserve = NewError("客户端保留，服务器任何情况不会下发这个错误", 254)
 	ErrErrIdNotFound = NewError("错误没有对应的错误码", 255)
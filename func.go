@@ -20,52 +20,22 @@ import (
 )
 
 func AddStopCheck(cs string) uint64 {
-	id := atomic.AddUint64(&stopCheckIndex, 1)
-	if id == 0 {
-		id = atomic.AddUint64(&stopCheckIndex, 1)
-	}
-	stopCheckMap.Lock()
-	stopCheckMap.M[id] = cs
-	stopCheckMap.Unlock()
-	return id
+	return DefApp.AddStopCheck(cs)
 }
 
 func RemoveStopCheck(id uint64) {
-	stopCheckMap.Lock()
-	delete(stopCheckMap.M, id)
-	stopCheckMap.Unlock()
+	DefApp.RemoveStopCheck(id)
 }
 
 func AtExit(fun func()) {
-	id := atomic.AddUint32(&atexitId, 1)
-	if id == 0 {
-		id = atomic.AddUint32(&atexitId, 1)
-	}
-
-	atexitMapSync.Lock()
-	atexitMap[id] = fun
-	atexitMapSync.Unlock()
+	DefApp.AtExit(fun)
 }
 
 func stopServer() {
-	if !atomic.CompareAndSwapInt32(&stop, 0, 1) {
+	if !DefApp.shutdown() {
 		return
 	}
 
-	close(stopChanForGo)
-	for sc := 0; !waitAll.TryWait(); sc++ {
-		Sleep(1)
-		if sc >= Config.StopTimeout {
-			LogError("Server Stop Timeout")
-			stopCheckMap.Lock()
-			for _, v := range stopCheckMap.M {
-				LogError("Server Stop Timeout:%v", v)
-			}
-			stopCheckMap.Unlock()
-			break
-		}
-	}
-
 	Try(func() {
 		close(stopChanForSys)
 		LogInfo("Server Stop From Ctrl+C")
@@ -79,11 +49,15 @@ func Stop() {
 }
 
 func IsStop() bool {
-	return stop == 1
+	return DefApp.IsStop()
+}
+
+func IsDraining() bool {
+	return DefApp.IsDraining()
 }
 
 func IsRuning() bool {
-	return stop == 0
+	return DefApp.IsRuning()
 }
 
 func CmdAct(cmd, act uint8) int {
@@ -126,11 +100,7 @@ func WaitForSystemExit(atexit ...func()) {
 			v()
 		}
 	}
-	atexitMapSync.Lock()
-	for _, v := range atexitMap {
-		v()
-	}
-	atexitMapSync.Unlock()
+	DefApp.runAtExit()
 	for _, v := range redisManagers {
 		v.close()
 	}
@@ -176,9 +146,10 @@ func Daemon(skip ...string) {
 }
 
 func GetStatis() *Statis {
-	statis.GoCount = int(gocount)
-	statis.MsgqueCount = len(msgqueMap)
-	statis.PoolGoCount = poolGoCount
+	s := DefApp.GetStatis()
+	statis.GoCount = s.GoCount
+	statis.MsgqueCount = s.MsgqueCount
+	statis.PoolGoCount = s.PoolGoCount
 	return statis
 }
 
@@ -253,7 +224,7 @@ func Try(fun func(), handler func(interface{})) {
 				handler(err)
 			}
 			atomic.AddInt32(&statis.PanicCount, 1)
-			statis.LastPanic = int(Timestamp)
+			statis.LastPanic = int(timestamp())
 		}
 	}()
 	fun()
@@ -268,7 +239,7 @@ func Try2(fun func(), handler func(interface{})) {
 				handler(err)
 			}
 			atomic.AddInt32(&statis.PanicCount, 1)
-			statis.LastPanic = int(Timestamp)
+			statis.LastPanic = int(timestamp())
 		}
 	}()
 	fun()
@@ -443,7 +414,7 @@ func RandBetween(min, max int) int {
 	}
 
 	randIndex++
-	r := rand.New(rand.NewSource(int64(randIndex) + Timestamp))
+	r := rand.New(rand.NewSource(int64(randIndex) + timestamp()))
 	random := r.Intn(max-min) + min
 	return random
 }
@@ -477,7 +448,7 @@ func RandSliceBetween(min, max, count int) []int {
 		return retSlice
 	}
 	randIndex++
-	r := rand.New(rand.NewSource(int64(randIndex) + Timestamp))
+	r := rand.New(rand.NewSource(int64(randIndex) + timestamp()))
 	random := r.Intn(randomRange) + min
 	baseRand := RandBetween(random*min, random*max)
 	retSlice = append(retSlice, random)
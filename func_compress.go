@@ -10,6 +10,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
 func ZlibCompress(data []byte) []byte {
@@ -20,15 +25,26 @@ func ZlibCompress(data []byte) []byte {
 	return in.Bytes()
 }
 
+// 解压后的长度不能超过MaxMsgDataSize，多读一个字节用来判断是否超长，防止恶意数据申请过大的内存
+func readUnCompress(r io.Reader) ([]byte, error) {
+	undatas, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxMsgDataSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(undatas) > int(MaxMsgDataSize) {
+		return nil, ErrMsgLenTooLong
+	}
+	return undatas, nil
+}
+
 func ZlibUnCompress(data []byte) ([]byte, error) {
 	b := bytes.NewReader(data)
-	r, _ := zlib.NewReader(b)
-	defer r.Close()
-	undatas, err := ioutil.ReadAll(r)
+	r, err := zlib.NewReader(b)
 	if err != nil {
 		return nil, err
 	}
-	return undatas, nil
+	defer r.Close()
+	return readUnCompress(r)
 }
 
 func GZipCompress(data []byte) []byte {
@@ -41,12 +57,72 @@ func GZipCompress(data []byte) []byte {
 
 func GZipUnCompress(data []byte) ([]byte, error) {
 	b := bytes.NewReader(data)
-	r, _ := gzip.NewReader(b)
+	r, err := gzip.NewReader(b)
+	if err != nil {
+		return nil, err
+	}
 	defer r.Close()
-	undatas, err := ioutil.ReadAll(r)
+	return readUnCompress(r)
+}
+
+func SnappyCompress(data []byte) []byte {
+	return snappy.Encode(nil, data)
+}
+
+// 解压前先检查解压后的长度，防止恶意数据申请过大的内存
+func SnappyUnCompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
 	if err != nil {
 		return nil, err
 	}
+	if n > int(MaxMsgDataSize) {
+		return nil, ErrMsgLenTooLong
+	}
+	return snappy.Decode(nil, data)
+}
+
+func S2Compress(data []byte) []byte {
+	return s2.Encode(nil, data)
+}
+
+func S2UnCompress(data []byte) ([]byte, error) {
+	n, err := s2.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > int(MaxMsgDataSize) {
+		return nil, ErrMsgLenTooLong
+	}
+	return s2.Decode(nil, data)
+}
+
+var zstdOnce sync.Once
+var zstdEncoder *zstd.Encoder
+var zstdDecoder *zstd.Decoder
+
+func zstdInit() {
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxMsgDataSize)))
+}
+
+func ZstdCompress(data []byte) []byte {
+	zstdOnce.Do(zstdInit)
+	return zstdEncoder.EncodeAll(data, nil)
+}
+
+// 解码器在第一次使用时按MaxMsgDataSize限制内存，之后修改了MaxMsgDataSize也按当前值检查长度
+func ZstdUnCompress(data []byte) ([]byte, error) {
+	zstdOnce.Do(zstdInit)
+	undatas, err := zstdDecoder.DecodeAll(data, nil)
+	if err == zstd.ErrDecoderSizeExceeded {
+		return nil, ErrMsgLenTooLong
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(undatas) > int(MaxMsgDataSize) {
+		return nil, ErrMsgLenTooLong
+	}
 	return undatas, nil
 }
 
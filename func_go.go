@@ -5,62 +5,22 @@ import (
 )
 
 func Go(fn func()) {
-	pc := Config.PoolSize + 1
-	select {
-	case poolChan <- fn:
-		return
-	default:
-		pc = atomic.AddInt32(&poolGoCount, 1)
-		if pc > Config.PoolSize {
-			atomic.AddInt32(&poolGoCount, -1)
-		}
-	}
-
-	waitAll.Add(1)
-	var debugStr string
-	id := atomic.AddUint32(&goid, 1)
-	c := atomic.AddInt32(&gocount, 1)
-	if DefLog.Level() <= LogLevelDebug {
-		debugStr = LogSimpleStack()
-		LogTrace("goroutine start id:%d count:%d from:%s", id, c, debugStr)
-	}
-	go func() {
-		Try(fn, nil)
-		for pc <= Config.PoolSize {
-			select {
-			case <-stopChanForGo:
-				pc = Config.PoolSize + 1
-			case nfn := <-poolChan:
-				Try(nfn, nil)
-			}
-		}
-
-		waitAll.Done()
-		c = atomic.AddInt32(&gocount, -1)
-
-		if DefLog.Level() <= LogLevelDebug {
-			LogTrace("goroutine end id:%d count:%d from:%s", id, c, debugStr)
-		}
-	}()
+	DefApp.Go(fn)
 }
 
 func Go2(fn func(cstop chan struct{})) {
-	Go(func() {
-		fn(stopChanForGo)
-	})
+	DefApp.Go2(fn)
 }
 
 func GoArgs(fn func(...interface{}), args ...interface{}) {
-	Go(func() {
-		fn(args...)
-	})
+	DefApp.GoArgs(fn, args...)
 }
 
 func goForRedis(fn func()) {
 	waitAllForRedis.Add(1)
 	var debugStr string
 	id := atomic.AddUint32(&goid, 1)
-	c := atomic.AddInt32(&gocount, 1)
+	c := atomic.AddInt32(&DefApp.gocount, 1)
 	if DefLog.Level() <= LogLevelDebug {
 		debugStr = LogSimpleStack()
 		LogTrace("goroutine start id:%d count:%d from:%s", id, c, debugStr)
@@ -68,7 +28,7 @@ func goForRedis(fn func()) {
 	go func() {
 		Try(fn, nil)
 		waitAllForRedis.Done()
-		c = atomic.AddInt32(&gocount, -1)
+		c = atomic.AddInt32(&DefApp.gocount, -1)
 
 		if DefLog.Level() <= LogLevelDebug {
 			LogTrace("goroutine end id:%d count:%d from:%s", id, c, debugStr)
@@ -77,7 +37,7 @@ func goForRedis(fn func()) {
 }
 
 func goForLog(fn func(cstop chan struct{})) bool {
-	if IsStop() {
+	if isLogStop() {
 		return false
 	}
 	waitAllForLog.Add(1)
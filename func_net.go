@@ -13,24 +13,11 @@ import (
 )
 
 func Send(msg *Message, fun func(msgque IMsgQue) bool) {
-	if msg == nil {
-		return
-	}
-	c := make(chan struct{})
-	gmsgMapSync.Lock()
-	gmsg := gmsgArray[gmsgId]
-	gmsgArray[gmsgId+1] = &gMsg{c: c}
-	gmsgId++
-	gmsgMapSync.Unlock()
-	gmsg.msg = msg
-	gmsg.fun = fun
-	close(gmsg.c)
+	DefApp.Send(msg, fun)
 }
 
 func SendGroup(group string, msg *Message) {
-	Send(msg, func(msgque IMsgQue) bool {
-		return msgque.IsInGroup(group)
-	})
+	DefApp.SendGroup(group, msg)
 }
 
 func HttpGetWithBasicAuth(url, name, passwd string) (string, error, *http.Response) {
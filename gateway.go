@@ -0,0 +1,159 @@
+package antnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 网关，作为客户端消息队列的处理器，通过少量tcp连接把客户端转发到后端
+// 后端需要对网关的连接调用SetProxyTrusted，每个客户端在后端是一个普通的消息队列，RemoteAddr为客户端的真实地址
+// 网关上注册了处理函数的消息在网关处理，其他消息转发到后端
+type Gateway struct {
+	DefMsgHandler
+	app      *App
+	links    []*gatewayLink
+	index    uint32
+	lock     sync.RWMutex
+	sessions map[uint32]*gatewaySession
+}
+
+type gatewaySession struct {
+	client IMsgQue
+	link   *gatewayLink
+}
+
+type gatewayLink struct {
+	DefMsgHandler
+	gateway *Gateway
+	msgque  IMsgQue
+}
+
+// 创建网关，app为nil时使用DefApp
+func NewGateway(app *App) *Gateway {
+	if app == nil {
+		app = DefApp
+	}
+	return &Gateway{app: app, sessions: map[uint32]*gatewaySession{}}
+}
+
+// 添加后端，netType为tcp或者tls，连接断开后自动重连，断开时这个连接上的客户端都会被关闭
+func (r *Gateway) AddBackend(netType, addr string) IMsgQue {
+	link := &gatewayLink{gateway: r}
+	r.lock.Lock()
+	r.links = append(r.links, link)
+	r.lock.Unlock()
+	msgque := r.app.StartConnect(netType, addr, MsgTypeMsg, link, nil, nil)
+	r.lock.Lock()
+	link.msgque = msgque
+	r.lock.Unlock()
+	return msgque
+}
+
+func (r *Gateway) selectLink() *gatewayLink {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for i := 0; i < len(r.links); i++ {
+		link := r.links[atomic.AddUint32(&r.index, 1)%uint32(len(r.links))]
+		if link.msgque != nil && link.msgque.Available() {
+			return link
+		}
+	}
+	return nil
+}
+
+func (r *Gateway) getSession(id uint32) *gatewaySession {
+	r.lock.RLock()
+	s := r.sessions[id]
+	r.lock.RUnlock()
+	return s
+}
+
+func (r *Gateway) delSession(id uint32) *gatewaySession {
+	r.lock.Lock()
+	s := r.sessions[id]
+	delete(r.sessions, id)
+	r.lock.Unlock()
+	return s
+}
+
+// 客户端的数量
+func (r *Gateway) SessionCount() int {
+	r.lock.RLock()
+	n := len(r.sessions)
+	r.lock.RUnlock()
+	return n
+}
+
+func (r *Gateway) OnNewMsgQue(msgque IMsgQue) bool {
+	link := r.selectLink()
+	if link == nil {
+		r.app.LogWarn("gateway no backend available msgque:%v", msgque.Id())
+		return false
+	}
+	r.lock.Lock()
+	r.sessions[msgque.Id()] = &gatewaySession{client: msgque, link: link}
+	r.lock.Unlock()
+	data := append([]byte{byte(msgque.GetNetType())}, msgque.RemoteAddr()...)
+	return link.msgque.Send(newProxyMsg(msgque.Id(), proxyOpen, data))
+}
+
+func (r *Gateway) OnDelMsgQue(msgque IMsgQue) {
+	if s := r.delSession(msgque.Id()); s != nil {
+		s.link.msgque.Send(newProxyMsg(msgque.Id(), proxyClose, nil))
+	}
+}
+
+func (r *Gateway) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	s := r.getSession(msgque.Id())
+	if s == nil {
+		return false
+	}
+	return s.link.msgque.Send(newProxyDataMsg(msgque.Id(), msg))
+}
+
+// 后端发来的消息
+func (r *gatewayLink) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	if msg.Head == nil || msg.Head.Flags&FlagProxy == 0 {
+		r.gateway.app.LogWarn("gateway recv msg without proxy flag link:%v cmd:%v act:%v", msgque.Id(), msg.Cmd(), msg.Act())
+		return true
+	}
+	session, kind, data, err := parseProxyMsg(msg)
+	if err != nil {
+		r.gateway.app.LogError("gateway bad proxy msg link:%v err:%v", msgque.Id(), err)
+		return false
+	}
+	switch kind {
+	case proxyData:
+		s := r.gateway.getSession(session)
+		if s == nil {
+			return true
+		}
+		m, err := parseProxyDataMsg(data)
+		if err != nil {
+			r.gateway.app.LogError("gateway bad proxy data link:%v session:%v err:%v", msgque.Id(), session, err)
+			return true
+		}
+		s.client.Send(m)
+	case proxyClose:
+		if s := r.gateway.delSession(session); s != nil {
+			s.client.Stop()
+		}
+	}
+	return true
+}
+
+func (r *gatewayLink) OnDelMsgQue(msgque IMsgQue) {
+	var clients []IMsgQue
+	r.gateway.lock.Lock()
+	for k, v := range r.gateway.sessions {
+		if v.link == r {
+			clients = append(clients, v.client)
+			delete(r.gateway.sessions, k)
+		}
+	}
+	r.gateway.lock.Unlock()
+	for _, v := range clients {
+		v.Stop()
+	}
+	msgque.Reconnect(1)
+}
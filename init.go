@@ -41,20 +41,15 @@ func (r *WaitGroup) TryWait() bool {
 	return atomic.LoadInt64(&r.count) == 0
 }
 
-var waitAll = &WaitGroup{} //等待所有goroutine
 var waitAllForLog sync.WaitGroup
 var waitAllForRedis sync.WaitGroup
 
 var stopForLog int32 //
-var stop int32       //停止标志
 
-var gocount int32 //goroutine数量
 var goid uint32
 var DefLog *Log //日志
 
 var msgqueId uint32 //消息队列id
-var msgqueMapSync sync.Mutex
-var msgqueMap = map[uint32]IMsgQue{}
 
 type gMsg struct {
 	c   chan struct{}
@@ -62,47 +57,46 @@ type gMsg struct {
 	fun func(msgque IMsgQue) bool
 }
 
-var gmsgId uint16
-var gmsgMapSync sync.Mutex
-var gmsgArray = [65536]*gMsg{}
-
-var atexitId uint32
-var atexitMapSync sync.Mutex
-var atexitMap = map[uint32]func(){}
-
-var stopChanForGo = make(chan struct{})
 var stopChanForLog = make(chan struct{})
 var stopChanForSys = make(chan os.Signal, 1)
 
-var poolChan = make(chan func())
-var poolGoCount int32
-
 var StartTick int64
 var NowTick int64
 var Timestamp int64   // 当前秒数
 var TimeString string // 当前时间 格式：2020-7-9 14:59:15
 var randIndex uint32 = 0
 var Config = struct {
-	AutoCompressLen uint32
-	UdpServerGoCnt  int
-	PoolSize        int32
-	SSLCrtPath      string
-	SSLKeyPath      string
-	EnableWss       bool
-	ReadDataBuffer  int
-	StopTimeout     int
-}{UdpServerGoCnt: 64, PoolSize: 50000, ReadDataBuffer: 1 << 12, StopTimeout: 3000}
-
-var stopCheckIndex uint64
-var stopCheckMap = struct {
-	sync.Mutex
-	M map[uint64]string
-}{M: map[uint64]string{}}
+	AutoCompressLen   uint32
+	CompressCodec     CompressCodec //自动压缩使用的算法
+	UdpServerGoCnt    int
+	PoolSize          int32
+	SSLCrtPath        string
+	SSLKeyPath        string
+	SSLClientCaPath   string //设置后tls监听会用这个CA校验客户端证书
+	SSLRootCaPath     string //tls连接用这个CA校验服务器证书，为空则使用系统的根证书
+	SSLReloadInterval int    //检查证书文件是否修改的间隔，单位毫秒，0按1000处理
+	SSLServerName     string //tls连接校验的服务器名，为空则使用连接地址
+	SSLInsecure       bool   //tls连接不校验服务器证书
+	EnableWss         bool
+	ReadDataBuffer    int
+	StopTimeout       int
+	DrainTimeout      int        //停止时等待消息队列发送完毕的最长时间，单位毫秒
+	EnableMetrics     bool       //开启按网络类型和消息号的流量统计
+	RateLimit         RateLimit  //Accept产生的消息队列默认的限流参数
+	ProxyProtocol     bool       //tcp tls ws wss监听解析PROXY protocol v1/v2头，使用其中的地址作为客户端地址
+	TrustForwarded    bool       //ws wss监听使用X-Forwarded-For或X-Real-IP作为客户端地址，只应该在反向代理后面开启
+	ForwardedHops     int        //反向代理的层数，使用X-Forwarded-For从右边数第几个地址，0按1处理
+	HeartbeatInterval int        //默认的心跳间隔，单位毫秒，0表示不开启
+	HeartbeatMiss     int        //默认连续多少次没有收到心跳回复断开连接
+	SendPolicy        SendPolicy //消息队列默认的发送策略
+	SendTimeout       int        //SendPolicyTimeout默认的超时时间，单位毫秒
+	KeyExchangePSK    []byte     //密钥交换使用的预共享密钥，参与会话密钥的推导，双方不一致时加密消息无法解密
+}{UdpServerGoCnt: 64, PoolSize: 50000, ReadDataBuffer: 1 << 12, StopTimeout: 3000, DrainTimeout: 3000, HeartbeatMiss: 3}
 
 func init() {
-	gmsgArray[gmsgId] = &gMsg{c: make(chan struct{})}
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	DefLog = NewLog(10000, &ConsoleLogger{true})
 	DefLog.SetLevel(LogLevelInfo)
+	DefApp = NewApp(DefLog)
 	timerTick()
 }
@@ -2,10 +2,6 @@ package antnet
 
 import (
 	"fmt"
-	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"sync/atomic"
@@ -28,103 +24,6 @@ func (r *ConsoleLogger) Write(str string) {
 	}
 }
 
-type OnFileLogFull func(path string)
-type OnFileLogTimeout func(path string) int
-type OnFileRename func(dirName, fileName, extName string) string
-type FileLogger struct {
-	Path         string
-	Ln           bool
-	Timeout      int //0表示不设置, 单位s
-	MaxSize      int //0表示不限制，最大大小
-	OnFull       OnFileLogFull
-	OnTimeout    OnFileLogTimeout
-	OnRenameFile OnFileRename
-
-	size     int
-	file     *os.File
-	filename string
-	extname  string
-	dirname  string
-}
-
-func (r *FileLogger) Write(str string) {
-	if r.file == nil {
-		return
-	}
-
-	newsize := r.size
-	if r.Ln {
-		newsize += len(str) + 1
-	} else {
-		newsize += len(str)
-	}
-
-	if r.MaxSize > 0 && newsize >= r.MaxSize {
-		r.file.Close()
-		r.file = nil
-		newpath := r.dirname + "/" + r.filename + fmt.Sprintf("_%v", Date()) + r.extname
-		if r.OnRenameFile != nil {
-			newpath = r.OnRenameFile(r.dirname+"/", r.filename, r.extname)
-		}
-		os.Rename(r.Path, newpath)
-		file, err := os.OpenFile(r.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
-		if err == nil {
-			r.file = file
-		}
-		r.size = 0
-		if r.OnFull != nil {
-			r.OnFull(newpath)
-		}
-	}
-
-	if r.file == nil {
-		return
-	}
-
-	if r.Ln {
-		r.file.WriteString(str)
-		r.file.WriteString("\n")
-		r.size += len(str) + 1
-	} else {
-		r.file.WriteString(str)
-		r.size += len(str)
-	}
-}
-
-type HttpLogger struct {
-	Url     string
-	Get     bool
-	GetKey  string
-	Timeout int
-}
-
-func (r *HttpLogger) Write(str string) {
-	if r.Url == "" {
-		return
-	}
-	Go(func() {
-		if r.Timeout == 0 {
-			r.Timeout = 5
-		}
-		c := http.Client{
-			Timeout: time.Duration(r.Timeout) * time.Second,
-		}
-		var resp *http.Response
-		if r.Get {
-			if r.GetKey == "" {
-				r.GetKey = "log"
-			}
-			resp, _ = c.Get(r.Url + "/?" + r.GetKey + "=" + str)
-		} else {
-			resp, _ = c.Post(r.Url, "application/x-www-form-urlencoded", strings.NewReader(str))
-		}
-
-		if resp != nil && resp.Body != nil {
-			resp.Body.Close()
-		}
-	})
-}
-
 type LogLevel int
 
 const (
@@ -147,9 +46,51 @@ var LogLevelNameMap = map[string]LogLevel{
 	"off":   LogLevelAllOff,
 }
 
+// 日志过滤函数，返回true表示输出
+type LogFilter func(e *LogEntry) bool
+
+type logSink struct {
+	level    LogLevel
+	filter   LogFilter
+	reported int64 //已经报告过的丢弃数量
+}
+
+// 日志缓冲区满时的处理方式
+type LogOverflow int
+
+const (
+	LogOverflowBlock      LogOverflow = iota //阻塞直到写入，默认
+	LogOverflowDropNewest                    //丢弃新的日志
+	LogOverflowDropOldest                    //丢弃缓冲区中最早的日志
+	LogOverflowSample                        //每sample条保留一条，保留的阻塞写入，其余丢弃
+)
+
+// 会丢弃日志的输出实现这个接口，丢弃的数量会被定时报告
+type logDropper interface {
+	Dropped() int64
+}
+
+// 输出在Log停止，写完所有日志后调用
+type logCloser interface {
+	close()
+}
+
+func (r *logSink) accept(e *LogEntry) (ok bool) {
+	if r.level == LogLevelAllOff || e.Level < r.level {
+		return false
+	}
+	if r.filter == nil {
+		return true
+	}
+	defer func() { recover() }()
+	return r.filter(e)
+}
+
 type Log struct {
 	logger         [32]ILogger
-	cwrite         chan string
+	sinks          [32]logSink
+	cwrite         chan *LogEntry
+	encoder        ILogEncoder
 	ctimeout       chan *FileLogger
 	bufsize        int
 	stop           int32
@@ -157,36 +98,26 @@ type Log struct {
 	loggerCount    int32
 	level          LogLevel
 	callStackCnt   int
+	overflow       LogOverflow
+	sample         int64
+	sampleCount    int64
+	dropped        int64
+	reported       int64
+	reportInterval int
+	reportTick     int64
 	formatFunc     func(level LogLevel, fileName string, line int, v ...interface{}) string
 }
 
 func (r *Log) initFileLogger(f *FileLogger) *FileLogger {
-	if f.file == nil {
-		f.Path, _ = filepath.Abs(f.Path)
-		f.Path = StrReplace(f.Path, "\\", "/")
-		f.dirname = path.Dir(f.Path)
-		f.extname = path.Ext(f.Path)
-		f.filename = filepath.Base(f.Path[0 : len(f.Path)-len(f.extname)])
-		os.MkdirAll(f.dirname, 0666)
-		file, err := os.OpenFile(f.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-		if err == nil {
-			f.file = file
-			info, err := f.file.Stat()
-			if err != nil {
-				return nil
-			}
-
-			f.size = int(info.Size())
-			if f.Timeout > 0 {
-				SetTimeout(f.Timeout*1000, func(...interface{}) int {
-					defer func() { recover() }()
-					r.ctimeout <- f
-					return 0
-				})
-			}
-
-			return f
+	if f.file == nil && f.open() {
+		if f.Timeout > 0 {
+			SetTimeout(f.Timeout*1000, func(...interface{}) int {
+				defer func() { recover() }()
+				r.ctimeout <- f
+				return 0
+			})
 		}
+		return f
 	}
 	return nil
 }
@@ -194,29 +125,27 @@ func (r *Log) initFileLogger(f *FileLogger) *FileLogger {
 func (r *Log) start() {
 	goForLog(func(cstop chan struct{}) {
 		var i int32
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 		for !r.IsStop() {
 			select {
-			case s, ok := <-r.cwrite:
-				if ok {
-					for i = 0; i < r.loggerCount; i++ {
-						r.logger[i].Write(s)
+			case now := <-ticker.C:
+				for i = 0; i < r.loggerCount; i++ {
+					if f, ok := r.logger[i].(*FileLogger); ok {
+						f.checkRotate()
 					}
 				}
+				if tick := now.UnixNano() / 1000000; r.reportInterval > 0 && tick-r.reportTick >= int64(r.reportInterval) {
+					r.reportTick = tick
+					r.reportDropped()
+				}
+			case e, ok := <-r.cwrite:
+				if ok {
+					r.dispatch(e)
+				}
 			case c, ok := <-r.ctimeout:
 				if ok {
-					c.file.Close()
-					c.file = nil
-					newpath := c.dirname + "/" + c.filename + fmt.Sprintf("_%v", Date()) + c.extname
-					if c.OnRenameFile != nil {
-						newpath = c.OnRenameFile(c.dirname+"/", c.filename, c.extname)
-					}
-					os.Rename(c.Path, newpath)
-					file, err := os.OpenFile(c.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-					if err == nil {
-						c.file = file
-					}
-
-					c.size = 0
+					newpath := c.rotate(c.timeName())
 					if c.OnTimeout != nil {
 						nc := c.OnTimeout(newpath)
 						if nc > 0 {
@@ -227,29 +156,38 @@ func (r *Log) start() {
 							})
 						}
 					}
+					c.afterRotate(newpath)
 				}
 			case <-cstop:
 			}
 		}
 
-		for s := range r.cwrite {
-			for i = 0; i < r.loggerCount; i++ {
-				r.logger[i].Write(s)
-			}
+		for e := range r.cwrite {
+			r.dispatch(e)
 		}
-
-		// 关闭打开的文件fd
+		r.reportDropped()
 		for i = 0; i < r.loggerCount; i++ {
-			if f, ok := r.logger[i].(*FileLogger); ok {
-				if f.file != nil {
-					f.file.Close()
-					f.file = nil
-				}
+			if c, ok := r.logger[i].(logCloser); ok {
+				c.close()
 			}
 		}
 	})
 }
 
+func (r *Log) dispatch(e *LogEntry) {
+	var i int32
+	for i = 0; i < r.loggerCount; i++ {
+		if !r.sinks[i].accept(e) {
+			continue
+		}
+		if l, ok := r.logger[i].(IEntryLogger); ok {
+			l.WriteEntry(e)
+		} else {
+			r.logger[i].Write(e.Encode(nil))
+		}
+	}
+}
+
 func (r *Log) Stop() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
 		close(r.cwrite)
@@ -258,6 +196,12 @@ func (r *Log) Stop() {
 }
 
 func (r *Log) SetLogger(logger ILogger) bool {
+	return r.AddLogger(logger, LogLevelTrace, nil)
+}
+
+// 添加输出，level为这个输出的最低等级，filter不为nil时只输出filter返回true的日志
+// 日志需要先通过Log本身的等级，所以Log的等级应该不高于所有输出的最低等级
+func (r *Log) AddLogger(logger ILogger, level LogLevel, filter LogFilter) bool {
 	if r.preLoggerCount >= 31 {
 		return false
 	}
@@ -266,10 +210,23 @@ func (r *Log) SetLogger(logger ILogger) bool {
 			return false
 		}
 	}
-	r.logger[atomic.AddInt32(&r.preLoggerCount, 1)] = logger
+	index := atomic.AddInt32(&r.preLoggerCount, 1)
+	r.sinks[index] = logSink{level: level, filter: filter}
+	r.logger[index] = logger
 	atomic.AddInt32(&r.loggerCount, 1)
 	return true
+}
 
+// 修改已经添加的输出的等级和过滤函数，输出不存在返回false
+func (r *Log) SetLoggerLevel(logger ILogger, level LogLevel, filter LogFilter) bool {
+	var i int32
+	for i = 0; i < r.loggerCount; i++ {
+		if r.logger[i] == logger {
+			r.sinks[i] = logSink{level: level, filter: filter}
+			return true
+		}
+	}
+	return false
 }
 func (r *Log) Level() LogLevel {
 	return r.level
@@ -289,86 +246,162 @@ func (r *Log) SetLevelByName(name string) bool {
 }
 
 func isLogStop() bool {
-	return stopForLog == 1
+	return atomic.LoadInt32(&stopForLog) == 1
 }
 
 func (r *Log) IsStop() bool {
-	if r.stop == 0 {
+	if atomic.LoadInt32(&r.stop) == 0 {
 		if isLogStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
+}
+
+// 设置编码器，默认为TextLogEncoder，没有实现IEntryLogger或者没有设置自己编码器的输出使用这个编码器
+func (r *Log) SetEncoder(encoder ILogEncoder) {
+	if encoder == nil {
+		encoder = &TextLogEncoder{}
+	}
+	r.encoder = encoder
 }
 
 func (r *Log) SetFormatFunc(formatFunc func(level LogLevel, fileName string, line int, v ...interface{}) string) {
 	r.formatFunc = formatFunc
 }
 
-func (r *Log) write(levstr string, level LogLevel, v ...interface{}) {
+func (r *Log) write(level LogLevel, skip int, fields []LogField, v ...interface{}) {
 	defer func() { recover() }()
 	if r.IsStop() {
 		return
 	}
 
+	e := &LogEntry{Level: level, Time: time.Now(), Fields: fields, encoder: r.encoder}
+	_, file, line, ok := runtime.Caller(skip)
+	if ok {
+		i := strings.LastIndex(file, "/") + 1
+		e.File = string(([]byte(file))[i:])
+		e.Line = line
+	}
 	if r.formatFunc != nil {
-		_, file, line, ok := runtime.Caller(r.callStackCnt)
-		if ok {
-			i := strings.LastIndex(file, "/") + 1
-			if len(v) > 1 {
-				r.cwrite <- r.formatFunc(level, string(([]byte(file))[i:]), line, v...)
-			} else {
-				r.cwrite <- r.formatFunc(level, string(([]byte(file))[i:]), line, v...)
-			}
+		if !ok {
+			return
 		}
+		e.Msg = r.formatFunc(level, e.File, line, v...)
+		e.Raw = true
+	} else if len(v) > 1 {
+		e.Msg = fmt.Sprintf(v[0].(string), v[1:]...)
 	} else {
-		prefix := levstr
-		_, file, line, ok := runtime.Caller(r.callStackCnt)
-		if ok {
-			i := strings.LastIndex(file, "/") + 1
-			prefix = fmt.Sprintf("[%s][%s][%s:%d]:", levstr, Date(), string(([]byte(file))[i:]), line)
+		e.Msg = fmt.Sprint(v[0])
+	}
+	r.push(e)
+}
+
+func (r *Log) push(e *LogEntry) {
+	select {
+	case r.cwrite <- e:
+		return
+	default:
+	}
+	switch r.overflow {
+	case LogOverflowDropNewest:
+		atomic.AddInt64(&r.dropped, 1)
+	case LogOverflowDropOldest:
+		for {
+			select {
+			case r.cwrite <- e:
+				return
+			default:
+			}
+			select {
+			case <-r.cwrite:
+				atomic.AddInt64(&r.dropped, 1)
+			default:
+			}
 		}
-		if len(v) > 1 {
-			r.cwrite <- prefix + fmt.Sprintf(v[0].(string), v[1:]...)
+	case LogOverflowSample:
+		if atomic.AddInt64(&r.sampleCount, 1)%r.sample == 0 {
+			r.cwrite <- e
 		} else {
-			r.cwrite <- prefix + fmt.Sprint(v[0])
+			atomic.AddInt64(&r.dropped, 1)
+		}
+	default:
+		r.cwrite <- e
+	}
+}
+
+// 设置缓冲区满时的处理方式，sample只对LogOverflowSample有效
+func (r *Log) SetOverflow(overflow LogOverflow, sample int) {
+	if sample <= 0 {
+		sample = 10
+	}
+	atomic.StoreInt64(&r.sample, int64(sample))
+	r.overflow = overflow
+}
+
+// 缓冲区满丢弃的日志数量，不包括输出自己丢弃的
+func (r *Log) Dropped() int64 {
+	return atomic.LoadInt64(&r.dropped)
+}
+
+// 设置丢弃日志的报告间隔，单位毫秒，0表示不报告，默认10秒
+func (r *Log) SetDropReport(interval int) {
+	r.reportInterval = interval
+}
+
+// 报告新丢弃的日志，直接交给输出，不经过缓冲区
+func (r *Log) reportDropped() {
+	if r.reportInterval <= 0 || r.level > LogLevelWarn {
+		return
+	}
+	if n := atomic.LoadInt64(&r.dropped); n > r.reported {
+		r.dispatch(&LogEntry{Level: LogLevelWarn, Time: time.Now(), Msg: "log dropped lines", Fields: []LogField{{"dropped", n - r.reported}, {"total", n}}, encoder: r.encoder})
+		r.reported = n
+	}
+	var i int32
+	for i = 0; i < r.loggerCount; i++ {
+		if d, ok := r.logger[i].(logDropper); ok {
+			if n := d.Dropped(); n > r.sinks[i].reported {
+				r.dispatch(&LogEntry{Level: LogLevelWarn, Time: time.Now(), Msg: "log sink dropped lines", Fields: []LogField{{"sink", i}, {"dropped", n - r.sinks[i].reported}, {"total", n}}, encoder: r.encoder})
+				r.sinks[i].reported = n
+			}
 		}
 	}
 }
 
 func (r *Log) Trace(v ...interface{}) {
 	if r.level <= LogLevelTrace {
-		r.write("T", LogLevelTrace, v...)
+		r.write(LogLevelTrace, r.callStackCnt, nil, v...)
 	}
 }
 
 func (r *Log) Debug(v ...interface{}) {
 	if r.level <= LogLevelDebug {
-		r.write("D", LogLevelDebug, v...)
+		r.write(LogLevelDebug, r.callStackCnt, nil, v...)
 	}
 }
 
 func (r *Log) Info(v ...interface{}) {
 	if r.level <= LogLevelInfo {
-		r.write("I", LogLevelInfo, v...)
+		r.write(LogLevelInfo, r.callStackCnt, nil, v...)
 	}
 }
 
 func (r *Log) Warn(v ...interface{}) {
 	if r.level <= LogLevelWarn {
-		r.write("W", LogLevelWarn, v...)
+		r.write(LogLevelWarn, r.callStackCnt, nil, v...)
 	}
 }
 
 func (r *Log) Error(v ...interface{}) {
 	if r.level <= LogLevelError {
-		r.write("E", LogLevelError, v...)
+		r.write(LogLevelError, r.callStackCnt, nil, v...)
 	}
 }
 
 func (r *Log) Fatal(v ...interface{}) {
 	if r.level <= LogLevelFatal {
-		r.write("F", LogLevelFatal, v...)
+		r.write(LogLevelFatal, r.callStackCnt, nil, v...)
 	}
 }
 
@@ -378,10 +411,50 @@ func (r *Log) Write(v ...interface{}) {
 		return
 	}
 
+	e := &LogEntry{Level: LogLevelAllOff, Time: time.Now(), Raw: true, encoder: r.encoder}
 	if len(v) > 1 {
-		r.cwrite <- fmt.Sprintf(v[0].(string), v[1:]...)
+		e.Msg = fmt.Sprintf(v[0].(string), v[1:]...)
 	} else if len(v) > 0 {
-		r.cwrite <- fmt.Sprint(v[0])
+		e.Msg = fmt.Sprint(v[0])
+	} else {
+		return
+	}
+	r.push(e)
+}
+
+func (r *Log) TraceKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelTrace {
+		r.write(LogLevelTrace, r.callStackCnt, logFields(kv), msg)
+	}
+}
+
+func (r *Log) DebugKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelDebug {
+		r.write(LogLevelDebug, r.callStackCnt, logFields(kv), msg)
+	}
+}
+
+func (r *Log) InfoKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelInfo {
+		r.write(LogLevelInfo, r.callStackCnt, logFields(kv), msg)
+	}
+}
+
+func (r *Log) WarnKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelWarn {
+		r.write(LogLevelWarn, r.callStackCnt, logFields(kv), msg)
+	}
+}
+
+func (r *Log) ErrorKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelError {
+		r.write(LogLevelError, r.callStackCnt, logFields(kv), msg)
+	}
+}
+
+func (r *Log) FatalKV(msg string, kv ...interface{}) {
+	if r.level <= LogLevelFatal {
+		r.write(LogLevelFatal, r.callStackCnt, logFields(kv), msg)
 	}
 }
 
@@ -389,7 +462,9 @@ func NewLog(bufsize int, logger ...ILogger) *Log {
 	log := &Log{
 		bufsize:        bufsize,
 		callStackCnt:   3,
-		cwrite:         make(chan string, bufsize),
+		cwrite:         make(chan *LogEntry, bufsize),
+		reportInterval: 10000,
+		encoder:        &TextLogEncoder{},
 		ctimeout:       make(chan *FileLogger, 32),
 		level:          LogLevelDebug,
 		preLoggerCount: -1,
@@ -425,6 +500,35 @@ func LogWarn(v ...interface{}) {
 	DefLog.Warn(v...)
 }
 
+// 创建带有附加字段的日志，比如LogWith("msgque", msgque.Id()).Info("xxx")
+func LogWith(kv ...interface{}) *FieldLog {
+	return DefLog.With(kv...)
+}
+
+func LogTraceKV(msg string, kv ...interface{}) {
+	DefLog.TraceKV(msg, kv...)
+}
+
+func LogDebugKV(msg string, kv ...interface{}) {
+	DefLog.DebugKV(msg, kv...)
+}
+
+func LogInfoKV(msg string, kv ...interface{}) {
+	DefLog.InfoKV(msg, kv...)
+}
+
+func LogWarnKV(msg string, kv ...interface{}) {
+	DefLog.WarnKV(msg, kv...)
+}
+
+func LogErrorKV(msg string, kv ...interface{}) {
+	DefLog.ErrorKV(msg, kv...)
+}
+
+func LogFatalKV(msg string, kv ...interface{}) {
+	DefLog.FatalKV(msg, kv...)
+}
+
 func LogStack() {
 	buf := make([]byte, 1<<12)
 	LogError(string(buf[:runtime.Stack(buf, false)]))
@@ -0,0 +1,217 @@
+package antnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var logLevelShortNames = []string{"T", "D", "I", "W", "E", "F"}
+var logLevelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+func (r LogLevel) String() string {
+	if r >= 0 && int(r) < len(logLevelNames) {
+		return logLevelNames[r]
+	}
+	return "off"
+}
+
+func (r LogLevel) shortName() string {
+	if r >= 0 && int(r) < len(logLevelShortNames) {
+		return logLevelShortNames[r]
+	}
+	return ""
+}
+
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// 把key value交替的参数转为字段，key不是字符串时使用fmt.Sprint，多出来的最后一个参数的key为!extra
+func logFields(kv []interface{}) []LogField {
+	fields := make([]LogField, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 >= len(kv) {
+			fields = append(fields, LogField{"!extra", kv[i]})
+			break
+		}
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		fields = append(fields, LogField{key, kv[i+1]})
+	}
+	return fields
+}
+
+// 一条日志，所有ILogger都会收到，实现了IEntryLogger的会收到日志本身，其他的收到编码后的字符串
+type LogEntry struct {
+	Level  LogLevel
+	Time   time.Time
+	File   string //文件名，获取失败时为空
+	Line   int
+	Msg    string
+	Fields []LogField
+	Raw    bool //通过Log.Write写入或者经过SetFormatFunc格式化的内容，文本编码时不加前缀
+
+	encoder ILogEncoder
+	text    string
+}
+
+// 使用编码器编码，enc为nil时使用Log设置的编码器，结果会被缓存
+func (r *LogEntry) Encode(enc ILogEncoder) string {
+	if enc != nil && enc != r.encoder {
+		return enc.Encode(r)
+	}
+	if r.text == "" {
+		r.text = r.encoder.Encode(r)
+	}
+	return r.text
+}
+
+type ILogEncoder interface {
+	Encode(e *LogEntry) string
+}
+
+// 接收结构化日志的输出
+type IEntryLogger interface {
+	ILogger
+	WriteEntry(e *LogEntry)
+}
+
+// 文本编码，格式为[I][2006-01-02 15:04:05][file.go:10]:msg key=value，和以前的格式兼容
+type TextLogEncoder struct {
+}
+
+func (r *TextLogEncoder) Encode(e *LogEntry) string {
+	var buf bytes.Buffer
+	if !e.Raw {
+		if e.File != "" {
+			fmt.Fprintf(&buf, "[%s][%s][%s:%d]:", e.Level.shortName(), e.Time.Format("2006-01-02 15:04:05"), e.File, e.Line)
+		} else {
+			buf.WriteString(e.Level.shortName())
+		}
+	}
+	buf.WriteString(e.Msg)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		s := logValueString(f.Value)
+		if s == "" || bytes.ContainsAny([]byte(s), " \t\r\n\"=") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
+
+// json编码，每条日志一行，固定字段为time level caller msg，然后是附加字段
+type JSONLogEncoder struct {
+	TimeFormat string //为空使用2006-01-02T15:04:05.000Z07:00
+}
+
+func (r *JSONLogEncoder) Encode(e *LogEntry) string {
+	format := r.TimeFormat
+	if format == "" {
+		format = "2006-01-02T15:04:05.000Z07:00"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	buf.WriteString(strconv.Quote(e.Time.Format(format)))
+	if e.Level < LogLevelAllOff {
+		buf.WriteString(`,"level":"`)
+		buf.WriteString(e.Level.String())
+		buf.WriteByte('"')
+	}
+	if e.File != "" {
+		buf.WriteString(`,"caller":`)
+		buf.WriteString(strconv.Quote(e.File + ":" + strconv.Itoa(e.Line)))
+	}
+	buf.WriteString(`,"msg":`)
+	writeJsonValue(&buf, e.Msg)
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		writeJsonValue(&buf, f.Key)
+		buf.WriteByte(':')
+		writeJsonValue(&buf, f.Value)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func logValueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func writeJsonValue(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case error:
+		v = val.Error()
+	case time.Duration:
+		v = val.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// 带有附加字段的日志，通过Log.With创建，输出到原来的Log
+type FieldLog struct {
+	log    *Log
+	fields []LogField
+}
+
+func (r *Log) With(kv ...interface{}) *FieldLog {
+	return &FieldLog{log: r, fields: logFields(kv)}
+}
+
+// 创建子日志，继承当前的字段
+func (r *FieldLog) With(kv ...interface{}) *FieldLog {
+	fields := make([]LogField, 0, len(r.fields)+len(kv)/2)
+	fields = append(fields, r.fields...)
+	return &FieldLog{log: r.log, fields: append(fields, logFields(kv)...)}
+}
+
+func (r *FieldLog) Fields() []LogField {
+	return r.fields
+}
+
+func (r *FieldLog) output(level LogLevel, fields []LogField, v ...interface{}) {
+	if r.log.level <= level {
+		r.log.write(level, r.log.callStackCnt, fields, v...)
+	}
+}
+
+func (r *FieldLog) kv(level LogLevel, msg string, kv []interface{}) {
+	if r.log.level <= level {
+		r.log.write(level, r.log.callStackCnt, append(append([]LogField{}, r.fields...), logFields(kv)...), msg)
+	}
+}
+
+func (r *FieldLog) Trace(v ...interface{}) { r.output(LogLevelTrace, r.fields, v...) }
+func (r *FieldLog) Debug(v ...interface{}) { r.output(LogLevelDebug, r.fields, v...) }
+func (r *FieldLog) Info(v ...interface{})  { r.output(LogLevelInfo, r.fields, v...) }
+func (r *FieldLog) Warn(v ...interface{})  { r.output(LogLevelWarn, r.fields, v...) }
+func (r *FieldLog) Error(v ...interface{}) { r.output(LogLevelError, r.fields, v...) }
+func (r *FieldLog) Fatal(v ...interface{}) { r.output(LogLevelFatal, r.fields, v...) }
+
+func (r *FieldLog) TraceKV(msg string, kv ...interface{}) { r.kv(LogLevelTrace, msg, kv) }
+func (r *FieldLog) DebugKV(msg string, kv ...interface{}) { r.kv(LogLevelDebug, msg, kv) }
+func (r *FieldLog) InfoKV(msg string, kv ...interface{})  { r.kv(LogLevelInfo, msg, kv) }
+func (r *FieldLog) WarnKV(msg string, kv ...interface{})  { r.kv(LogLevelWarn, msg, kv) }
+func (r *FieldLog) ErrorKV(msg string, kv ...interface{}) { r.kv(LogLevelError, msg, kv) }
+func (r *FieldLog) FatalKV(msg string, kv ...interface{}) { r.kv(LogLevelFatal, msg, kv) }
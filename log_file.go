@@ -0,0 +1,262 @@
+package antnet
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+type OnFileLogFull func(path string)
+type OnFileLogTimeout func(path string) int
+type OnFileRename func(dirName, fileName, extName string) string
+
+type FileRotate int
+
+const (
+	FileRotateNone   FileRotate = iota //不按时间轮转
+	FileRotateDaily                    //每天零点轮转，文件名为name_20060102.ext
+	FileRotateHourly                   //每小时轮转，文件名为name_2006010215.ext
+)
+
+type FileLogger struct {
+	Path         string
+	Ln           bool
+	Timeout      int //0表示不设置, 单位s
+	MaxSize      int //0表示不限制，最大大小
+	OnFull       OnFileLogFull
+	OnTimeout    OnFileLogTimeout
+	OnRenameFile OnFileRename
+	Encoder      ILogEncoder //为空时使用Log的编码器
+	Rotate       FileRotate  //按时间轮转，对齐到整点或者零点
+	TimeZone     int         //轮转和文件名使用的时区，单位小时，和time.go中的函数相同，本地时区可以使用LocalTimeZone()
+	MaxFiles     int         //最多保留多少个轮转出来的文件，0表示不限制
+	MaxAge       int         //轮转出来的文件最多保留多少秒，0表示不限制
+	Compress     bool        //轮转出来的文件使用gzip压缩，压缩后加上.gz后缀
+
+	size     int
+	file     *os.File
+	filename string
+	extname  string
+	dirname  string
+	rotated  *regexp.Regexp //轮转后的文件名 name_时间[.序号]ext[.gz]
+	rotateAt int64          //下次按时间轮转的时间戳
+	period   int64          //当前文件所属时间段的开始时间戳
+	clean    sync.Mutex     //压缩和清理在其他协程中进行
+}
+
+func (r *FileLogger) WriteEntry(e *LogEntry) {
+	r.Write(e.Encode(r.Encoder))
+}
+
+func (r *FileLogger) Write(str string) {
+	if r.file == nil {
+		return
+	}
+	r.checkRotate()
+
+	newsize := r.size
+	if r.Ln {
+		newsize += len(str) + 1
+	} else {
+		newsize += len(str)
+	}
+
+	if r.MaxSize > 0 && newsize >= r.MaxSize {
+		newpath := r.rotate(r.timeName())
+		if r.OnFull != nil {
+			r.OnFull(newpath)
+		}
+		r.afterRotate(newpath)
+	}
+
+	if r.file == nil {
+		return
+	}
+
+	if r.Ln {
+		r.file.WriteString(str)
+		r.file.WriteString("\n")
+		r.size += len(str) + 1
+	} else {
+		r.file.WriteString(str)
+		r.size += len(str)
+	}
+}
+
+func (r *FileLogger) open() bool {
+	r.Path, _ = filepath.Abs(r.Path)
+	r.Path = StrReplace(r.Path, "\\", "/")
+	r.dirname = path.Dir(r.Path)
+	r.extname = path.Ext(r.Path)
+	r.filename = filepath.Base(r.Path[0 : len(r.Path)-len(r.extname)])
+	r.rotated = regexp.MustCompile(`^` + regexp.QuoteMeta(r.filename) + `_(\d{8}|\d{10}|\d{8}_\d{6})(\.\d+)?` + regexp.QuoteMeta(r.extname) + `(\.gz)?$`)
+	os.MkdirAll(r.dirname, 0755)
+	file, err := os.OpenFile(r.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return false
+	}
+	r.file = file
+	r.size = int(info.Size())
+	if r.Rotate != FileRotateNone {
+		r.period, r.rotateAt = r.periodOf(time.Now().Unix())
+		// 上次运行留下的文件属于之前的时间段，先轮转出去
+		if r.size > 0 {
+			if period, _ := r.periodOf(info.ModTime().Unix()); period < r.period {
+				cur := r.period
+				r.period = period
+				newpath := r.rotate(r.periodName())
+				r.period = cur
+				r.afterRotate(newpath)
+			}
+		}
+	}
+	return true
+}
+
+// 返回时间戳所在时间段的开始和结束
+func (r *FileLogger) periodOf(timestamp int64) (int64, int64) {
+	span := int64(86400)
+	if r.Rotate == FileRotateHourly {
+		span = 3600
+	}
+	offset := int64(r.TimeZone * 3600)
+	start := (timestamp+offset)/span*span - offset
+	return start, start + span
+}
+
+func (r *FileLogger) periodName() string {
+	t := time.Unix(r.period+int64(r.TimeZone*3600), 0).UTC()
+	if r.Rotate == FileRotateHourly {
+		return t.Format("2006010215")
+	}
+	return t.Format("20060102")
+}
+
+func (r *FileLogger) timeName() string {
+	return time.Unix(time.Now().Unix()+int64(r.TimeZone*3600), 0).UTC().Format("20060102_150405")
+}
+
+// 到了下一个时间段时轮转
+func (r *FileLogger) checkRotate() {
+	if r.Rotate == FileRotateNone || r.file == nil {
+		return
+	}
+	now := time.Now().Unix()
+	if now < r.rotateAt {
+		return
+	}
+	var newpath string
+	if r.size > 0 {
+		newpath = r.rotate(r.periodName())
+	}
+	r.period, r.rotateAt = r.periodOf(now)
+	if newpath != "" {
+		r.afterRotate(newpath)
+	}
+}
+
+// 关闭当前文件，重命名为name_suffix.ext，已经存在时加上序号，然后重新打开
+func (r *FileLogger) rotate(suffix string) string {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	var newpath string
+	if r.OnRenameFile != nil {
+		newpath = r.OnRenameFile(r.dirname+"/", r.filename, r.extname)
+	} else {
+		base := r.dirname + "/" + r.filename + "_" + suffix
+		newpath = base + r.extname
+		for i := 1; PathExists(newpath) || PathExists(newpath+".gz"); i++ {
+			newpath = fmt.Sprintf("%s.%d%s", base, i, r.extname)
+		}
+	}
+	os.Rename(r.Path, newpath)
+	file, err := os.OpenFile(r.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err == nil {
+		r.file = file
+	}
+	r.size = 0
+	return newpath
+}
+
+// 轮转之后压缩并清理旧文件，日志停止后在当前协程进行
+func (r *FileLogger) afterRotate(newpath string) {
+	if !r.Compress && r.MaxFiles <= 0 && r.MaxAge <= 0 {
+		return
+	}
+	fn := func(chan struct{}) {
+		r.clean.Lock()
+		defer r.clean.Unlock()
+		if r.Compress {
+			r.compress(newpath)
+		}
+		r.removeOld()
+	}
+	if !goForLog(fn) {
+		fn(nil)
+	}
+}
+
+func (r *FileLogger) compress(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(file+".gz", GZipCompress(data), 0644); err != nil {
+		os.Remove(file + ".gz")
+		return
+	}
+	os.Remove(file)
+}
+
+// 按修改时间删除超过MaxFiles个数或者MaxAge时间的轮转文件，只处理自己轮转出来的文件
+func (r *FileLogger) removeOld() {
+	if r.MaxFiles <= 0 && r.MaxAge <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(r.dirname)
+	if err != nil {
+		return
+	}
+	type rotated struct {
+		path string
+		time int64
+	}
+	var files []rotated
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !r.rotated.MatchString(name) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, rotated{r.dirname + "/" + name, info.ModTime().Unix()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].time > files[j].time })
+	now := time.Now().Unix()
+	for i, f := range files {
+		if (r.MaxFiles > 0 && i >= r.MaxFiles) || (r.MaxAge > 0 && now-f.time > int64(r.MaxAge)) {
+			os.Remove(f.path)
+		}
+	}
+}
+
+func (r *FileLogger) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
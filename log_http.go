@@ -0,0 +1,185 @@
+package antnet
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 通过http发送日志，日志先缓存起来，由一个协程批量发送，失败时按指数退避重试
+// Post时一批日志用换行连接后一次发送，Get时每条日志一个请求
+type HttpLogger struct {
+	Url           string
+	Get           bool
+	GetKey        string
+	Timeout       int         //请求超时，单位秒，0表示5秒
+	Encoder       ILogEncoder //为空时使用Log的编码器
+	BatchSize     int         //缓存多少条发送一次，0表示100
+	BatchInterval int         //最长多久发送一次，单位毫秒，0表示1000
+	MaxPending    int         //最多缓存多少条，超过时丢弃新的日志，0表示10000
+	MaxRetry      int         //失败后最多重试次数，0表示3次，小于0表示不重试
+	RetryInterval int         //第一次重试的间隔，单位毫秒，之后每次翻倍，0表示500
+
+	once    sync.Once
+	lock    sync.Mutex
+	lines   []string
+	cflush  chan struct{}
+	dropped int64
+}
+
+func (r *HttpLogger) WriteEntry(e *LogEntry) {
+	r.Write(e.Encode(r.Encoder))
+}
+
+func (r *HttpLogger) Write(str string) {
+	if r.Url == "" {
+		return
+	}
+	r.once.Do(r.start)
+	r.lock.Lock()
+	if len(r.lines) >= r.MaxPending {
+		r.lock.Unlock()
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+	r.lines = append(r.lines, str)
+	full := len(r.lines) >= r.BatchSize
+	r.lock.Unlock()
+	if full {
+		select {
+		case r.cflush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 缓存满或者重试失败丢弃的日志数量
+func (r *HttpLogger) Dropped() int64 {
+	return atomic.LoadInt64(&r.dropped)
+}
+
+func (r *HttpLogger) start() {
+	if r.Timeout == 0 {
+		r.Timeout = 5
+	}
+	if r.GetKey == "" {
+		r.GetKey = "log"
+	}
+	if r.BatchSize <= 0 {
+		r.BatchSize = 100
+	}
+	if r.BatchInterval <= 0 {
+		r.BatchInterval = 1000
+	}
+	if r.MaxPending <= 0 {
+		r.MaxPending = 10000
+	}
+	if r.MaxRetry == 0 {
+		r.MaxRetry = 3
+	}
+	if r.RetryInterval <= 0 {
+		r.RetryInterval = 500
+	}
+	r.cflush = make(chan struct{}, 1)
+	goForLog(func(cstop chan struct{}) {
+		ticker := time.NewTicker(time.Millisecond * time.Duration(r.BatchInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cstop:
+				r.flush(cstop)
+				return
+			case <-ticker.C:
+			case <-r.cflush:
+			}
+			r.flush(cstop)
+		}
+	})
+}
+
+// 取出缓存的日志发送，cstop关闭后不再等待重试
+func (r *HttpLogger) flush(cstop chan struct{}) {
+	for {
+		r.lock.Lock()
+		n := len(r.lines)
+		if n > r.BatchSize {
+			n = r.BatchSize
+		}
+		lines := r.lines[:n:n]
+		r.lines = r.lines[n:]
+		r.lock.Unlock()
+		if n == 0 {
+			return
+		}
+		if n := r.send(lines, cstop); n > 0 {
+			atomic.AddInt64(&r.dropped, int64(n))
+			select {
+			case <-cstop:
+				//关闭时请求失败，剩下的日志不再尝试，全部计入丢弃
+				r.lock.Lock()
+				atomic.AddInt64(&r.dropped, int64(len(r.lines)))
+				r.lines = nil
+				r.lock.Unlock()
+				return
+			default:
+			}
+		}
+	}
+}
+
+// 返回没有发送成功的日志数量
+func (r *HttpLogger) send(lines []string, cstop chan struct{}) int {
+	c := http.Client{
+		Timeout: time.Duration(r.Timeout) * time.Second,
+	}
+	wait := time.Millisecond * time.Duration(r.RetryInterval)
+	for retry := 0; ; retry++ {
+		for len(lines) > 0 && r.request(&c, lines) {
+			if !r.Get {
+				return 0
+			}
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			return 0
+		}
+		if retry >= r.MaxRetry {
+			return len(lines)
+		}
+		select {
+		case <-cstop:
+			return len(lines)
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (r *HttpLogger) request(c *http.Client, lines []string) bool {
+	var resp *http.Response
+	var err error
+	if r.Get {
+		resp, err = c.Get(r.Url + "/?" + r.GetKey + "=" + url.QueryEscape(lines[0]))
+	} else {
+		resp, err = c.Post(r.Url, "application/x-www-form-urlencoded", strings.NewReader(strings.Join(lines, "\n")))
+	}
+	if err != nil {
+		return false
+	}
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+	return resp.StatusCode < 500
+}
+
+// Log停止时在写完所有日志后调用，同步发送剩余的日志，不再重试，第一次失败后剩下的日志都丢弃
+func (r *HttpLogger) close() {
+	if r.cflush != nil {
+		cstop := make(chan struct{})
+		close(cstop)
+		r.flush(cstop)
+	}
+}
@@ -0,0 +1,341 @@
+package antnet
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type NetLogFraming int
+
+const (
+	NetLogFramingNewline NetLogFraming = iota //每条日志后面加换行
+	NetLogFramingLength                       //每条日志前面加4字节大端长度
+	NetLogFramingOctet                        //RFC 6587 octet counting，长度的十进制加空格，syslog over tcp使用
+	NetLogFramingNone                         //不加分隔，udp每条日志一个包
+)
+
+// 把日志发送到远程的tcp tls或udp服务，使用消息队列连接，断开后通过Reconnect自动重连
+// 日志先缓存起来，由一个协程在连接可用时发送，缓存超过MaxPending的日志会被丢弃
+type NetLogger struct {
+	Net        string //tcp tls udp，为空使用tcp
+	Addr       string
+	Framing    NetLogFraming
+	MaxPending int         //最多缓存多少条，0表示10000
+	Encoder    ILogEncoder //为空时使用Log的编码器
+	App        *App        //连接所属的实例，为空时使用内部的实例，连接自己的日志不输出，避免连接错误又写回这个输出
+
+	once    sync.Once
+	app     *App
+	lock    sync.Mutex
+	lines   [][]byte
+	cflush  chan struct{}
+	dropped int64
+	closed  int32
+	msgque  IMsgQue
+}
+
+type netLoggerHandler struct {
+	DefMsgHandler
+	logger *NetLogger
+}
+
+func (r *netLoggerHandler) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	if ok {
+		r.logger.notify()
+	}
+	return true
+}
+
+func (r *netLoggerHandler) OnDelMsgQue(msgque IMsgQue) {
+	if atomic.LoadInt32(&r.logger.closed) == 0 {
+		msgque.Reconnect(1)
+	}
+}
+
+func (r *NetLogger) WriteEntry(e *LogEntry) {
+	r.Write(e.Encode(r.Encoder))
+}
+
+func (r *NetLogger) Write(str string) {
+	r.write(r.frame(str))
+}
+
+func (r *NetLogger) frame(str string) []byte {
+	switch r.Framing {
+	case NetLogFramingLength:
+		data := make([]byte, 4+len(str))
+		binary.BigEndian.PutUint32(data, uint32(len(str)))
+		copy(data[4:], str)
+		return data
+	case NetLogFramingOctet:
+		return []byte(strconv.Itoa(len(str)) + " " + str)
+	case NetLogFramingNone:
+		return []byte(str)
+	}
+	return []byte(str + "\n")
+}
+
+func (r *NetLogger) write(data []byte) {
+	if r.Addr == "" || atomic.LoadInt32(&r.closed) == 1 {
+		return
+	}
+	r.once.Do(r.start)
+	r.lock.Lock()
+	if len(r.lines) >= r.MaxPending {
+		r.lock.Unlock()
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+	r.lines = append(r.lines, data)
+	r.lock.Unlock()
+	r.notify()
+}
+
+func (r *NetLogger) notify() {
+	select {
+	case r.cflush <- struct{}{}:
+	default:
+	}
+}
+
+// 缓存满丢弃的日志数量
+func (r *NetLogger) Dropped() int64 {
+	return atomic.LoadInt64(&r.dropped)
+}
+
+func (r *NetLogger) start() {
+	if r.Net == "" {
+		r.Net = "tcp"
+	}
+	if r.MaxPending <= 0 {
+		r.MaxPending = 10000
+	}
+	r.app = r.App
+	if r.app == nil {
+		r.app = NewApp(&Log{level: LogLevelAllOff})
+	}
+	r.cflush = make(chan struct{}, 1)
+	// 连接在日志协程中建立，避免在Log的协程中写日志
+	goForLog(func(cstop chan struct{}) {
+		msgque := r.app.StartConnect(r.Net, r.Addr, MsgTypeCmd, &netLoggerHandler{logger: r}, nil, nil)
+		r.lock.Lock()
+		r.msgque = msgque
+		r.lock.Unlock()
+		if msgque == nil {
+			return
+		}
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cstop:
+				r.flush()
+				return
+			case <-ticker.C:
+			case <-r.cflush:
+			}
+			if atomic.LoadInt32(&r.closed) == 1 { //关闭时已经写出
+				return
+			}
+			r.flush()
+		}
+	})
+}
+
+// 连接可用时发送缓存的日志，发送失败的放回缓存等待重连
+func (r *NetLogger) flush() {
+	r.lock.Lock()
+	msgque := r.msgque
+	if msgque == nil || !msgque.Available() || len(r.lines) == 0 {
+		r.lock.Unlock()
+		return
+	}
+	lines := r.lines
+	r.lines = nil
+	r.lock.Unlock()
+	for i, data := range lines {
+		if msgque.SendWithPolicy(&Message{Data: data}, SendPolicyTimeout, 1000) != nil {
+			r.lock.Lock()
+			r.lines = append(lines[i:], r.lines...)
+			r.lock.Unlock()
+			return
+		}
+	}
+}
+
+// Log停止时在写完所有日志后调用，等待发送队列写完后再关闭连接，没有发出去的算作丢弃
+func (r *NetLogger) close() {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return
+	}
+	r.flush()
+	r.lock.Lock()
+	msgque := r.msgque
+	r.lock.Unlock()
+	if r.app != nil && r.app != r.App {
+		r.app.Stop()
+	} else if msgque != nil {
+		if d, ok := msgque.(msgQueDrainer); ok {
+			for sc := 0; sc < Config.DrainTimeout && !d.drained(); sc++ {
+				Sleep(1)
+			}
+		}
+		msgque.Stop()
+	}
+	r.lock.Lock()
+	atomic.AddInt64(&r.dropped, int64(len(r.lines)))
+	r.lines = nil
+	r.lock.Unlock()
+}
+
+// RFC 5424格式的syslog，udp时每条日志一个包，tcp和tls使用RFC 6587 octet counting分隔
+// 日志的字段和代码位置放在structured data中
+type SyslogLogger struct {
+	Net        string //udp tcp tls，为空使用udp
+	Addr       string
+	Facility   int    //0表示1，即user-level
+	Hostname   string //为空使用os.Hostname
+	AppName    string //为空使用程序名
+	MaxPending int    //最多缓存多少条，0表示10000
+	App        *App   //连接所属的实例，为空时使用内部的实例
+
+	once   sync.Once
+	logger *NetLogger
+	procId string
+}
+
+const syslogSdId = "antnet@32473"
+
+func (r *SyslogLogger) init() {
+	if r.Net == "" {
+		r.Net = "udp"
+	}
+	if r.Facility <= 0 {
+		r.Facility = 1
+	}
+	if r.Hostname == "" {
+		r.Hostname, _ = os.Hostname()
+	}
+	if r.AppName == "" {
+		r.AppName = filepath.Base(os.Args[0])
+	}
+	r.Hostname = syslogHeaderField(r.Hostname, 255)
+	r.AppName = syslogHeaderField(r.AppName, 48)
+	r.procId = strconv.Itoa(os.Getpid())
+	framing := NetLogFramingOctet
+	if r.Net == "udp" {
+		framing = NetLogFramingNone
+	}
+	r.logger = &NetLogger{Net: r.Net, Addr: r.Addr, Framing: framing, MaxPending: r.MaxPending, App: r.App}
+}
+
+func (r *SyslogLogger) Write(str string) {
+	r.WriteEntry(&LogEntry{Level: LogLevelAllOff, Time: time.Now(), Msg: str, Raw: true})
+}
+
+func (r *SyslogLogger) WriteEntry(e *LogEntry) {
+	r.once.Do(r.init)
+	r.logger.Write(r.Format(e))
+}
+
+func (r *SyslogLogger) Dropped() int64 {
+	if r.logger == nil {
+		return 0
+	}
+	return r.logger.Dropped()
+}
+
+func (r *SyslogLogger) close() {
+	if r.logger != nil {
+		r.logger.close()
+	}
+}
+
+// 按RFC 5424格式化，<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (r *SyslogLogger) Format(e *LogEntry) string {
+	r.once.Do(r.init)
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(strconv.Itoa(r.Facility*8 + syslogSeverity(e.Level)))
+	b.WriteString(">1 ")
+	b.WriteString(e.Time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	b.WriteString(" " + r.Hostname + " " + r.AppName + " " + r.procId + " - ")
+	if e.File == "" && len(e.Fields) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + syslogSdId)
+		if e.File != "" {
+			b.WriteString(` caller="` + syslogParamValue(e.File+":"+strconv.Itoa(e.Line)) + `"`)
+		}
+		for _, f := range e.Fields {
+			b.WriteString(" " + syslogParamName(f.Key) + `="` + syslogParamValue(logValueString(f.Value)) + `"`)
+		}
+		b.WriteString("]")
+	}
+	if e.Msg != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Msg)
+	}
+	return b.String()
+}
+
+func syslogSeverity(level LogLevel) int {
+	switch level {
+	case LogLevelTrace, LogLevelDebug:
+		return 7
+	case LogLevelInfo:
+		return 6
+	case LogLevelWarn:
+		return 4
+	case LogLevelError:
+		return 3
+	case LogLevelFatal:
+		return 2
+	}
+	return 5
+}
+
+// 头部字段只能是可见的ascii字符，为空时使用-
+func syslogHeaderField(s string, max int) string {
+	b := []byte(s)
+	n := 0
+	for _, c := range b {
+		if c > 32 && c < 127 {
+			b[n] = c
+			n++
+		}
+	}
+	if n == 0 {
+		return "-"
+	}
+	if n > max {
+		n = max
+	}
+	return string(b[:n])
+}
+
+func syslogParamName(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			return '_'
+		}
+		return c
+	}, s)
+	if s == "" {
+		return "_"
+	}
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+func syslogParamValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
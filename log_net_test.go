@@ -0,0 +1,180 @@
+package antnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_NetLoggerTcp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := &NetLogger{Addr: ln.Addr().String()}
+	defer l.close()
+	l.Write("hello")
+	l.Write("world")
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reader := bufio.NewReader(c)
+	for _, want := range []string{"hello", "world"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want+"\n" {
+			t.Fatal(line, err)
+		}
+	}
+
+	// 服务器断开后自动重连，断开期间的日志缓存起来
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+	l.Write("again")
+	c, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "again\n" {
+		t.Fatal(line, err)
+	}
+}
+
+func Test_NetLoggerLength(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := &NetLogger{Addr: ln.Addr().String(), Framing: NetLogFramingLength}
+	defer l.close()
+	l.Write("a\nb")
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(c, head); err != nil || binary.BigEndian.Uint32(head) != 3 {
+		t.Fatal(head, err)
+	}
+	data := make([]byte, 3)
+	if _, err := io.ReadFull(c, data); err != nil || string(data) != "a\nb" {
+		t.Fatal(string(data), err)
+	}
+}
+
+func Test_SyslogLoggerUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	l := &SyslogLogger{Addr: conn.LocalAddr().String(), Hostname: "host", AppName: "game"}
+	defer l.close()
+	l.WriteEntry(&LogEntry{Level: LogLevelError, Time: time.Now(), File: "a.go", Line: 3, Msg: "failed", Fields: []LogField{{"uid", 7}, {"reason", `a"]b`}}})
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<11>1 ") || !strings.HasSuffix(msg, ` host game `+l.procId+` - [antnet@32473 caller="a.go:3" uid="7" reason="a\"\]b"] failed`) {
+		t.Fatal(msg)
+	}
+}
+
+func Test_HttpLoggerGet(t *testing.T) {
+	c := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c <- req.URL.Query().Get("log")
+	}))
+	defer srv.Close()
+	l := &HttpLogger{Url: srv.URL, Get: true, BatchInterval: 10}
+	l.Write("a b&c=d")
+	select {
+	case s := <-c:
+		if s != "a b&c=d" {
+			t.Fatal(s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("not delivered")
+	}
+}
+
+func Test_HttpLoggerClose(t *testing.T) {
+	n := int32(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	l := &HttpLogger{Url: srv.URL, BatchSize: 2, BatchInterval: 60000, RetryInterval: 1000}
+	l.Write("lost")
+	//直接放入缓存，避免触发日志协程发送
+	l.lock.Lock()
+	l.lines = append(l.lines, "lost", "lost", "lost", "lost")
+	l.lock.Unlock()
+	// 关闭时服务器出错只尝试一次，不等待重试，后面的批次也不再发送
+	start := time.Now()
+	l.close()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("close waited %v", d)
+	}
+	if c := atomic.LoadInt32(&n); c != 1 || l.Dropped() != 5 {
+		t.Fatalf("requests:%v dropped:%v", c, l.Dropped())
+	}
+}
+
+func Test_NetLoggerClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := &NetLogger{Addr: ln.Addr().String()}
+	l.Write("first")
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(c)
+	if line, err := reader.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatal(line, err)
+	}
+	// 连接的错误日志不能写回这个输出
+	if l.app == DefApp || l.app.GetLog().Level() != LogLevelAllOff {
+		t.Fatal("sink connection should not log")
+	}
+
+	// 关闭时缓存和发送队列中的日志都要写出去
+	n := 1000
+	for i := 0; i < n; i++ {
+		l.Write("line")
+	}
+	l.close()
+	for i := 0; i < n; i++ {
+		if line, err := reader.ReadString('\n'); err != nil || line != "line\n" {
+			t.Fatalf("line %v:%q err:%v dropped:%v", i, line, err, l.Dropped())
+		}
+	}
+	if _, err := reader.ReadString('\n'); err != io.EOF {
+		t.Fatalf("connection not closed err:%v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package antnet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type entryLogger struct {
+	c chan *LogEntry
+}
+
+func (r *entryLogger) Write(str string) {}
+func (r *entryLogger) WriteEntry(e *LogEntry) {
+	r.c <- e
+}
+
+func Test_LogEncoder(t *testing.T) {
+	e := &LogEntry{
+		Level:  LogLevelWarn,
+		Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		File:   "log_test.go",
+		Line:   10,
+		Msg:    "send failed",
+		Fields: logFields([]interface{}{"msgque", 7, "err", errors.New("queue full"), "cmd"}),
+	}
+	text := (&TextLogEncoder{}).Encode(e)
+	if text != `[W][2024-01-02 03:04:05][log_test.go:10]:send failed msgque=7 err="queue full" !extra=cmd` {
+		t.Fatal(text)
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte((&JSONLogEncoder{}).Encode(e)), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["level"] != "warn" || m["caller"] != "log_test.go:10" || m["msg"] != "send failed" || m["msgque"] != 7.0 || m["err"] != "queue full" {
+		t.Fatal(m)
+	}
+}
+
+func Test_LogWith(t *testing.T) {
+	l := &entryLogger{c: make(chan *LogEntry, 4)}
+	log := NewLog(16, l)
+	defer log.Stop()
+	child := log.With("msgque", 1).With("user", "u1")
+	child.Info("hello %v", 2)
+	child.WarnKV("slow", "cmd", 3)
+	e := <-l.c
+	if e.Msg != "hello 2" || len(e.Fields) != 2 || e.Fields[1].Value != "u1" || !strings.HasPrefix(e.File, "log_test.go") {
+		t.Fatal(e)
+	}
+	e = <-l.c
+	if e.Level != LogLevelWarn || len(e.Fields) != 3 || e.Fields[2].Key != "cmd" {
+		t.Fatal(e)
+	}
+	if len(child.Fields()) != 2 {
+		t.Fatal(child.Fields())
+	}
+}
+
+func Test_LogSinkLevel(t *testing.T) {
+	all := &entryLogger{c: make(chan *LogEntry, 8)}
+	alert := &entryLogger{c: make(chan *LogEntry, 8)}
+	log := NewLog(16)
+	defer log.Stop()
+	log.SetLevel(LogLevelTrace)
+	log.SetLogger(all)
+	log.AddLogger(alert, LogLevelError, func(e *LogEntry) bool { return !strings.Contains(e.Msg, "ignore") })
+	log.Debug("debug")
+	log.Error("ignore me")
+	log.Error("alert")
+	for _, msg := range []string{"debug", "ignore me", "alert"} {
+		if e := <-all.c; e.Msg != msg {
+			t.Fatal(e.Msg)
+		}
+	}
+	if e := <-alert.c; e.Msg != "alert" || e.Level != LogLevelError || e.Line == 0 {
+		t.Fatal(e)
+	}
+	if !log.SetLoggerLevel(alert, LogLevelAllOff, nil) {
+		t.Fatal("logger not found")
+	}
+	log.Fatal("fatal")
+	<-all.c
+	select {
+	case e := <-alert.c:
+		t.Fatal(e.Msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type blockLogger struct {
+	c chan struct{}
+}
+
+func (r *blockLogger) Write(str string) {
+	<-r.c
+}
+
+func Test_LogOverflow(t *testing.T) {
+	for _, overflow := range []LogOverflow{LogOverflowDropNewest, LogOverflowDropOldest, LogOverflowSample} {
+		l := &blockLogger{c: make(chan struct{})}
+		log := NewLog(2, l)
+		log.SetOverflow(overflow, 100)
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 50; i++ {
+				log.Info("line %d", i)
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("log blocked", overflow)
+		}
+		if log.Dropped() < 40 {
+			t.Fatal(overflow, log.Dropped())
+		}
+		close(l.c)
+		log.Stop()
+	}
+}
+
+func Test_FileLoggerRotate(t *testing.T) {
+	f := &FileLogger{Rotate: FileRotateDaily, TimeZone: 8}
+	ts := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC).Unix() //东八区1月3日4点
+	start, end := f.periodOf(ts)
+	if start != time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC).Unix() || end-start != 86400 {
+		t.Fatal(start, end)
+	}
+	f.period = start
+	if f.periodName() != "20240103" {
+		t.Fatal(f.periodName())
+	}
+
+	dir := t.TempDir()
+	f = &FileLogger{Path: dir + "/app.log", Ln: true, Rotate: FileRotateHourly, MaxFiles: 2, Compress: true}
+	if !f.open() {
+		t.Fatal("open failed")
+	}
+	defer f.close()
+	for i := 0; i < 4; i++ {
+		f.Write("line")
+		f.period -= int64(i+1) * 3600
+		f.rotateAt = 0
+	}
+	f.Write("last")
+	time.Sleep(200 * time.Millisecond)
+	f.clean.Lock()
+	defer f.clean.Unlock()
+	entries, _ := os.ReadDir(dir)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 3 || names[0] != "app.log" {
+		t.Fatal(names)
+	}
+	for _, name := range names[1:] {
+		if !strings.HasPrefix(name, "app_") || !strings.HasSuffix(name, ".log.gz") {
+			t.Fatal(names)
+		}
+	}
+}
+
+func Test_FileLoggerRemoveOld(t *testing.T) {
+	dir := t.TempDir()
+	f := &FileLogger{Path: dir + "/app.log", MaxFiles: 1}
+	if !f.open() {
+		t.Fatal("open failed")
+	}
+	defer f.close()
+	rotated := []string{"app_20240103.log", "app_2024010304.log.gz", "app_20240103_040506.1.log"}
+	others := []string{"app_backup.log", "app_20240103.log.bak", "app_config.json", "app_1.txt", "myapp_20240103.log",
+		"app_2.log", "app_2024.log", "app_202401031.log", "app_20240103_0405.log", "app_1_2.log"}
+	for i, name := range append(rotated, others...) {
+		os.WriteFile(dir+"/"+name, nil, 0644)
+		mtime := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		os.Chtimes(dir+"/"+name, mtime, mtime)
+	}
+	f.removeOld()
+	for i, name := range rotated {
+		if _, err := os.Stat(dir + "/" + name); (err == nil) != (i == 0) {
+			t.Fatalf("rotated file %v err:%v", name, err)
+		}
+	}
+	for _, name := range append(others, "app.log") {
+		if _, err := os.Stat(dir + "/" + name); err != nil {
+			t.Fatalf("other file %v removed", name)
+		}
+	}
+}
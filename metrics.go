@@ -0,0 +1,272 @@
+package antnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 处理耗时统计区间，单位秒
+var MetricsLatencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type metricsCounter struct {
+	recvMsgs    int64
+	recvBytes   int64
+	sendMsgs    int64
+	sendBytes   int64
+	chanFull    int64
+	discard     int64
+	parseFail   int64
+	callTimeout int64
+	rateLimit   int64
+
+	latencyCount   int64
+	latencySum     int64 //纳秒
+	latencyBuckets []int64
+}
+
+type metricsMap struct {
+	sync.RWMutex
+	M map[int]*metricsCounter
+}
+
+// 单个消息队列的流量统计
+type MsgQueMetrics struct {
+	RecvMsgs  int64
+	RecvBytes int64
+	SendMsgs  int64
+	SendBytes int64
+}
+
+func (r NetType) String() string {
+	switch r {
+	case NetTypeTcp:
+		return "tcp"
+	case NetTypeUdp:
+		return "udp"
+	case NetTypeWs:
+		return "ws"
+	case NetTypeRudp:
+		return "rudp"
+	}
+	return "unknown"
+}
+
+// 统计数据属于实例，不同实例的消息队列分开统计
+func (r *App) getMetrics(netType NetType, cmdAct int) *metricsCounter {
+	key := int(netType)<<16 + cmdAct
+	r.metrics.RLock()
+	c, ok := r.metrics.M[key]
+	r.metrics.RUnlock()
+	if ok {
+		return c
+	}
+	r.metrics.Lock()
+	if c, ok = r.metrics.M[key]; !ok {
+		c = &metricsCounter{latencyBuckets: make([]int64, len(MetricsLatencyBuckets))}
+		r.metrics.M[key] = c
+	}
+	r.metrics.Unlock()
+	return c
+}
+
+func metricsMsg(m *Message) (cmdAct int, size int64) {
+	if m.Head != nil {
+		return m.CmdAct(), int64(MsgHeadSize + len(m.Data))
+	}
+	return 0, int64(len(m.Data))
+}
+
+func (r *msgQue) metricsRecv(m *Message) {
+	cmdAct, size := metricsMsg(m)
+	atomic.AddInt64(&r.metrics.RecvMsgs, 1)
+	atomic.AddInt64(&r.metrics.RecvBytes, size)
+	if !Config.EnableMetrics {
+		return
+	}
+	c := r.app.getMetrics(r.netType, cmdAct)
+	atomic.AddInt64(&c.recvMsgs, 1)
+	atomic.AddInt64(&c.recvBytes, size)
+}
+
+func (r *msgQue) metricsSend(m *Message) {
+	cmdAct, size := metricsMsg(m)
+	atomic.AddInt64(&r.metrics.SendMsgs, 1)
+	atomic.AddInt64(&r.metrics.SendBytes, size)
+	if !Config.EnableMetrics {
+		return
+	}
+	c := r.app.getMetrics(r.netType, cmdAct)
+	atomic.AddInt64(&c.sendMsgs, 1)
+	atomic.AddInt64(&c.sendBytes, size)
+}
+
+func (r *msgQue) metricsAdd(m *Message, field func(c *metricsCounter) *int64) {
+	if !Config.EnableMetrics {
+		return
+	}
+	cmdAct := 0
+	if m != nil && m.Head != nil {
+		cmdAct = m.CmdAct()
+	}
+	atomic.AddInt64(field(r.app.getMetrics(r.netType, cmdAct)), 1)
+}
+
+func metricsChanFull(c *metricsCounter) *int64    { return &c.chanFull }
+func metricsDiscard(c *metricsCounter) *int64     { return &c.discard }
+func metricsParseFail(c *metricsCounter) *int64   { return &c.parseFail }
+func metricsCallTimeout(c *metricsCounter) *int64 { return &c.callTimeout }
+func metricsRateLimit(c *metricsCounter) *int64   { return &c.rateLimit }
+
+func (r *msgQue) metricsLatency(m *Message, d time.Duration) {
+	cmdAct := 0
+	if m.Head != nil {
+		cmdAct = m.CmdAct()
+	}
+	c := r.app.getMetrics(r.netType, cmdAct)
+	atomic.AddInt64(&c.latencyCount, 1)
+	atomic.AddInt64(&c.latencySum, int64(d))
+	s := d.Seconds()
+	for i, b := range MetricsLatencyBuckets {
+		if s <= b {
+			atomic.AddInt64(&c.latencyBuckets[i], 1)
+			break
+		}
+	}
+}
+
+func (r *msgQue) GetMetrics() MsgQueMetrics {
+	return MsgQueMetrics{
+		RecvMsgs:  atomic.LoadInt64(&r.metrics.RecvMsgs),
+		RecvBytes: atomic.LoadInt64(&r.metrics.RecvBytes),
+		SendMsgs:  atomic.LoadInt64(&r.metrics.SendMsgs),
+		SendBytes: atomic.LoadInt64(&r.metrics.SendBytes),
+	}
+}
+
+// 以Prometheus文本格式输出DefApp的统计数据
+func WriteMetrics(w io.Writer) error {
+	return DefApp.WriteMetrics(w)
+}
+
+// 以Prometheus文本格式输出实例的统计数据，panic数量是整个进程的
+func (r *App) WriteMetrics(w io.Writer) error {
+	r.metrics.RLock()
+	keys := make([]int, 0, len(r.metrics.M))
+	for k := range r.metrics.M {
+		keys = append(keys, k)
+	}
+	r.metrics.RUnlock()
+	sort.Ints(keys)
+
+	buf := &bytes.Buffer{}
+	st := r.GetStatis()
+	stats := []struct {
+		name string
+		typ  string
+		help string
+		v    int64
+	}{
+		{"antnet_msgque_count", "gauge", "Number of msgques.", int64(st.MsgqueCount)},
+		{"antnet_go_count", "gauge", "Number of goroutines started by Go.", int64(st.GoCount)},
+		{"antnet_pool_go_count", "gauge", "Number of pool goroutines.", int64(st.PoolGoCount)},
+		{"antnet_panic_total", "counter", "Number of recovered panics in the process.", int64(st.PanicCount)},
+	}
+	for _, g := range stats {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", g.name, g.help, g.name, g.typ, g.name, g.v)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		field func(c *metricsCounter) *int64
+	}{
+		{"antnet_recv_msgs_total", "Messages received.", func(c *metricsCounter) *int64 { return &c.recvMsgs }},
+		{"antnet_recv_bytes_total", "Bytes received.", func(c *metricsCounter) *int64 { return &c.recvBytes }},
+		{"antnet_send_msgs_total", "Messages sent.", func(c *metricsCounter) *int64 { return &c.sendMsgs }},
+		{"antnet_send_bytes_total", "Bytes sent.", func(c *metricsCounter) *int64 { return &c.sendBytes }},
+		{"antnet_chan_full_total", "Write channel full events.", metricsChanFull},
+		{"antnet_discard_total", "Messages discarded.", metricsDiscard},
+		{"antnet_parse_fail_total", "Messages failed to parse.", metricsParseFail},
+		{"antnet_call_timeout_total", "Call timeouts.", metricsCallTimeout},
+		{"antnet_rate_limit_total", "Messages over rate limit.", metricsRateLimit},
+	}
+	for _, m := range counters {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, k := range keys {
+			c := r.getMetrics(NetType(k>>16), k&0xFFFF)
+			if v := atomic.LoadInt64(m.field(c)); v > 0 {
+				fmt.Fprintf(buf, "%s{%s} %d\n", m.name, metricsLabels(k), v)
+			}
+		}
+	}
+
+	name := "antnet_handler_seconds"
+	fmt.Fprintf(buf, "# HELP %s Handler execution time.\n# TYPE %s histogram\n", name, name)
+	for _, k := range keys {
+		c := r.getMetrics(NetType(k>>16), k&0xFFFF)
+		count := atomic.LoadInt64(&c.latencyCount)
+		if count == 0 {
+			continue
+		}
+		labels := metricsLabels(k)
+		var sum int64
+		for i, b := range MetricsLatencyBuckets {
+			sum += atomic.LoadInt64(&c.latencyBuckets[i])
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, b, sum)
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+		fmt.Fprintf(buf, "%s_sum{%s} %g\n", name, labels, time.Duration(atomic.LoadInt64(&c.latencySum)).Seconds())
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, count)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func metricsLabels(key int) string {
+	return fmt.Sprintf("net=\"%v\",cmd=\"%d\",act=\"%d\"", NetType(key>>16), (key>>8)&0xFF, key&0xFF)
+}
+
+func MetricsHandler() http.Handler {
+	return DefApp.MetricsHandler()
+}
+
+func (r *App) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteMetrics(w)
+	})
+}
+
+// 启动统计服务，访问addr的/metrics获取Prometheus格式的统计数据，调用后自动开启统计
+func StartMetricsServer(addr string) error {
+	return DefApp.StartMetricsServer(addr)
+}
+
+func (r *App) StartMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		r.LogError("start metrics server failed addr:%s err:%v", addr, err)
+		return err
+	}
+	Config.EnableMetrics = true
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.MetricsHandler())
+	server := &http.Server{Handler: mux}
+	r.Go2(func(cstop chan struct{}) {
+		<-cstop
+		server.Close()
+	})
+	r.Go(func() {
+		r.LogInfo("metrics server start addr:%s", addr)
+		server.Serve(listener)
+	})
+	return nil
+}
@@ -0,0 +1,59 @@
+package antnet
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func metricsTestScrape(t *testing.T, app *App) string {
+	w := httptest.NewRecorder()
+	app.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("bad content type %v", ct)
+	}
+	return w.Body.String()
+}
+
+func Test_Metrics(t *testing.T) {
+	old := Config.EnableMetrics
+	Config.EnableMetrics = true
+	defer func() { Config.EnableMetrics = old }()
+
+	app := NewApp(nil)
+	defer app.Stop()
+	other := NewApp(nil)
+	defer other.Stop()
+	msgque := callTestConnect(t, app, "tcp", callTestServer(t, app, "tcp"))
+	for i := 0; i < 5; i++ {
+		if err := msgque.Call(context.Background(), 1, 1, []byte("ping"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := metricsTestScrape(t, app)
+	//客户端和服务器在同一个实例中，各收到5个消息
+	for _, want := range []string{
+		"# TYPE antnet_msgque_count gauge\n",
+		"# HELP antnet_pool_go_count Number of pool goroutines.\n",
+		"# TYPE antnet_panic_total counter\nantnet_panic_total ",
+		`antnet_recv_msgs_total{net="tcp",cmd="1",act="1"} 10` + "\n",
+		`antnet_send_msgs_total{net="tcp",cmd="1",act="1"} 10` + "\n",
+		`antnet_send_bytes_total{net="tcp",cmd="1",act="1"} 160` + "\n",
+		`antnet_handler_seconds_bucket{net="tcp",cmd="1",act="1",le="+Inf"} 5` + "\n",
+		`antnet_handler_seconds_count{net="tcp",cmd="1",act="1"} 5` + "\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("missing %q in\n%s", want, s)
+		}
+	}
+	if m := msgque.GetMetrics(); m.SendMsgs != 5 || m.RecvMsgs != 5 {
+		t.Fatalf("bad msgque metrics %+v", m)
+	}
+
+	//其他实例的统计不受影响
+	if s := metricsTestScrape(t, other); strings.Contains(s, "antnet_recv_msgs_total{") {
+		t.Fatalf("metrics leaked to other app\n%s", s)
+	}
+}
@@ -1,14 +1,21 @@
 package antnet
 
 import (
+	"context"
+	"crypto/ecdh"
+	"crypto/tls"
 	"net"
+	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var DefMsgQueTimeout int = 180
+var DefCallTimeout int = 10000      //Call未设置截止时间时的默认超时，单位ms
+var DefMsgQueSendTimeout int = 1000 //SendPolicyTimeout未设置超时时间时的默认超时，单位ms
 
 type MsgType int
 
@@ -20,9 +27,10 @@ const (
 type NetType int
 
 const (
-	NetTypeTcp NetType = iota //TCP类型
-	NetTypeUdp                //UDP类型
-	NetTypeWs                 //websocket
+	NetTypeTcp  NetType = iota //TCP类型
+	NetTypeUdp                 //UDP类型
+	NetTypeWs                  //websocket
+	NetTypeRudp                //可靠UDP类型
 )
 
 type ConnType int
@@ -42,11 +50,14 @@ type IMsgQue interface {
 	LocalAddr() string
 	RemoteAddr() string
 	SetRealRemoteAddr(addr string)
+	GetSubprotocol() string //websocket协商后的子协议
 
 	Stop()
 	IsStop() bool
 	Available() bool
 	IsProxy() bool
+	//信任连接上的网关代理消息，后端应该只对网关的连接调用，未信任的连接收到代理消息会被关闭
+	SetProxyTrusted(trusted bool)
 
 	Send(m *Message) (re bool)
 	SendString(str string) (re bool)
@@ -54,7 +65,11 @@ type IMsgQue interface {
 	SendByteStr(str []byte) (re bool)
 	SendByteStrLn(str []byte) (re bool)
 	SendCallback(m *Message, c chan *Message) (re bool)
+	SendWithPolicy(m *Message, policy SendPolicy, timeout int) error //按指定策略发送，返回发送结果
+	SetSendPolicy(policy SendPolicy, timeout int)                    //设置Send使用的默认策略
+	SetSendHighWater(n int)                                          //设置写入通道高水位
 	DelCallback(m *Message)
+	Call(ctx context.Context, cmd, act uint8, req interface{}, resp interface{}) error
 	SetTimeout(t int)
 	SetCmdReadRaw()
 	GetTimeout() int
@@ -65,6 +80,7 @@ type IMsgQue interface {
 
 	SetUser(user interface{})
 	GetUser() interface{}
+	Logger() *FieldLog //带有消息队列id和用户的日志
 
 	SetGroupId(group string)
 	DelGroupId(group string)
@@ -73,14 +89,39 @@ type IMsgQue interface {
 	//服务器内部通讯时提升效率，比如战斗服发送消息到网关服，应该在连接建立时使用，cwriteCnt大于0表示重新设置cwrite缓存长度，内网一般发送较快，不用考虑
 	SetMultiplex(multiplex bool, cwriteCnt int) bool
 
+	SetCompress(codec CompressCodec, autoCompressLen uint32)
+
+	SetCipher(c ICipher)
+	GetCipher() ICipher
+	KeyExchange() bool //发起密钥交换，完成后带FlagEncrypt的消息会自动加解密，交换本身不做身份认证
+
+	GetMetrics() MsgQueMetrics
+
+	//开启心跳，interval为发送间隔，单位毫秒，0表示关闭，连续miss次没有回复断开连接
+	SetHeartbeat(interval, miss int)
+	GetRtt() time.Duration
+
+	SetRateLimit(limit *RateLimit)
+	SetCmdActRateLimit(cmd, act uint8, msgPerSec int)
+
+	SetActor(a *Actor)
+	GetActor() *Actor
+
+	//绑定到消息队列的定时器，消息队列关闭时自动停止，设置了Actor时在Actor中执行
+	AddTimer(inteval int, fn func(...interface{}) int, args ...interface{}) *Timer
+	AddCron(spec string, fn func(...interface{}), args ...interface{}) (*Timer, error)
+
 	tryCallback(msg *Message) (re bool)
 }
 
 type msgQue struct {
-	id uint32 //唯一标示
+	id  uint32 //唯一标示
+	app *App   //所属实例
 
 	cwrite  chan *Message //写入通道
+	cstop   chan struct{} //停止时关闭，写入通道不关闭，避免和发送的协程冲突
 	stop    int32         //停止标记
+	writing int32         //写入通道中和正在写的消息数量
 	msgTyp  MsgType       //消息类型
 	connTyp ConnType      //通道类型
 
@@ -89,17 +130,42 @@ type msgQue struct {
 	parserFactory IParserFactory
 	timeout       int //传输超时
 	lastTick      int64
-
-	init           bool
-	available      bool
-	multiplex      bool
-	callback       map[int]chan *Message
-	group          map[string]int
-	user           interface{}
-	callbackLock   sync.Mutex
-	gmsgId         uint16
-	discard        bool
-	realRemoteAddr string //当使用代理是，需要特殊设置客户端真实IP
+	chain         atomic.Value //*middlewareChain，中间件和处理函数的调用链
+
+	init         bool
+	available    int32
+	multiplex    bool
+	callback     map[int]chan *Message
+	group        map[string]int
+	user         interface{}
+	callbackLock sync.Mutex
+	gmsgId       uint16
+	callIndex    uint32
+	discard      bool
+
+	compressSet     bool
+	compressCodec   CompressCodec
+	autoCompressLen uint32
+	realRemoteAddr  string //当使用代理是，需要特殊设置客户端真实IP
+	cipher          ICipher
+	ecdhKey         *ecdh.PrivateKey
+	encryptWait     []*Message //密钥交换完成前需要加密的消息
+	netType         NetType
+	metrics         MsgQueMetrics
+	limitSet        int32
+	limiter         atomic.Value //*rateLimiter，nil表示不限制
+	actor           *Actor
+	timers          timerOwner
+	proxy           *proxyLink //网关连接上的虚拟消息队列
+	proxyTrusted    int32      //是否接受网关的代理消息
+	heartbeat       heartbeat
+	sendPolicy      atomic.Value //*sendPolicyConf，未设置时使用Config
+	sendHighWater   int32
+	sendHigh        int32
+	throttle        struct {
+		sync.Mutex
+		M []throttleMsg //限流延迟处理的消息，按到达顺序在定时器中处理
+	}
 }
 
 func (r *msgQue) SetUser(user interface{}) {
@@ -107,23 +173,41 @@ func (r *msgQue) SetUser(user interface{}) {
 }
 
 func (r *msgQue) getGMsg(add bool) *gMsg {
+	r.app.gmsgMapSync.Lock()
 	if add {
 		r.gmsgId++
 	}
-	gm := gmsgArray[r.gmsgId]
+	gm := r.app.gmsgArray[r.gmsgId]
+	r.app.gmsgMapSync.Unlock()
 	return gm
 }
 func (r *msgQue) SetCmdReadRaw() {
 
 }
 func (r *msgQue) Available() bool {
-	return r.available
+	return atomic.LoadInt32(&r.available) == 1
+}
+
+func (r *msgQue) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&r.available, 1)
+	} else {
+		atomic.StoreInt32(&r.available, 0)
+	}
 }
 
 func (r *msgQue) GetUser() interface{} {
 	return r.user
 }
 
+// 带有消息队列id和用户的日志
+func (r *msgQue) Logger() *FieldLog {
+	if r.user != nil {
+		return r.app.log.With("msgque", r.id, "user", r.user)
+	}
+	return r.app.log.With("msgque", r.id)
+}
+
 func (r *msgQue) GetHandler() IMsgHandler {
 	return r.handler
 }
@@ -147,7 +231,7 @@ func (r *msgQue) SetTimeout(t int) {
 }
 
 func (r *msgQue) isTimeout(tick *time.Timer) bool {
-	left := int(Timestamp - r.lastTick)
+	left := int(timestamp() - atomic.LoadInt64(&r.lastTick))
 	if left < r.timeout || r.timeout == 0 {
 		if r.timeout == 0 {
 			tick.Reset(time.Second * time.Duration(DefMsgQueTimeout))
@@ -156,7 +240,7 @@ func (r *msgQue) isTimeout(tick *time.Timer) bool {
 		}
 		return false
 	}
-	LogInfo("msgque close because timeout id:%v wait:%v timeout:%v", r.id, left, r.timeout)
+	r.app.LogInfo("msgque close because timeout id:%v wait:%v timeout:%v", r.id, left, r.timeout)
 	return true
 }
 
@@ -168,6 +252,10 @@ func (r *msgQue) Reconnect(t int) {
 
 }
 
+func (r *msgQue) GetSubprotocol() string {
+	return ""
+}
+
 func (r *msgQue) IsProxy() bool {
 	return r.realRemoteAddr != ""
 }
@@ -221,63 +309,98 @@ func (r *msgQue) SetDiscardMsg(discard bool) {
 	r.discard = discard
 }
 func (r *msgQue) Send(m *Message) (re bool) {
-	if r.discard {
-		LogWarn("msgque discard msg by setting msgque:%v", r.id)
-		return true
-	}
-	if m == nil || r.stop == 1 {
-		return
-	}
-	defer func() {
-		if err := recover(); err != nil {
-			re = false
-		}
-	}()
-	if Config.AutoCompressLen > 0 && m.Head != nil && m.Head.Len >= Config.AutoCompressLen && (m.Head.Flags&FlagCompress) == 0 {
-		m.Head.Flags |= FlagCompress
-		m.Data = GZipCompress(m.Data)
-		m.Head.Len = uint32(len(m.Data))
-	}
-	select {
-	case r.cwrite <- m:
-	default:
-		canDiscard := false
-		if m.Head != nil && m.Head.Flags&FlagCanDiscard > 0 {
-			canDiscard = true
-		}
-		LogWarn("msgque write channel full msgque:%v canDiscard:%v", r.id, canDiscard)
-		if canDiscard {
-			return false
-		}
-		r.cwrite <- m
-	}
-
-	return true
+	policy, timeout := r.getSendPolicy()
+	err := r.SendWithPolicy(m, policy, timeout)
+	return err == nil || err == ErrSendDropOldest
 }
 
 func (r *msgQue) SendCallback(m *Message, c chan *Message) (re bool) {
 	if c == nil || cap(c) < 1 {
-		LogError("try send callback but chan is null or no buffer")
+		r.app.LogError("try send callback but chan is null or no buffer")
 		return
 	}
-	if r.Send(m) {
-		r.setCallback(m.Tag(), c)
-	} else {
-		c <- nil
+	//先注册回调再发送，否则回复可能在注册前到达
+	r.setCallback(m.Tag(), c)
+	if !r.Send(m) {
+		r.callbackLock.Lock()
+		if r.callback[m.Tag()] == c {
+			delete(r.callback, m.Tag())
+		}
+		r.callbackLock.Unlock()
+		select {
+		case c <- nil:
+		default:
+		}
 		return
 	}
 	return true
 }
 
 func (r *msgQue) DelCallback(m *Message) {
-	if r.callback == nil {
-		return
-	}
 	r.callbackLock.Lock()
 	delete(r.callback, m.Tag())
 	r.callbackLock.Unlock()
 }
 
+func (r *msgQue) Call(ctx context.Context, cmd, act uint8, req interface{}, resp interface{}) error {
+	var data []byte
+	if d, ok := req.([]byte); ok {
+		data = d
+	} else if req != nil {
+		if r.parser == nil {
+			return ErrProtoPack
+		}
+		data = r.parser.PackMsg(req)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Millisecond*time.Duration(DefCallTimeout))
+		defer cancel()
+	}
+
+	index := uint16(atomic.AddUint32(&r.callIndex, 1))
+	if index == 0 {
+		index = uint16(atomic.AddUint32(&r.callIndex, 1))
+	}
+	m := NewMsg(cmd, act, index, 0, data)
+	c := make(chan *Message, 1)
+	if !r.SendCallback(m, c) {
+		return ErrNetClosed
+	}
+
+	var rm *Message
+	select {
+	case rm = <-c:
+	case <-ctx.Done():
+		r.DelCallback(m)
+		if ctx.Err() == context.DeadlineExceeded {
+			r.app.LogWarn("msgque call timeout msgque:%v cmd:%v act:%v index:%v", r.id, cmd, act, index)
+			r.metricsAdd(m, metricsCallTimeout)
+			return ErrNetTimeout
+		}
+		return ctx.Err()
+	}
+
+	if rm == nil {
+		return ErrNetClosed
+	}
+	if rm.Head != nil && rm.Head.Error != 0 {
+		return GetError(rm.Head.Error)
+	}
+	if resp == nil || len(rm.Data) == 0 {
+		return nil
+	}
+	if p, ok := resp.(*[]byte); ok {
+		*p = rm.Data
+		return nil
+	}
+	if up, ok := r.parser.(IMsgUnPacker); ok {
+		return up.UnPackMsg(rm.Data, resp)
+	}
+	return ErrProtoUnPack
+}
+
 func (r *msgQue) SendString(str string) (re bool) {
 	return r.Send(&Message{Data: []byte(str)})
 }
@@ -295,9 +418,6 @@ func (r *msgQue) SendByteStrLn(str []byte) (re bool) {
 }
 
 func (r *msgQue) tryCallback(msg *Message) (re bool) {
-	if r.callback == nil {
-		return false
-	}
 	defer func() {
 		if err := recover(); err != nil {
 
@@ -336,27 +456,111 @@ func (r *msgQue) setCallback(tag int, c chan *Message) {
 }
 
 func (r *msgQue) baseStop() {
-	if r.cwrite != nil {
-		close(r.cwrite)
+	if r.cstop != nil {
+		close(r.cstop)
 	}
+	r.timers.stopTimers()
+	r.stopProxy()
 
+	r.callbackLock.Lock()
 	for k, v := range r.callback {
-		Try(func() {
-			v <- nil
-		}, func(i interface{}) {
-
-		})
+		select {
+		case v <- nil:
+		default:
+		}
 		delete(r.callback, k)
 	}
-	msgqueMapSync.Lock()
-	delete(msgqueMap, r.id)
-	msgqueMapSync.Unlock()
-	LogInfo("msgque close id:%d", r.id)
+	r.callbackLock.Unlock()
+	r.app.delMsgQue(r.id)
+	r.app.LogInfo("msgque close id:%d", r.id)
+}
+func (r *msgQue) closeListen() {}
+
+// 基础结构没有Stop，通过实例找到具体的消息队列停止
+func (r *msgQue) stopMsgQue() {
+	if msgque := r.app.getMsgQue(r.id); msgque != nil {
+		msgque.Stop()
+	}
 }
+
+// 停止服务时取消等待中的重连，返回true表示已经取消，需要关闭消息队列
+func (r *msgQue) cancelConnect(connecting *int32) bool {
+	return (r.app.IsStop() || r.app.IsDraining()) && atomic.CompareAndSwapInt32(connecting, 1, 0)
+}
+
+// 发送队列和全局消息都已经写出
+func (r *msgQue) drained() bool {
+	if atomic.LoadInt32(&r.stop) == 1 || r.cwrite == nil {
+		return true
+	}
+	if len(r.cwrite) > 0 {
+		return false
+	}
+	r.app.gmsgMapSync.Lock()
+	caught := r.gmsgId == r.app.gmsgId
+	r.app.gmsgMapSync.Unlock()
+	//写协程在全局消息编号增加前已经计数，所以先检查编号再检查计数
+	return caught && atomic.LoadInt32(&r.writing) <= 0
+}
+
+// 消息放入写入通道或者写协程取到全局消息时加1，写完或者丢弃时减1，排空时等待计数为0
+func (r *msgQue) addWriting(m *Message) {
+	if m != nil {
+		atomic.AddInt32(&r.writing, 1)
+	}
+}
+
+func (r *msgQue) doneWriting(m *Message) {
+	if m != nil {
+		atomic.AddInt32(&r.writing, -1)
+	}
+}
+
+type msgQueDrainer interface {
+	closeListen()
+	drained() bool
+}
+
 func (r *msgQue) processMsg(msgque IMsgQue, msg *Message) (re bool) {
+	if r.isProxyMsg(msg) {
+		if atomic.LoadInt32(&r.proxyTrusted) == 0 {
+			r.app.LogError("msgque recv proxy msg from untrusted peer msgque:%v addr:%v", r.id, msgque.RemoteAddr())
+			return false
+		}
+		Try(func() {
+			re = r.onProxyMsg(msgque, msg)
+		}, nil)
+		return re
+	}
+	if msg.Head != nil && msg.Head.Flags&FlagKeyExchange > 0 {
+		//密钥交换在读协程里面处理，保证后续的加密消息到达时密钥已经设置
+		r.metricsRecv(msg)
+		Try(func() {
+			re = r.onKeyExchange(msg)
+		}, nil)
+		return re
+	}
+	if process, ok := r.checkRateLimit(msgque, msg); !process {
+		return ok
+	}
+	return r.dispatchMsg(msgque, msg)
+}
+
+// 按Actor，多路复用或者读协程直接处理的方式分发消息，返回false表示关闭连接
+func (r *msgQue) dispatchMsg(msgque IMsgQue, msg *Message) (re bool) {
 	re = true
-	if r.multiplex {
-		Go(func() {
+	if a := r.GetActor(); a != nil {
+		//Actor邮箱满时阻塞读协程，对发送方施加背压
+		if err := a.PostWait(func() {
+			if !r.processMsgTrue(msgque, msg) {
+				msgque.Stop()
+			}
+		}, DefActorPostTimeout); err != nil {
+			r.app.LogWarn("msgque actor post failed drop msg msgque:%v cmd:%v act:%v err:%v", r.id, msg.Cmd(), msg.Act(), err)
+			r.metricsAdd(msg, metricsDiscard)
+		}
+	} else if r.multiplex {
+		r.app.Go(func() {
 			r.processMsgTrue(msgque, msg)
 		})
 	} else {
@@ -367,21 +571,42 @@ func (r *msgQue) processMsg(msgque IMsgQue, msg *Message) (re bool) {
 	return re
 }
 func (r *msgQue) processMsgTrue(msgque IMsgQue, msg *Message) bool {
-	if msg.Head != nil && msg.Head.Flags&FlagCompress > 0 && msg.Data != nil {
-		data, err := GZipUnCompress(msg.Data)
+	r.metricsRecv(msg)
+	if msg.Head != nil && msg.Head.Flags&FlagHeartbeat > 0 {
+		return r.onHeartbeat(msg)
+	}
+	if msg.Head != nil && msg.Head.Flags&FlagEncrypt > 0 && msg.Data != nil {
+		c := r.GetCipher()
+		if c == nil {
+			r.app.LogError("msgque recv encrypt msg but cipher not set msgque:%v cmd:%v act:%v", msgque.Id(), msg.Head.Cmd, msg.Head.Act)
+			return false
+		}
+		data, err := c.Decrypt(msg.Data)
 		if err != nil {
-			LogError("msgque uncompress failed msgque:%v cmd:%v act:%v len:%v err:%v", msgque.Id(), msg.Head.Cmd, msg.Head.Act, msg.Head.Len, err)
+			r.app.LogError("msgque decrypt failed msgque:%v cmd:%v act:%v len:%v err:%v", msgque.Id(), msg.Head.Cmd, msg.Head.Act, msg.Head.Len, err)
+			r.metricsAdd(msg, metricsParseFail)
 			return false
 		}
 		msg.Data = data
-		msg.Head.Flags -= FlagCompress
+		msg.Head.Flags -= FlagEncrypt
 		msg.Head.Len = uint32(len(msg.Data))
 	}
+	if msg.Head != nil && msg.Head.Flags&FlagCompress > 0 && msg.Data != nil {
+		if err := r.uncompressMsg(msg); err != nil {
+			r.app.LogError("msgque uncompress failed msgque:%v cmd:%v act:%v len:%v codec:%v err:%v", msgque.Id(), msg.Head.Cmd, msg.Head.Act, msg.Head.Len, msg.Head.CompressCodec(), err)
+			r.metricsAdd(msg, metricsParseFail)
+			return false
+		}
+	}
 	if r.parser != nil {
 		mp, err := r.parser.ParseC2S(msg)
 		if err == nil {
 			msg.IMsgParser = mp
 		} else {
+			if msgque.tryCallback(msg) {
+				return true
+			}
+			r.metricsAdd(msg, metricsParseFail)
 			if r.parser.GetErrType() == ParseErrTypeSendRemind {
 				if msg.Head != nil {
 					r.Send(r.parser.GetRemindMsg(err, r.msgTyp).CopyTag(msg))
@@ -399,9 +624,12 @@ func (r *msgQue) processMsgTrue(msgque IMsgQue, msg *Message) bool {
 	if msgque.tryCallback(msg) {
 		return true
 	}
-	f := r.handler.GetHandlerFunc(msgque, msg)
-	if f == nil {
-		f = r.handler.OnProcessMsg
+	f := r.getHandlerChain()
+	if Config.EnableMetrics {
+		start := time.Now()
+		defer func() {
+			r.metricsLatency(msg, time.Since(start))
+		}()
 	}
 	return f(msgque, msg)
 }
@@ -412,19 +640,28 @@ type IMsgHandler interface {
 	OnNewMsgQue(msgque IMsgQue) bool                         //新的消息队列
 	OnDelMsgQue(msgque IMsgQue)                              //消息队列关闭
 	OnProcessMsg(msgque IMsgQue, msg *Message) bool          //默认的消息处理函数
-	OnConnectComplete(msgque IMsgQue, ok bool) bool          //连接成功
+	OnConnectComplete(msgque IMsgQue, ok bool) bool          //连接完成，udp没有握手，只要地址有效就是成功
 	GetHandlerFunc(msgque IMsgQue, msg *Message) HandlerFunc //根据消息获得处理函数
+	//消息超过限流时调用，返回实际的处理方式
+	OnRateLimit(msgque IMsgQue, msg *Message, action RateLimitAction) RateLimitAction
+	//写入通道中的消息数达到高水位时调用，size为当前数量，capacity为通道容量，在发送者的协程中调用，不能阻塞
+	OnSendQueueHigh(msgque IMsgQue, size, capacity int)
 }
 
 type DefMsgHandler struct {
-	msgMap  map[int]HandlerFunc
-	typeMap map[reflect.Type]HandlerFunc
+	msgMap     map[int]HandlerFunc
+	typeMap    map[reflect.Type]HandlerFunc
+	middleware []Middleware
 }
 
-func (r *DefMsgHandler) OnNewMsgQue(msgque IMsgQue) bool                { return true }
-func (r *DefMsgHandler) OnDelMsgQue(msgque IMsgQue)                     {}
-func (r *DefMsgHandler) OnProcessMsg(msgque IMsgQue, msg *Message) bool { return true }
-func (r *DefMsgHandler) OnConnectComplete(msgque IMsgQue, ok bool) bool { return true }
+func (r *DefMsgHandler) OnNewMsgQue(msgque IMsgQue) bool                    { return true }
+func (r *DefMsgHandler) OnDelMsgQue(msgque IMsgQue)                         {}
+func (r *DefMsgHandler) OnProcessMsg(msgque IMsgQue, msg *Message) bool     { return true }
+func (r *DefMsgHandler) OnConnectComplete(msgque IMsgQue, ok bool) bool     { return true }
+func (r *DefMsgHandler) OnSendQueueHigh(msgque IMsgQue, size, capacity int) {}
+func (r *DefMsgHandler) OnRateLimit(msgque IMsgQue, msg *Message, action RateLimitAction) RateLimitAction {
+	return action
+}
 func (r *DefMsgHandler) GetHandlerFunc(msgque IMsgQue, msg *Message) HandlerFunc {
 	if msg.Head == nil {
 		if r.typeMap != nil {
@@ -470,68 +707,155 @@ func (r *EchoMsgHandler) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
 }
 
 func StartServer(addr string, typ MsgType, handler IMsgHandler, parser IParserFactory) error {
+	return DefApp.StartServer(addr, typ, handler, parser)
+}
+
+func (r *App) StartServer(addr string, typ MsgType, handler IMsgHandler, parser IParserFactory) error {
 	addrs := strings.Split(addr, "://")
 	if addrs[0] == "tcp" || addrs[0] == "all" {
 		listen, err := net.Listen("tcp", addrs[1])
 		if err == nil {
-			msgque := newTcpListen(listen, typ, handler, parser, addr)
-			Go(func() {
-				LogDebug("process listen for tcp msgque:%d", msgque.id)
+			if Config.ProxyProtocol {
+				listen = &proxyProtoListener{listen}
+			}
+			msgque := newTcpListen(r, listen, typ, handler, parser, addr)
+			r.Go(func() {
+				r.LogDebug("process listen for tcp msgque:%d", msgque.id)
+				msgque.listen()
+				r.LogDebug("process listen end for tcp msgque:%d", msgque.id)
+			})
+		} else {
+			r.LogError("listen on %s failed, errstr:%s", addr, err)
+			return err
+		}
+	}
+	if addrs[0] == "tls" {
+		conf, err := newTlsServerConfig()
+		if err != nil {
+			r.LogError("listen on %s failed, tls config err:%v", addr, err)
+			return err
+		}
+		listen, err := net.Listen("tcp", addrs[1])
+		if err == nil {
+			if Config.ProxyProtocol {
+				listen = &proxyProtoListener{listen}
+			}
+			msgque := newTcpListen(r, tls.NewListener(listen, conf), typ, handler, parser, addr)
+			r.Go(func() {
+				r.LogDebug("process listen for tls msgque:%d", msgque.id)
 				msgque.listen()
-				LogDebug("process listen end for tcp msgque:%d", msgque.id)
+				r.LogDebug("process listen end for tls msgque:%d", msgque.id)
 			})
 		} else {
-			LogError("listen on %s failed, errstr:%s", addr, err)
+			r.LogError("listen on %s failed, errstr:%s", addr, err)
 			return err
 		}
 	}
-	if addrs[0] == "udp" || addrs[0] == "all" {
+	if addrs[0] == "udp" || addrs[0] == "rudp" || addrs[0] == "all" {
 		naddr, err := net.ResolveUDPAddr("udp", addrs[1])
 		if err != nil {
-			LogError("listen on %s failed, errstr:%s", addr, err)
+			r.LogError("listen on %s failed, errstr:%s", addr, err)
 			return err
 		}
 		conn, err := net.ListenUDP("udp", naddr)
 		if err == nil {
-			msgque := newUdpListen(conn, typ, handler, parser, addr)
-			Go(func() {
-				LogDebug("process listen for udp msgque:%d", msgque.id)
+			msgque := newUdpListen(r, conn, typ, handler, parser, addr, addrs[0] == "rudp")
+			r.Go(func() {
+				r.LogDebug("process listen for udp msgque:%d", msgque.id)
 				msgque.listen()
-				LogDebug("process listen end for udp msgque:%d", msgque.id)
+				r.LogDebug("process listen end for udp msgque:%d", msgque.id)
 			})
 		} else {
-			LogError("listen on %s failed, errstr:%s", addr, err)
+			r.LogError("listen on %s failed, errstr:%s", addr, err)
 			return err
 		}
 	}
 	if addrs[0] == "ws" || addrs[0] == "wss" {
-		naddr := strings.SplitN(addrs[1], "/", 2)
-		url := "/"
-		if len(naddr) > 1 {
-			url = "/" + naddr[1]
+		return r.StartWsServer(addr, nil, typ, handler, parser)
+	}
+	return nil
+}
+
+// 启动websocket服务，地址格式为ws://ip:port/path或者wss://ip:port/path，conf为nil时使用DefWsConfig
+func StartWsServer(addr string, conf *WsConfig, typ MsgType, handler IMsgHandler, parser IParserFactory) error {
+	return DefApp.StartWsServer(addr, conf, typ, handler, parser)
+}
+
+func (r *App) StartWsServer(addr string, conf *WsConfig, typ MsgType, handler IMsgHandler, parser IParserFactory) error {
+	addrs := strings.Split(addr, "://")
+	if len(addrs) != 2 || (addrs[0] != "ws" && addrs[0] != "wss") {
+		r.LogError("listen on %s failed, bad ws addr", addr)
+		return ErrNetUnreachable
+	}
+	naddr := strings.SplitN(addrs[1], "/", 2)
+	url := "/"
+	if len(naddr) > 1 {
+		url = "/" + naddr[1]
+	}
+	if conf == nil {
+		c := DefWsConfig
+		conf = &c
+	}
+
+	mux := conf.ServeMux
+	var server *http.Server
+	var listen net.Listener
+	if mux == nil {
+		server = conf.Server
+		if server == nil {
+			server = &http.Server{}
+		}
+		if server.Handler == nil {
+			mux = http.NewServeMux()
+			server.Handler = mux
+		} else if m, ok := server.Handler.(*http.ServeMux); ok {
+			mux = m
+		} else {
+			r.LogError("listen on %s failed, server handler must be nil or *http.ServeMux", addr)
+			return ErrWsPathConflict
 		}
-		if addrs[0] == "wss" {
-			Config.EnableWss = true
+		var err error
+		listen, err = net.Listen("tcp", naddr[0])
+		if err != nil {
+			r.LogError("listen on %s failed, errstr:%s", addr, err)
+			return err
+		}
+		if Config.ProxyProtocol {
+			listen = &proxyProtoListener{listen}
 		}
-		if typ != MsgTypeCmd {
-			LogInfo("ws type msgque noly support MsgTypeCmd now auto set to MsgTypeCmd")
+	}
+
+	msgque := newWsListen(r, naddr[0], url, conf, server, typ, handler, parser)
+	msgque.secure = addrs[0] == "wss" || Config.EnableWss
+	if err := msgque.handle(mux); err != nil {
+		if listen != nil {
+			listen.Close()
 		}
-		msgque := newWsListen(naddr[0], url, MsgTypeCmd, handler, parser)
-		Go(func() {
-			LogDebug("process listen for ws msgque:%d", msgque.id)
-			msgque.listen()
-			LogDebug("process listen end for ws msgque:%d", msgque.id)
+		msgque.Stop()
+		return err
+	}
+	if listen != nil {
+		r.Go(func() {
+			r.LogDebug("process listen for ws msgque:%d", msgque.id)
+			msgque.listen(listen)
+			r.LogDebug("process listen end for ws msgque:%d", msgque.id)
 		})
 	}
 	return nil
 }
 
 func StartConnect(netType string, addr string, typ MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) IMsgQue {
+	return DefApp.StartConnect(netType, addr, typ, handler, parser, user)
+}
+
+func (r *App) StartConnect(netType string, addr string, typ MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) IMsgQue {
 	var msgque IMsgQue
 	if netType == "ws" || netType == "wss" {
-		msgque = newWsConn(addr, nil, typ, handler, parser, user)
+		msgque = newWsConn(r, addr, nil, typ, handler, parser, user)
+	} else if netType == "udp" || netType == "rudp" {
+		msgque = newUdpConn(r, addr, typ, handler, parser, user, netType == "rudp")
 	} else {
-		msgque = newTcpConn(netType, addr, nil, typ, handler, parser, user)
+		msgque = newTcpConn(r, netType, addr, nil, typ, handler, parser, user)
 	}
 	if handler.OnNewMsgQue(msgque) {
 		msgque.Reconnect(0)
@@ -0,0 +1,205 @@
+package antnet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+)
+
+var DefKeyExchangeWaitSize int = 64  //密钥交换完成前最多缓存的加密消息数量，超过后关闭消息队列
+var DefKeyExchangeTimeout int = 5000 //发起密钥交换后等待对方回复的最长时间，超时关闭消息队列，单位ms
+
+type ICipher interface {
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
+
+type AesGcmCipher struct {
+	aead cipher.AEAD
+}
+
+// 密钥长度16，24，32分别对应AES-128，AES-192，AES-256
+func NewAesGcmCipher(key []byte) (*AesGcmCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AesGcmCipher{aead: aead}, nil
+}
+
+func (r *AesGcmCipher) Encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, r.aead.NonceSize(), r.aead.NonceSize()+len(data)+r.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return r.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (r *AesGcmCipher) Decrypt(data []byte) ([]byte, error) {
+	ns := r.aead.NonceSize()
+	if len(data) < ns+r.aead.Overhead() {
+		return nil, ErrMsgLenTooShort
+	}
+	data, err := r.aead.Open(nil, data[:ns], data[ns:], nil)
+	if err != nil {
+		return nil, ErrMsgDecrypt
+	}
+	return data, nil
+}
+
+func (r *msgQue) SetCipher(c ICipher) {
+	r.callbackLock.Lock()
+	r.cipher = c
+	r.callbackLock.Unlock()
+}
+
+func (r *msgQue) GetCipher() ICipher {
+	r.callbackLock.Lock()
+	defer r.callbackLock.Unlock()
+	return r.cipher
+}
+
+// 发起密钥交换，一般在OnNewMsgQue或者OnConnectComplete中调用，交换完成前发送的加密消息会被缓存
+// 交换使用未认证的X25519，只能防止被动窃听，无法防止中间人攻击，需要认证时应设置Config.KeyExchangePSK，
+// 或者使用SetCipher设置预共享密钥，或者使用tls
+// 每个连接只能交换一次，交换完成或者已经设置了密钥后不再接受新的交换
+func (r *msgQue) KeyExchange() bool {
+	if r.msgTyp != MsgTypeMsg {
+		r.app.LogError("msgque key exchange only support MsgTypeMsg msgque:%v", r.id)
+		return false
+	}
+	if r.GetCipher() != nil {
+		r.app.LogError("msgque key exchange cipher already set msgque:%v", r.id)
+		return false
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		r.app.LogError("msgque key exchange generate key failed msgque:%v err:%v", r.id, err)
+		return false
+	}
+	r.callbackLock.Lock()
+	r.ecdhKey = key
+	r.callbackLock.Unlock()
+	r.app.SetTimeout(DefKeyExchangeTimeout, func(...interface{}) int {
+		r.callbackLock.Lock()
+		timeout := r.ecdhKey == key
+		r.callbackLock.Unlock()
+		if timeout {
+			r.app.LogError("msgque key exchange timeout msgque:%v", r.id)
+			r.stopMsgQue()
+		}
+		return 0
+	})
+	return r.Send(newKeyExchangeMsg(key))
+}
+
+func newKeyExchangeMsg(key *ecdh.PrivateKey) *Message {
+	data := key.PublicKey().Bytes()
+	return &Message{
+		Head: &MessageHead{Len: uint32(len(data)), Flags: FlagKeyExchange},
+		Data: data,
+	}
+}
+
+func (r *msgQue) onKeyExchange(msg *Message) bool {
+	pub, err := ecdh.X25519().NewPublicKey(msg.Data)
+	if err != nil {
+		r.app.LogError("msgque key exchange bad public key msgque:%v err:%v", r.id, err)
+		return false
+	}
+
+	r.callbackLock.Lock()
+	key, old := r.ecdhKey, r.cipher
+	r.callbackLock.Unlock()
+	if old != nil {
+		r.app.LogError("msgque key exchange refused cipher already set msgque:%v", r.id)
+		return false
+	}
+	reply := key == nil
+	if reply {
+		if key, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			r.app.LogError("msgque key exchange generate key failed msgque:%v err:%v", r.id, err)
+			return false
+		}
+	}
+
+	secret, err := key.ECDH(pub)
+	if err != nil {
+		r.app.LogError("msgque key exchange failed msgque:%v err:%v", r.id, err)
+		return false
+	}
+	sum := sha256.Sum256(append(secret, Config.KeyExchangePSK...))
+	c, err := NewAesGcmCipher(sum[:])
+	if err != nil {
+		r.app.LogError("msgque key exchange new cipher failed msgque:%v err:%v", r.id, err)
+		return false
+	}
+
+	if reply && !r.Send(newKeyExchangeMsg(key)) {
+		return false
+	}
+
+	r.callbackLock.Lock()
+	r.cipher = c
+	r.ecdhKey = nil
+	pending := r.encryptWait
+	r.encryptWait = nil
+	r.callbackLock.Unlock()
+	r.app.LogDebug("msgque key exchange complete msgque:%v", r.id)
+
+	for _, m := range pending {
+		r.Send(m)
+	}
+	return true
+}
+
+// 返回加密后的新消息，原消息不会被修改，wait为true表示密钥交换未完成，消息已缓存
+func (r *msgQue) encryptMsg(m *Message, cache bool) (em *Message, wait bool, err error) {
+	r.callbackLock.Lock()
+	c := r.cipher
+	if c == nil && r.ecdhKey != nil && cache {
+		if len(r.encryptWait) >= DefKeyExchangeWaitSize {
+			r.callbackLock.Unlock()
+			r.app.LogError("msgque key exchange wait full msgque:%v size:%v", r.id, DefKeyExchangeWaitSize)
+			r.stopMsgQue()
+			return nil, false, ErrSendQueueFull
+		}
+		r.encryptWait = append(r.encryptWait, m)
+		r.callbackLock.Unlock()
+		return nil, true, nil
+	}
+	r.callbackLock.Unlock()
+	if c == nil {
+		return nil, false, ErrMsgEncrypt
+	}
+
+	data, err := c.Encrypt(m.Data)
+	if err != nil {
+		return nil, false, err
+	}
+	head := *m.Head
+	head.forever = false
+	head.data = nil
+	head.Len = uint32(len(data))
+	return &Message{Head: &head, Data: data, IMsgParser: m.IMsgParser, User: m.User}, false, nil
+}
+
+// 全局消息在各自的写协程里面加密
+func (r *msgQue) encryptGMsg(m *Message) *Message {
+	if m == nil || m.Head == nil || m.Head.Flags&FlagEncrypt == 0 || m.Data == nil {
+		return m
+	}
+	em, _, err := r.encryptMsg(m, false)
+	if err != nil {
+		r.app.LogError("msgque encrypt global msg failed msgque:%v cmd:%v act:%v err:%v", r.id, m.Head.Cmd, m.Head.Act, err)
+		return nil
+	}
+	return em
+}
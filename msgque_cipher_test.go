@@ -0,0 +1,110 @@
+package antnet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+type cipherTestServer struct{ DefMsgHandler }
+
+func (r *cipherTestServer) OnNewMsgQue(msgque IMsgQue) bool {
+	msgque.SetMultiplex(true, 0)
+	return true
+}
+
+func (r *cipherTestServer) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	m := NewMsg(msg.Cmd(), msg.Act(), msg.Index(), 0, append([]byte("re:"), msg.Data...))
+	m.Head.Flags |= FlagEncrypt
+	msgque.Send(m)
+	return true
+}
+
+type cipherTestClient struct {
+	DefMsgHandler
+	cconn chan struct{}
+	cdel  chan struct{}
+}
+
+func (r *cipherTestClient) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	defer close(r.cconn)
+	return ok && msgque.KeyExchange()
+}
+
+func (r *cipherTestClient) OnDelMsgQue(msgque IMsgQue) {
+	close(r.cdel)
+}
+
+func Test_KeyExchange(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	addr := testStartServer(t, app, "tcp", MsgTypeMsg, &cipherTestServer{}, nil)
+	handler := &cipherTestClient{cconn: make(chan struct{}), cdel: make(chan struct{})}
+	msgque := app.StartConnect("tcp", addr, MsgTypeMsg, handler, nil, nil)
+	<-handler.cconn
+
+	//交换完成前发送的消息会被缓存，服务器多路复用时也必须能够解密
+	c := make(chan *Message, 1)
+	m := NewMsg(1, 2, 3, 0, []byte("secret"))
+	m.Head.Flags |= FlagEncrypt
+	msgque.SendCallback(m, c)
+	select {
+	case rm := <-c:
+		if rm == nil || string(rm.Data) != "re:secret" || rm.Flags()&FlagEncrypt != 0 {
+			t.Fatalf("bad reply %v", rm)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait encrypt reply timeout")
+	}
+
+	if msgque.KeyExchange() {
+		t.Fatal("key exchange should be refused after complete")
+	}
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	msgque.Send(newKeyExchangeMsg(key))
+	select {
+	case <-handler.cdel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server should close msgque on re-key")
+	}
+}
+
+func Test_KeyExchangeWaitLimit(t *testing.T) {
+	//没有写协程，对方永远收不到交换请求
+	msgque, _ := newSendTestMsgQue(t)
+	if !msgque.KeyExchange() {
+		t.Fatal("key exchange failed")
+	}
+	for i := 0; i < DefKeyExchangeWaitSize; i++ {
+		m := NewMsg(1, 2, 0, 0, []byte("secret"))
+		m.Head.Flags |= FlagEncrypt
+		if err := msgque.SendWithPolicy(m, SendPolicyNonBlock, 0); err != nil {
+			t.Fatalf("cache msg %v err:%v", i, err)
+		}
+	}
+	m := NewMsg(1, 2, 0, 0, []byte("secret"))
+	m.Head.Flags |= FlagEncrypt
+	if err := msgque.SendWithPolicy(m, SendPolicyNonBlock, 0); err != ErrSendQueueFull {
+		t.Fatalf("cache full err:%v", err)
+	}
+	if !msgque.IsStop() {
+		t.Fatal("msgque should be stopped when key exchange wait is full")
+	}
+}
+
+func Test_KeyExchangeTimeout(t *testing.T) {
+	old := DefKeyExchangeTimeout
+	DefKeyExchangeTimeout = 50
+	defer func() { DefKeyExchangeTimeout = old }()
+	msgque, _ := newSendTestMsgQue(t)
+	if !msgque.KeyExchange() {
+		t.Fatal("key exchange failed")
+	}
+	for i := 0; i < 100 && !msgque.IsStop(); i++ {
+		Sleep(10)
+	}
+	if !msgque.IsStop() {
+		t.Fatal("msgque should be stopped after key exchange timeout")
+	}
+}
@@ -0,0 +1,116 @@
+package antnet
+
+import "sync"
+
+type CompressCodec uint8
+
+// 压缩算法编号保存在消息头Flags的高4位，0为gzip，兼容旧版本
+const (
+	CompressGZip   CompressCodec = iota //gzip
+	CompressZlib                        //zlib
+	CompressSnappy                      //snappy，速度快
+	CompressS2                          //s2，速度接近lz4
+	CompressZstd                        //zstd，压缩率和速度比较均衡
+
+	CompressCodecMax CompressCodec = 15
+)
+
+const (
+	FlagCodecShift = 12
+	FlagCodecMask  = 0xF << FlagCodecShift
+)
+
+type ICompressor interface {
+	Compress(data []byte) []byte
+	UnCompress(data []byte) ([]byte, error)
+}
+
+type compressFunc struct {
+	compress   func(data []byte) []byte
+	uncompress func(data []byte) ([]byte, error)
+}
+
+func (r *compressFunc) Compress(data []byte) []byte {
+	return r.compress(data)
+}
+
+func (r *compressFunc) UnCompress(data []byte) ([]byte, error) {
+	return r.uncompress(data)
+}
+
+var compressorMap = struct {
+	sync.RWMutex
+	M [CompressCodecMax + 1]ICompressor
+}{M: [CompressCodecMax + 1]ICompressor{
+	CompressGZip:   &compressFunc{GZipCompress, GZipUnCompress},
+	CompressZlib:   &compressFunc{ZlibCompress, ZlibUnCompress},
+	CompressSnappy: &compressFunc{SnappyCompress, SnappyUnCompress},
+	CompressS2:     &compressFunc{S2Compress, S2UnCompress},
+	CompressZstd:   &compressFunc{ZstdCompress, ZstdUnCompress},
+}}
+
+// 注册或者替换压缩算法，编号范围0-15
+func RegisterCompressor(codec CompressCodec, c ICompressor) bool {
+	if codec > CompressCodecMax {
+		LogError("register compressor failed codec:%v out of range", codec)
+		return false
+	}
+	compressorMap.Lock()
+	compressorMap.M[codec] = c
+	compressorMap.Unlock()
+	return true
+}
+
+func GetCompressor(codec CompressCodec) ICompressor {
+	if codec > CompressCodecMax {
+		return nil
+	}
+	compressorMap.RLock()
+	c := compressorMap.M[codec]
+	compressorMap.RUnlock()
+	return c
+}
+
+func (r *MessageHead) CompressCodec() CompressCodec {
+	return CompressCodec((r.Flags & FlagCodecMask) >> FlagCodecShift)
+}
+
+// 设置消息队列的压缩算法和自动压缩长度，覆盖Config里面的全局设置，autoCompressLen为0表示不自动压缩
+func (r *msgQue) SetCompress(codec CompressCodec, autoCompressLen uint32) {
+	r.compressCodec = codec
+	r.autoCompressLen = autoCompressLen
+	r.compressSet = true
+}
+
+func (r *msgQue) compressMsg(m *Message) {
+	autoLen, codec := Config.AutoCompressLen, Config.CompressCodec
+	if r.compressSet {
+		autoLen, codec = r.autoCompressLen, r.compressCodec
+	}
+	if autoLen == 0 || m.Head == nil || m.Head.Len < autoLen || (m.Head.Flags&FlagCompress) > 0 {
+		return
+	}
+	c := GetCompressor(codec)
+	if c == nil {
+		r.app.LogError("msgque compress codec not found msgque:%v codec:%v", r.id, codec)
+		return
+	}
+	m.Head.Flags |= FlagCompress | uint16(codec)<<FlagCodecShift
+	m.Data = c.Compress(m.Data)
+	m.Head.Len = uint32(len(m.Data))
+}
+
+func (r *msgQue) uncompressMsg(msg *Message) error {
+	c := GetCompressor(msg.Head.CompressCodec())
+	if c == nil {
+		return ErrMsgUnCompress
+	}
+	data, err := c.UnCompress(msg.Data)
+	if err != nil {
+		return err
+	}
+	msg.Data = data
+	msg.Head.Flags &^= FlagCompress | FlagCodecMask
+	msg.Head.Len = uint32(len(msg.Data))
+	return nil
+}
@@ -0,0 +1,47 @@
+package antnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_CompressCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("antnet codec "), 200)
+	for c := CompressGZip; c <= CompressZstd; c++ {
+		msgque := &msgQue{}
+		msgque.SetCompress(c, 10)
+		m := NewMsg(1, 2, 0, 0, append([]byte(nil), data...))
+		msgque.compressMsg(m)
+		if m.Head.CompressCodec() != c || m.Head.Flags&FlagCompress == 0 || len(m.Data) >= len(data) {
+			t.Fatalf("codec %v compress flags:%x len:%v", c, m.Head.Flags, len(m.Data))
+		}
+		if err := msgque.uncompressMsg(m); err != nil || !bytes.Equal(m.Data, data) || m.Head.Flags != 0 || m.Head.Len != uint32(len(data)) {
+			t.Fatalf("codec %v uncompress err:%v", c, err)
+		}
+	}
+
+	//短消息不压缩
+	msgque := &msgQue{}
+	msgque.SetCompress(CompressZstd, 1024)
+	m := NewMsg(1, 2, 0, 0, []byte("short"))
+	msgque.compressMsg(m)
+	if m.Head.Flags&FlagCompress != 0 {
+		t.Fatal("short msg should not be compressed")
+	}
+}
+
+func Test_CompressCodecLimit(t *testing.T) {
+	for c := CompressGZip; c <= CompressZstd; c++ {
+		compressor := GetCompressor(c)
+		data, err := compressor.UnCompress(compressor.Compress(make([]byte, MaxMsgDataSize)))
+		if err != nil || len(data) != int(MaxMsgDataSize) {
+			t.Fatalf("codec %v max size len:%v err:%v", c, len(data), err)
+		}
+		if _, err := compressor.UnCompress(compressor.Compress(make([]byte, MaxMsgDataSize+1))); err != ErrMsgLenTooLong {
+			t.Fatalf("codec %v oversized err:%v", c, err)
+		}
+		if _, err := compressor.UnCompress([]byte("bad data")); err == nil {
+			t.Fatalf("codec %v bad data no error", c)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package antnet
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 心跳，定时发送ping，收到回复时计算往返时间，连续miss次没有回复断开连接
+// websocket使用协议层的ping pong，tcp和udp的MsgTypeMsg使用带FlagHeartbeat的消息，收到心跳的一方自动回复，不会交给处理器
+type heartbeat struct {
+	interval int
+	miss     int
+	missed   int32
+	wait     int32
+	rtt      int64
+	timer    *Timer
+	ping     func(data []byte) error
+	stop     func()
+}
+
+// 按Config设置默认心跳
+func initHeartbeat(msgque IMsgQue) {
+	if Config.HeartbeatInterval > 0 {
+		msgque.SetHeartbeat(Config.HeartbeatInterval, Config.HeartbeatMiss)
+	}
+}
+
+func (r *msgQue) SetHeartbeat(interval, miss int) {
+}
+
+func (r *tcpMsgQue) SetHeartbeat(interval, miss int) {
+	r.setHeartbeat(interval, miss, r.sendHeartbeat, r.Stop)
+}
+
+func (r *udpMsgQue) SetHeartbeat(interval, miss int) {
+	r.setHeartbeat(interval, miss, r.sendHeartbeat, r.Stop)
+}
+
+func (r *wsMsgQue) SetHeartbeat(interval, miss int) {
+	r.setHeartbeat(interval, miss, r.ping, r.Stop)
+}
+
+// 心跳测量的往返时间，没有开启心跳或者还没有收到回复时为0
+func (r *msgQue) GetRtt() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.heartbeat.rtt))
+}
+
+func (r *msgQue) setHeartbeat(interval, miss int, ping func(data []byte) error, stop func()) {
+	hb := &r.heartbeat
+	if hb.timer != nil {
+		hb.timer.Stop()
+		hb.timer = nil
+	}
+	hb.interval = 0
+	if interval <= 0 {
+		return
+	}
+	if r.msgTyp == MsgTypeCmd && r.netType != NetTypeWs {
+		r.app.LogWarn("msgque heartbeat need MsgTypeMsg msgque:%v", r.id)
+		return
+	}
+	if miss <= 0 {
+		miss = 1
+	}
+	hb.interval = interval
+	hb.miss = miss
+	hb.ping = ping
+	hb.stop = stop
+	atomic.StoreInt32(&hb.wait, 0)
+	atomic.StoreInt32(&hb.missed, 0)
+	hb.timer = newTimeout(interval, func(...interface{}) int {
+		if atomic.LoadInt32(&r.stop) == 1 {
+			return 0
+		}
+		if !r.Available() {
+			return interval
+		}
+		if atomic.LoadInt32(&hb.wait) == 1 {
+			if n := atomic.AddInt32(&hb.missed, 1); int(n) >= hb.miss {
+				r.app.LogInfo("msgque close because heartbeat miss id:%v miss:%v", r.id, n)
+				stop()
+				return 0
+			}
+		}
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+		atomic.StoreInt32(&hb.wait, 1)
+		if err := ping(data); err != nil {
+			r.app.LogDebug("msgque send heartbeat failed id:%v err:%v", r.id, err)
+		}
+		return interval
+	}, nil, nil, &r.timers)
+}
+
+// 连接成功后重新开始心跳，断线时心跳定时器已经结束，需要重置等待状态
+func (r *msgQue) restartHeartbeat() {
+	hb := &r.heartbeat
+	if hb.interval > 0 {
+		r.setHeartbeat(hb.interval, hb.miss, hb.ping, hb.stop)
+	}
+}
+
+func (r *msgQue) onHeartbeatAck(data []byte) {
+	hb := &r.heartbeat
+	if len(data) == 8 {
+		rtt := time.Now().UnixNano() - int64(binary.LittleEndian.Uint64(data))
+		if rtt >= 0 {
+			atomic.StoreInt64(&hb.rtt, rtt)
+		}
+	}
+	atomic.StoreInt32(&hb.wait, 0)
+	atomic.StoreInt32(&hb.missed, 0)
+	atomic.StoreInt64(&r.lastTick, timestamp())
+}
+
+func (r *msgQue) sendHeartbeat(data []byte) error {
+	if !r.Send(&Message{Head: &MessageHead{Len: uint32(len(data)), Flags: FlagHeartbeat}, Data: data}) {
+		return ErrNetClosed
+	}
+	return nil
+}
+
+func (r *msgQue) onHeartbeat(msg *Message) bool {
+	if msg.Head.Flags&FlagAck > 0 {
+		r.onHeartbeatAck(msg.Data)
+		return true
+	}
+	r.Send(&Message{Head: &MessageHead{Len: uint32(len(msg.Data)), Flags: FlagHeartbeat | FlagAck}, Data: msg.Data})
+	return true
+}
+
+func (r *wsMsgQue) ping(data []byte) error {
+	return r.conn.WriteControl(websocket.PingMessage, data, time.Now().Add(time.Second))
+}
+
+func (r *wsMsgQue) onPing(data string) error {
+	atomic.StoreInt64(&r.lastTick, timestamp())
+	err := r.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
+	return err
+}
+
+func (r *wsMsgQue) onPong(data string) error {
+	r.onHeartbeatAck([]byte(data))
+	return nil
+}
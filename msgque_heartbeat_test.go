@@ -0,0 +1,134 @@
+package antnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type heartbeatTestClient struct {
+	DefMsgHandler
+	interval  int
+	miss      int
+	reconnect bool
+	started   bool
+	cconn     chan struct{}
+	cdel      chan struct{}
+}
+
+func (r *heartbeatTestClient) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	if ok {
+		//只在第一次连接时设置，重连后心跳应该自动恢复
+		if !r.started {
+			r.started = true
+			msgque.SetHeartbeat(r.interval, r.miss)
+		}
+		r.cconn <- struct{}{}
+	}
+	return ok
+}
+
+func (r *heartbeatTestClient) OnDelMsgQue(msgque IMsgQue) {
+	r.cdel <- struct{}{}
+	if r.reconnect {
+		msgque.Reconnect(1)
+	}
+}
+
+func newHeartbeatTestClient(interval, miss int, reconnect bool) *heartbeatTestClient {
+	return &heartbeatTestClient{interval: interval, miss: miss, reconnect: reconnect, cconn: make(chan struct{}, 4), cdel: make(chan struct{}, 4)}
+}
+
+func heartbeatTestWait(t *testing.T, c chan struct{}, what string) {
+	select {
+	case <-c:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("wait %v timeout", what)
+	}
+}
+
+// 不回复心跳的服务器，收到的心跳通过c通知
+func heartbeatTestListen(t *testing.T, c chan net.Conn) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, MsgHeadSize)
+				for {
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					head := NewMessageHead(buf)
+					if head == nil {
+						return
+					}
+					if _, err := io.ReadFull(conn, make([]byte, head.Len)); err != nil {
+						return
+					}
+					if head.Flags&FlagHeartbeat > 0 {
+						c <- conn
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func Test_HeartbeatRtt(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	addr := testStartServer(t, app, "tcp", MsgTypeMsg, &EchoMsgHandler{}, nil)
+	handler := newHeartbeatTestClient(20, 2, false)
+	msgque := app.StartConnect("tcp", addr, MsgTypeMsg, handler, nil, nil)
+	heartbeatTestWait(t, handler.cconn, "connect")
+	for i := 0; i < 100 && msgque.GetRtt() == 0; i++ {
+		Sleep(10)
+	}
+	if rtt := msgque.GetRtt(); rtt <= 0 || rtt > time.Second {
+		t.Fatalf("bad rtt %v", rtt)
+	}
+	//对方一直回复，不会因为心跳断开
+	Sleep(100)
+	if msgque.IsStop() {
+		t.Fatal("msgque closed with heartbeat reply")
+	}
+}
+
+func Test_HeartbeatMiss(t *testing.T) {
+	cbeat := make(chan net.Conn, 16)
+	ln := heartbeatTestListen(t, cbeat)
+	defer ln.Close()
+	addr := ln.Addr().String()
+	app := NewApp(nil)
+	defer app.Stop()
+
+	handler := newHeartbeatTestClient(20, 3, true)
+	app.StartConnect("tcp", addr, MsgTypeMsg, handler, nil, nil)
+	heartbeatTestWait(t, handler.cconn, "connect")
+	start := time.Now()
+	heartbeatTestWait(t, handler.cdel, "heartbeat miss close")
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("closed too early %v", d)
+	}
+
+	//重连后心跳重新开始
+	heartbeatTestWait(t, handler.cconn, "reconnect")
+	for len(cbeat) > 0 {
+		<-cbeat
+	}
+	select {
+	case <-cbeat:
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat after reconnect")
+	}
+	heartbeatTestWait(t, handler.cdel, "heartbeat miss close after reconnect")
+}
@@ -0,0 +1,305 @@
+package antnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type RateLimitAction int
+
+const (
+	RateLimitThrottle RateLimitAction = iota //消息延迟到令牌足够时处理，后面的消息排队保持顺序
+	RateLimitRemind                          //丢弃消息并发送提醒
+	RateLimitDiscard                         //直接丢弃消息
+	RateLimitClose                           //关闭连接
+	RateLimitPass                            //不做限制，正常处理
+)
+
+var DefRateLimitThrottleSize int = 1024 //限流延迟处理的消息最多缓存的数量，超过后关闭连接
+
+type RateLimit struct {
+	MsgPerSec   int             //每秒消息数，0表示不限制
+	BytesPerSec int             //每秒字节数，0表示不限制
+	Burst       float64         //突发倍数，令牌桶容量为每秒数量乘以倍数，0表示1倍
+	Action      RateLimitAction //超过限制时的默认处理，可以在OnRateLimit里面修改
+}
+
+func (r *RateLimit) enable() bool {
+	return r.MsgPerSec > 0 || r.BytesPerSec > 0
+}
+
+type tokenBucket struct {
+	rate   float64 //每毫秒令牌数
+	burst  float64
+	tokens float64
+	last   int64
+}
+
+func newTokenBucket(perSec int, burst float64) *tokenBucket {
+	if perSec <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	b := float64(perSec) * burst
+	return &tokenBucket{rate: float64(perSec) / 1000, burst: b, tokens: b, last: nowTick()}
+}
+
+func (r *tokenBucket) refill() {
+	if now := nowTick(); r.last < now {
+		r.tokens += float64(now-r.last) * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+	}
+}
+
+// 是否有足够的令牌，超过桶容量的消息只要桶满就允许通过
+func (r *tokenBucket) allow(n float64) bool {
+	if r == nil {
+		return true
+	}
+	r.refill()
+	return r.tokens >= n || r.tokens >= r.burst
+}
+
+func (r *tokenBucket) take(n float64) {
+	if r != nil {
+		r.tokens -= n
+	}
+}
+
+// 扣除令牌，返回需要等待的毫秒数
+func (r *tokenBucket) wait(n float64) int64 {
+	if r == nil {
+		return 0
+	}
+	r.refill()
+	r.tokens -= n
+	if r.tokens >= 0 {
+		return 0
+	}
+	return int64(-r.tokens/r.rate) + 1
+}
+
+type throttleMsg struct {
+	msg *Message
+	due int64 //到期的毫秒数
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	limit  RateLimit
+	msgs   *tokenBucket
+	bytes  *tokenBucket
+	cmdAct map[int]*tokenBucket
+}
+
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:  *limit,
+		msgs:   newTokenBucket(limit.MsgPerSec, limit.Burst),
+		bytes:  newTokenBucket(limit.BytesPerSec, limit.Burst),
+		cmdAct: map[int]*tokenBucket{},
+	}
+}
+
+func (r *msgQue) getLimiter() *rateLimiter {
+	l, _ := r.limiter.Load().(*rateLimiter)
+	return l
+}
+
+// 设置消息队列的限流参数，nil表示不限制，未设置时Accept产生的消息队列使用Config.RateLimit
+func (r *msgQue) SetRateLimit(limit *RateLimit) {
+	r.callbackLock.Lock()
+	defer r.callbackLock.Unlock()
+	var cmdAct map[int]*tokenBucket
+	if old := r.getLimiter(); old != nil {
+		old.Lock()
+		cmdAct = old.cmdAct
+		old.Unlock()
+	}
+	defer atomic.StoreInt32(&r.limitSet, 1)
+	if (limit == nil || !limit.enable()) && len(cmdAct) == 0 {
+		r.limiter.Store((*rateLimiter)(nil))
+		return
+	}
+	if limit == nil {
+		limit = &RateLimit{Action: Config.RateLimit.Action}
+	}
+	l := newRateLimiter(limit)
+	if cmdAct != nil {
+		l.cmdAct = cmdAct
+	}
+	r.limiter.Store(l)
+}
+
+// 设置单个消息的每秒数量限制，msgPerSec为0表示取消限制
+func (r *msgQue) SetCmdActRateLimit(cmd, act uint8, msgPerSec int) {
+	r.callbackLock.Lock()
+	defer r.callbackLock.Unlock()
+	r.initLimiter()
+	l := r.getLimiter()
+	if l == nil {
+		l = newRateLimiter(&RateLimit{Action: Config.RateLimit.Action})
+		r.limiter.Store(l)
+	}
+	l.Lock()
+	if msgPerSec > 0 {
+		l.cmdAct[CmdAct(cmd, act)] = newTokenBucket(msgPerSec, l.limit.Burst)
+	} else {
+		delete(l.cmdAct, CmdAct(cmd, act))
+	}
+	l.Unlock()
+}
+
+// 调用时已经加锁，先发布限流器再设置标记，读协程看到标记时一定能看到限流器
+func (r *msgQue) initLimiter() {
+	if atomic.LoadInt32(&r.limitSet) == 0 {
+		if r.connTyp == ConnTypeAccept && Config.RateLimit.enable() {
+			r.limiter.Store(newRateLimiter(&Config.RateLimit))
+		}
+		atomic.StoreInt32(&r.limitSet, 1)
+	}
+}
+
+// 返回false表示关闭连接，process为false表示消息不需要处理
+func (r *msgQue) checkRateLimit(msgque IMsgQue, msg *Message) (process bool, re bool) {
+	if atomic.LoadInt32(&r.limitSet) == 0 {
+		r.callbackLock.Lock()
+		r.initLimiter()
+		r.callbackLock.Unlock()
+	}
+	l := r.getLimiter()
+	if l == nil {
+		return true, true
+	}
+
+	size := float64(len(msg.Data))
+	if msg.Head != nil {
+		size += MsgHeadSize
+	}
+	l.Lock()
+	var cmdAct *tokenBucket
+	if msg.Head != nil {
+		cmdAct = l.cmdAct[msg.CmdAct()]
+	}
+	r.throttle.Lock()
+	delayed := len(r.throttle.M) > 0
+	r.throttle.Unlock()
+	if delayed {
+		//前面有延迟处理的消息，排在后面保持顺序
+		wait := l.wait(cmdAct, size)
+		l.Unlock()
+		return r.throttleMsg(msgque, msg, wait)
+	}
+	if l.msgs.allow(1) && l.bytes.allow(size) && cmdAct.allow(1) {
+		l.msgs.take(1)
+		l.bytes.take(size)
+		cmdAct.take(1)
+		l.Unlock()
+		return true, true
+	}
+	action := l.limit.Action
+	l.Unlock()
+
+	action = r.handler.OnRateLimit(msgque, msg, action)
+	r.metricsAdd(msg, metricsRateLimit)
+	switch action {
+	case RateLimitThrottle:
+		l.Lock()
+		wait := l.wait(cmdAct, size)
+		l.Unlock()
+		return r.throttleMsg(msgque, msg, wait)
+	case RateLimitRemind:
+		r.app.LogWarn("msgque rate limit remind msgque:%v", r.id)
+		r.Send(r.rateLimitRemindMsg(msg))
+		return false, true
+	case RateLimitDiscard:
+		r.app.LogWarn("msgque rate limit discard msgque:%v", r.id)
+		return false, true
+	case RateLimitClose:
+		r.app.LogWarn("msgque rate limit close msgque:%v addr:%v", r.id, msgque.RemoteAddr())
+		return false, false
+	}
+	return true, true
+}
+
+// 扣除令牌，返回需要等待的毫秒数，调用时已经加锁
+func (r *rateLimiter) wait(cmdAct *tokenBucket, size float64) int64 {
+	wait := r.msgs.wait(1)
+	if w := r.bytes.wait(size); w > wait {
+		wait = w
+	}
+	if w := cmdAct.wait(1); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// 消息延迟到期后在定时器中处理，不阻塞读协程，只在读协程中调用
+func (r *msgQue) throttleMsg(msgque IMsgQue, msg *Message, wait int64) (process bool, re bool) {
+	r.throttle.Lock()
+	defer r.throttle.Unlock()
+	n := len(r.throttle.M)
+	if n == 0 && wait <= 0 {
+		return true, true
+	}
+	if n >= DefRateLimitThrottleSize {
+		r.app.LogWarn("msgque rate limit throttle full close msgque:%v addr:%v size:%v", r.id, msgque.RemoteAddr(), n)
+		return false, false
+	}
+	r.app.LogDebug("msgque rate limit throttle msgque:%v wait:%vms", r.id, wait)
+	r.throttle.M = append(r.throttle.M, throttleMsg{msg: msg, due: nowTick() + wait})
+	if n == 0 {
+		r.app.SetTimeout(int(wait), func(...interface{}) int {
+			return r.processThrottle(msgque)
+		})
+	}
+	return false, true
+}
+
+// 按顺序处理到期的消息，处理完才从队列中删除，读协程看到队列不为空时不会直接处理后面的消息
+// 返回距离下一个消息到期的毫秒数，0表示队列已经处理完
+func (r *msgQue) processThrottle(msgque IMsgQue) int {
+	for {
+		r.throttle.Lock()
+		if len(r.throttle.M) == 0 {
+			r.throttle.Unlock()
+			return 0
+		}
+		m := r.throttle.M[0]
+		r.throttle.Unlock()
+		if wait := m.due - nowTick(); wait > 0 {
+			return int(wait)
+		}
+		if msgque.IsStop() || !r.dispatchMsg(msgque, m.msg) {
+			msgque.Stop()
+			r.throttle.Lock()
+			r.throttle.M = nil
+			r.throttle.Unlock()
+			return 0
+		}
+		r.throttle.Lock()
+		r.throttle.M[0] = throttleMsg{}
+		r.throttle.M = r.throttle.M[1:]
+		r.throttle.Unlock()
+	}
+}
+
+func (r *msgQue) rateLimitRemindMsg(msg *Message) *Message {
+	var m *Message
+	if r.parser != nil {
+		m = r.parser.GetRemindMsg(ErrMsgRateLimit, r.msgTyp)
+	} else if r.msgTyp == MsgTypeMsg {
+		m = NewErrMsg(ErrMsgRateLimit)
+	} else {
+		m = NewStrMsg(ErrMsgRateLimit.Error() + "\n")
+	}
+	if msg.Head != nil && m.Head != nil {
+		m.CopyTag(msg)
+	}
+	return m
+}
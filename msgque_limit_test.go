@@ -0,0 +1,172 @@
+package antnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_TokenBucket(t *testing.T) {
+	b := newTokenBucket(1000, 2)
+	if b.tokens != 2000 || b.burst != 2000 {
+		t.Fatalf("bad init tokens:%v burst:%v", b.tokens, b.burst)
+	}
+	//把上次时间设置到将来，测试期间不会补充令牌
+	b.last = nowTick() + 1000000
+	b.take(2000)
+	if b.allow(1) {
+		t.Fatal("empty bucket should not allow")
+	}
+	if w := b.wait(10); w != 11 || b.tokens != -10 {
+		t.Fatalf("wait:%v tokens:%v", w, b.tokens)
+	}
+
+	//每毫秒补充1个令牌
+	b.tokens = 0
+	b.last = nowTick() - 100
+	b.refill()
+	if b.tokens < 100 || b.tokens > 110 {
+		t.Fatalf("refill tokens:%v", b.tokens)
+	}
+	b.last = nowTick() - 1000000
+	b.refill()
+	if b.tokens != 2000 {
+		t.Fatalf("refill over burst tokens:%v", b.tokens)
+	}
+
+	//超过桶容量的消息只要桶满就允许通过
+	if !b.allow(5000) {
+		t.Fatal("full bucket should allow msg larger than burst")
+	}
+}
+
+type limitTestServer struct {
+	DefMsgHandler
+	action RateLimitAction
+	lock   sync.Mutex
+	index  []uint16
+	limits int32
+	accept chan *tcpMsgQue
+}
+
+func (r *limitTestServer) OnNewMsgQue(msgque IMsgQue) bool {
+	msgque.SetRateLimit(&RateLimit{MsgPerSec: 100, Action: r.action})
+	r.accept <- msgque.(*tcpMsgQue)
+	return true
+}
+
+func (r *limitTestServer) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	r.lock.Lock()
+	r.index = append(r.index, msg.Index())
+	r.lock.Unlock()
+	return true
+}
+
+func (r *limitTestServer) OnRateLimit(msgque IMsgQue, msg *Message, action RateLimitAction) RateLimitAction {
+	atomic.AddInt32(&r.limits, 1)
+	return action
+}
+
+func (r *limitTestServer) processed() []uint16 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]uint16(nil), r.index...)
+}
+
+type limitTestClient struct {
+	callTestClient
+	reminds int32
+}
+
+func (r *limitTestClient) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	if msg.Head != nil && msg.Head.Error == ErrMsgRateLimit.Id {
+		atomic.AddInt32(&r.reminds, 1)
+	}
+	return true
+}
+
+// 令牌桶容量100，发送120个消息，超出20个，等待处理want个消息
+func limitTestRun(t *testing.T, action RateLimitAction, want int) (*limitTestServer, *limitTestClient, IMsgQue, time.Time) {
+	app := NewApp(nil)
+	t.Cleanup(app.Stop)
+	server := &limitTestServer{action: action, accept: make(chan *tcpMsgQue, 1)}
+	addr := testStartServer(t, app, "tcp", MsgTypeMsg, server, nil)
+	client := &limitTestClient{callTestClient: callTestClient{cconn: make(chan bool, 1)}}
+	msgque := app.StartConnect("tcp", addr, MsgTypeMsg, client, nil, nil)
+	if !<-client.cconn {
+		t.Fatalf("connect to %v failed", addr)
+	}
+	start := time.Now()
+	for i := 0; i < 120; i++ {
+		msgque.Send(NewMsg(1, 1, uint16(i), 0, nil))
+	}
+	for i := 0; i < 300 && len(server.processed()) < want; i++ {
+		Sleep(10)
+	}
+	return server, client, msgque, start
+}
+
+func Test_RateLimitThrottle(t *testing.T) {
+	server, _, msgque, start := limitTestRun(t, RateLimitThrottle, 0)
+	//读协程不等待，超出的消息读出来排队，排队期间按速度处理，记录最多排队的数量
+	accept := <-server.accept
+	delayed := 0
+	for i := 0; i < 200 && delayed < 20; i++ {
+		accept.throttle.Lock()
+		if n := len(accept.throttle.M); n > delayed {
+			delayed = n
+		}
+		accept.throttle.Unlock()
+		Sleep(1)
+	}
+	if delayed < 10 {
+		t.Fatalf("throttle delayed:%v", delayed)
+	}
+	for i := 0; i < 300 && len(server.processed()) < 120; i++ {
+		Sleep(10)
+	}
+	index := server.processed()
+	if len(index) != 120 {
+		t.Fatalf("throttle processed:%v", len(index))
+	}
+	for i, v := range index {
+		if v != uint16(i) {
+			t.Fatalf("throttle out of order at %v index:%v", i, v)
+		}
+	}
+	//超出的20个消息按每秒100个的速度处理
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("throttle too fast %v", d)
+	}
+	if atomic.LoadInt32(&server.limits) == 0 || msgque.IsStop() {
+		t.Fatalf("throttle limits:%v stop:%v", atomic.LoadInt32(&server.limits), msgque.IsStop())
+	}
+}
+
+func Test_RateLimitDiscard(t *testing.T) {
+	for _, action := range []RateLimitAction{RateLimitRemind, RateLimitDiscard} {
+		server, client, msgque, _ := limitTestRun(t, action, 100)
+		Sleep(100)
+		if n := len(server.processed()); n != 100 || msgque.IsStop() {
+			t.Fatalf("action %v processed:%v stop:%v", action, n, msgque.IsStop())
+		}
+		reminds := 0
+		if action == RateLimitRemind {
+			reminds = 20
+		}
+		if n := atomic.LoadInt32(&client.reminds); n != int32(reminds) {
+			t.Fatalf("action %v reminds:%v", action, n)
+		}
+	}
+}
+
+func Test_RateLimitClose(t *testing.T) {
+	server, _, msgque, _ := limitTestRun(t, RateLimitClose, 100)
+	for i := 0; i < 100 && !msgque.IsStop(); i++ {
+		Sleep(10)
+	}
+	if n := len(server.processed()); n != 100 || !msgque.IsStop() {
+		t.Fatalf("close processed:%v stop:%v", n, msgque.IsStop())
+	}
+}
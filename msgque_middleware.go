@@ -0,0 +1,130 @@
+package antnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 中间件，调用next继续处理，不调用next表示拦截消息，返回false会关闭连接
+type Middleware func(msgque IMsgQue, msg *Message, next HandlerFunc) bool
+
+var middlewareList = struct {
+	sync.RWMutex
+	M []Middleware
+}{}
+
+var middlewareVersion int32 //全局或者处理者的中间件改变时增加，消息队列据此重新生成调用链
+
+type middlewareChain struct {
+	version int32
+	f       HandlerFunc
+}
+
+// 全局中间件，对所有消息队列生效，在处理者的中间件之前执行
+func UseMiddleware(m ...Middleware) {
+	middlewareList.Lock()
+	middlewareList.M = append(append([]Middleware{}, middlewareList.M...), m...)
+	middlewareList.Unlock()
+	atomic.AddInt32(&middlewareVersion, 1)
+}
+
+func (r *DefMsgHandler) Use(m ...Middleware) {
+	r.middleware = append(r.middleware, m...)
+	atomic.AddInt32(&middlewareVersion, 1)
+}
+
+func (r *DefMsgHandler) Middlewares() []Middleware {
+	return r.middleware
+}
+
+// 带有中间件的处理者，嵌入DefMsgHandler即可实现
+type IMiddlewareHandler interface {
+	Middlewares() []Middleware
+}
+
+// 包装其他处理者的处理者应该实现Unwrap，返回被包装的处理者，用于查找中间件
+type IHandlerWrapper interface {
+	Unwrap() IMsgHandler
+}
+
+func getMiddleware(handler IMsgHandler) []Middleware {
+	for handler != nil {
+		if h, ok := handler.(IMiddlewareHandler); ok {
+			return h.Middlewares()
+		}
+		w, ok := handler.(IHandlerWrapper)
+		if !ok {
+			break
+		}
+		handler = w.Unwrap()
+	}
+	return nil
+}
+
+func chainMiddleware(f HandlerFunc, list []Middleware) HandlerFunc {
+	for i := len(list) - 1; i >= 0; i-- {
+		m, next := list[i], f
+		f = func(msgque IMsgQue, msg *Message) bool {
+			return m(msgque, msg, next)
+		}
+	}
+	return f
+}
+
+func wrapMiddleware(handler IMsgHandler, f HandlerFunc) HandlerFunc {
+	if list := getMiddleware(handler); len(list) > 0 {
+		f = chainMiddleware(f, list)
+	}
+	middlewareList.RLock()
+	list := middlewareList.M
+	middlewareList.RUnlock()
+	if len(list) > 0 {
+		f = chainMiddleware(f, list)
+	}
+	return f
+}
+
+// 调用链只在中间件改变后重新生成，不需要每个消息都生成，链的最后根据消息查找处理函数
+func (r *msgQue) getHandlerChain() HandlerFunc {
+	version := atomic.LoadInt32(&middlewareVersion)
+	if c, ok := r.chain.Load().(*middlewareChain); ok && c.version == version {
+		return c.f
+	}
+	c := &middlewareChain{version: version, f: wrapMiddleware(r.handler, r.handleMsg)}
+	r.chain.Store(c)
+	return c.f
+}
+
+func (r *msgQue) handleMsg(msgque IMsgQue, msg *Message) bool {
+	f := r.handler.GetHandlerFunc(msgque, msg)
+	if f == nil {
+		f = r.handler.OnProcessMsg
+	}
+	return f(msgque, msg)
+}
+
+// 回复错误消息，MsgTypeMsg会带上原消息的cmd，act和index
+func ReplyError(msgque IMsgQue, msg *Message, err error) bool {
+	if msgque.GetMsgType() == MsgTypeMsg {
+		m := NewErrMsg(err)
+		if msg != nil {
+			m.CopyTag(msg)
+		}
+		return msgque.Send(m)
+	}
+	return msgque.SendStringLn(err.Error())
+}
+
+// 捕获处理函数的异常并回复ErrServePanic，连接不会关闭
+func RecoverMiddleware(msgque IMsgQue, msg *Message, next HandlerFunc) (re bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			LogError("msgque handler panic msgque:%v cmd:%v act:%v err:%v", msgque.Id(), msg.Cmd(), msg.Act(), err)
+			LogStack()
+			atomic.AddInt32(&statis.PanicCount, 1)
+			statis.LastPanic = int(timestamp())
+			re = ReplyError(msgque, msg, ErrServePanic)
+		}
+	}()
+	return next(msgque, msg)
+}
@@ -0,0 +1,82 @@
+package antnet
+
+import (
+	"strings"
+	"testing"
+)
+
+type wrapTestHandler struct {
+	IMsgHandler
+}
+
+func (r *wrapTestHandler) Unwrap() IMsgHandler {
+	return r.IMsgHandler
+}
+
+func Test_WrapMiddleware(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(msgque IMsgQue, msg *Message, next HandlerFunc) bool {
+			order = append(order, name)
+			return next(msgque, msg)
+		}
+	}
+	inner := &DefMsgHandler{}
+	inner.Use(trace("a"), trace("b"))
+	final := func(msgque IMsgQue, msg *Message) bool {
+		order = append(order, "f")
+		return true
+	}
+
+	for _, h := range []IMsgHandler{inner, &wrapTestHandler{inner}, &wrapTestHandler{&wrapTestHandler{inner}}} {
+		order = nil
+		if !wrapMiddleware(h, final)(nil, nil) {
+			t.Fatal("handler should return true")
+		}
+		if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "f" {
+			t.Fatalf("%T bad middleware order %v", h, order)
+		}
+	}
+
+	order = nil
+	wrapMiddleware(&wrapTestHandler{}, final)(nil, nil)
+	if len(order) != 1 {
+		t.Fatalf("nil wrapped handler bad order %v", order)
+	}
+}
+
+func Test_MiddlewareChainCache(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	handler := &DefMsgHandler{}
+	var order []string
+	handler.Register(1, 1, func(msgque IMsgQue, msg *Message) bool {
+		order = append(order, "f")
+		return true
+	})
+	handler.Use(func(msgque IMsgQue, msg *Message, next HandlerFunc) bool {
+		order = append(order, "a")
+		return next(msgque, msg)
+	})
+	msgque := newTcpConn(app, "tcp", "127.0.0.1:1", nil, MsgTypeMsg, handler, nil, nil)
+	defer msgque.Stop()
+
+	//中间件不变时调用链只生成一次
+	msgque.processMsgTrue(msgque, NewMsg(1, 1, 0, 0, nil))
+	chain := msgque.chain.Load()
+	msgque.processMsgTrue(msgque, NewMsg(1, 1, 0, 0, nil))
+	if msgque.chain.Load() != chain || strings.Join(order, "") != "afaf" {
+		t.Fatalf("chain rebuilt order:%v", order)
+	}
+
+	//添加中间件后重新生成
+	order = nil
+	handler.Use(func(msgque IMsgQue, msg *Message, next HandlerFunc) bool {
+		order = append(order, "b")
+		return next(msgque, msg)
+	})
+	msgque.processMsgTrue(msgque, NewMsg(1, 1, 0, 0, nil))
+	if msgque.chain.Load() == chain || strings.Join(order, "") != "abf" {
+		t.Fatalf("chain not rebuilt order:%v", order)
+	}
+}
@@ -10,14 +10,17 @@ const (
 )
 
 const (
-	FlagEncrypt    = 1 << 0 //数据是经过加密的
-	FlagCompress   = 1 << 1 //数据是经过压缩的
-	FlagCanDiscard = 1 << 2 //消息是否可以丢弃，基于tcp网络的帧同步中，包可以主动丢弃
-	FlagNeedAck    = 1 << 3 //消息需要确认
-	FlagAck        = 1 << 4 //确认消息
-	FlagReSend     = 1 << 5 //重发消息
-	FlagClient     = 1 << 6 //消息来自客服端，用于判断index来之服务器还是其他玩家
-	FlagUdpProxy   = 1 << 7 //udp代理
+	FlagEncrypt     = 1 << 0  //数据是经过加密的
+	FlagCompress    = 1 << 1  //数据是经过压缩的
+	FlagCanDiscard  = 1 << 2  //消息是否可以丢弃，基于tcp网络的帧同步中，包可以主动丢弃
+	FlagNeedAck     = 1 << 3  //消息需要确认
+	FlagAck         = 1 << 4  //确认消息
+	FlagReSend      = 1 << 5  //重发消息
+	FlagClient      = 1 << 6  //消息来自客服端，用于判断index来之服务器还是其他玩家
+	FlagUdpProxy    = 1 << 7  //udp代理
+	FlagKeyExchange = 1 << 8  //密钥交换，数据为公钥
+	FlagProxy       = 1 << 9  //网关代理消息，数据为会话id和客户端消息
+	FlagHeartbeat   = 1 << 10 //心跳，带FlagAck表示心跳回复，数据为发送时间
 )
 
 var MaxMsgDataSize uint32 = 1024 * 1024
@@ -34,29 +37,37 @@ type MessageHead struct {
 	data    []byte
 }
 
-func (r *MessageHead) Bytes() []byte {
-	if r.forever && r.data != nil {
-		return r.data
-	}
-	r.data = make([]byte, MsgHeadSize)
-	phead := (*MessageHead)(unsafe.Pointer(&r.data[0]))
+// 消息头在数据中的布局，不能直接把数据转换成MessageHead，它后面还有其他字段
+type messageHeadData struct {
+	Len   uint32
+	Error uint16
+	Cmd   uint8
+	Act   uint8
+	Index uint16
+	Flags uint16
+}
+
+func (r *MessageHead) put(data []byte) {
+	phead := (*messageHeadData)(unsafe.Pointer(&data[0]))
 	phead.Len = r.Len
 	phead.Error = r.Error
 	phead.Cmd = r.Cmd
 	phead.Act = r.Act
 	phead.Index = r.Index
 	phead.Flags = r.Flags
+}
+
+func (r *MessageHead) Bytes() []byte {
+	if r.forever && r.data != nil {
+		return r.data
+	}
+	r.data = make([]byte, MsgHeadSize)
+	r.put(r.data)
 	return r.data
 }
 
 func (r *MessageHead) FastBytes(data []byte) []byte {
-	phead := (*MessageHead)(unsafe.Pointer(&data[0]))
-	phead.Len = r.Len
-	phead.Error = r.Error
-	phead.Cmd = r.Cmd
-	phead.Act = r.Act
-	phead.Index = r.Index
-	phead.Flags = r.Flags
+	r.put(data)
 	return data
 }
 
@@ -66,13 +77,7 @@ func (r *MessageHead) BytesWithData(wdata []byte) []byte {
 	}
 	r.Len = uint32(len(wdata))
 	r.data = make([]byte, MsgHeadSize+r.Len)
-	phead := (*MessageHead)(unsafe.Pointer(&r.data[0]))
-	phead.Len = r.Len
-	phead.Error = r.Error
-	phead.Cmd = r.Cmd
-	phead.Act = r.Act
-	phead.Index = r.Index
-	phead.Flags = r.Flags
+	r.put(r.data)
 	if wdata != nil {
 		copy(r.data[MsgHeadSize:], wdata)
 	}
@@ -83,7 +88,7 @@ func (r *MessageHead) FromBytes(data []byte) error {
 	if len(data) < MsgHeadSize {
 		return ErrMsgLenTooShort
 	}
-	phead := (*MessageHead)(unsafe.Pointer(&data[0]))
+	phead := (*messageHeadData)(unsafe.Pointer(&data[0]))
 	r.Len = phead.Len
 	r.Error = phead.Error
 	r.Cmd = phead.Cmd
@@ -117,15 +122,7 @@ func NewMessageHead(data []byte) *MessageHead {
 }
 
 func MessageHeadFromByte(data []byte) *MessageHead {
-	if len(data) < MsgHeadSize {
-		return nil
-	}
-	phead := new(*MessageHead)
-	*phead = (*MessageHead)(unsafe.Pointer(&data[0]))
-	if (*phead).Len > MaxMsgDataSize {
-		return nil
-	}
-	return *phead
+	return NewMessageHead(data)
 }
 
 type Message struct {
@@ -0,0 +1,301 @@
+package antnet
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 网关和后端之间的代理消息类型，代理消息带FlagProxy标记，数据为4字节会话id，1字节类型，然后是具体内容
+const (
+	proxyOpen  = 1 //新的客户端，内容为1字节网络类型和客户端真实地址
+	proxyData  = 2 //客户端消息，内容为1字节是否有消息头，然后是消息头和数据
+	proxyClose = 3 //客户端关闭
+)
+
+const proxyHeadSize = 5
+
+func newProxyMsg(session uint32, kind uint8, data []byte) *Message {
+	buf := make([]byte, proxyHeadSize+len(data))
+	binary.LittleEndian.PutUint32(buf, session)
+	buf[4] = kind
+	copy(buf[proxyHeadSize:], data)
+	return &Message{Head: &MessageHead{Len: uint32(len(buf)), Flags: FlagProxy}, Data: buf}
+}
+
+func newProxyDataMsg(session uint32, m *Message) *Message {
+	if m.Head == nil {
+		return newProxyMsg(session, proxyData, append([]byte{0}, m.Data...))
+	}
+	head := *m.Head
+	head.forever = false
+	return newProxyMsg(session, proxyData, append([]byte{1}, head.BytesWithData(m.Data)...))
+}
+
+func parseProxyMsg(m *Message) (session uint32, kind uint8, data []byte, err error) {
+	if len(m.Data) < proxyHeadSize {
+		return 0, 0, nil, ErrMsgLenTooShort
+	}
+	return binary.LittleEndian.Uint32(m.Data), m.Data[4], m.Data[proxyHeadSize:], nil
+}
+
+func parseProxyDataMsg(data []byte) (*Message, error) {
+	if len(data) < 1 {
+		return nil, ErrMsgLenTooShort
+	}
+	if data[0] == 0 {
+		return &Message{Data: data[1:]}, nil
+	}
+	head := NewMessageHead(data[1:])
+	if head == nil {
+		return nil, ErrMsgLenTooShort
+	}
+	if int(head.Len) != len(data)-1-MsgHeadSize {
+		return nil, ErrMsgLenTooShort
+	}
+	m := &Message{Head: head}
+	if head.Len > 0 {
+		m.Data = data[1+MsgHeadSize:]
+	}
+	return m, nil
+}
+
+func (r *msgQue) isProxyMsg(msg *Message) bool {
+	return msg.Head != nil && msg.Head.Flags&FlagProxy > 0 && r.connTyp == ConnTypeAccept
+}
+
+func (r *msgQue) SetProxyTrusted(trusted bool) {
+	if trusted {
+		atomic.StoreInt32(&r.proxyTrusted, 1)
+	} else {
+		atomic.StoreInt32(&r.proxyTrusted, 0)
+	}
+}
+
+type proxyLink struct {
+	sync.Mutex
+	M map[uint32]*proxyMsgQue
+}
+
+// 后端收到网关的代理消息，每个客户端对应一个虚拟的消息队列，使用连接的处理器和解析器
+func (r *msgQue) onProxyMsg(link IMsgQue, msg *Message) bool {
+	if msg.Head.Flags&FlagCompress > 0 && msg.Data != nil {
+		if err := r.uncompressMsg(msg); err != nil {
+			r.app.LogError("msgque uncompress proxy msg failed msgque:%v err:%v", r.id, err)
+			return false
+		}
+	}
+	session, kind, data, err := parseProxyMsg(msg)
+	if err != nil {
+		r.app.LogError("msgque bad proxy msg msgque:%v err:%v", r.id, err)
+		return false
+	}
+
+	r.callbackLock.Lock()
+	if r.proxy == nil {
+		r.proxy = &proxyLink{M: map[uint32]*proxyMsgQue{}}
+	}
+	r.callbackLock.Unlock()
+	r.proxy.Lock()
+	msgque := r.proxy.M[session]
+	r.proxy.Unlock()
+
+	switch kind {
+	case proxyOpen:
+		if msgque != nil || len(data) < 1 {
+			r.app.LogError("msgque bad proxy open msgque:%v session:%v", r.id, session)
+			return true
+		}
+		msgque = newProxyAccept(r.app, link, r.proxy, session, NetType(data[0]), string(data[1:]), r.msgTyp, r.handler, r.parserFactory)
+		r.proxy.Lock()
+		r.proxy.M[session] = msgque
+		r.proxy.Unlock()
+		if r.handler.OnNewMsgQue(msgque) {
+			msgque.init = true
+			msgque.setAvailable(true)
+			r.app.Go(func() {
+				msgque.read()
+			})
+			r.app.Go(func() {
+				msgque.write()
+			})
+		} else {
+			msgque.Stop()
+		}
+	case proxyData:
+		if msgque == nil {
+			r.app.LogDebug("msgque proxy session not found msgque:%v session:%v", r.id, session)
+			link.Send(newProxyMsg(session, proxyClose, nil))
+			return true
+		}
+		m, err := parseProxyDataMsg(data)
+		if err != nil {
+			r.app.LogError("msgque bad proxy data msgque:%v session:%v err:%v", r.id, session, err)
+			msgque.Stop()
+			return true
+		}
+		//每个客户端在自己的协程中处理，处理慢的客户端不阻塞连接上的其他客户端
+		select {
+		case msgque.cread <- m:
+		default:
+			r.app.LogWarn("msgque proxy read queue full msgque:%v session:%v", r.id, session)
+			msgque.Stop()
+		}
+	case proxyClose:
+		if msgque != nil {
+			atomic.StoreInt32(&msgque.closed, 1)
+			msgque.Stop()
+		}
+	}
+	return true
+}
+
+// 连接关闭时关闭所有虚拟消息队列
+func (r *msgQue) stopProxy() {
+	if r.proxy == nil {
+		return
+	}
+	r.proxy.Lock()
+	for _, v := range r.proxy.M {
+		atomic.StoreInt32(&v.closed, 1)
+		v.Stop()
+	}
+	r.proxy.Unlock()
+}
+
+// 网关代理过来的客户端，对后端的处理器来说和普通的消息队列一样
+type proxyMsgQue struct {
+	msgQue
+	link    IMsgQue
+	plink   *proxyLink
+	session uint32
+	closed  int32         //网关已经关闭了客户端，不需要再通知网关
+	cread   chan *Message //读取通道，满了说明处理太慢，关闭客户端
+}
+
+func (r *proxyMsgQue) GetNetType() NetType {
+	return r.netType
+}
+
+func (r *proxyMsgQue) LocalAddr() string {
+	return r.link.LocalAddr()
+}
+
+func (r *proxyMsgQue) RemoteAddr() string {
+	return r.realRemoteAddr
+}
+
+func (r *proxyMsgQue) IsStop() bool {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() || r.link.IsStop() {
+			r.Stop()
+		}
+	}
+	return atomic.LoadInt32(&r.stop) == 1
+}
+
+func (r *proxyMsgQue) Stop() {
+	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
+		r.app.Go(func() {
+			if r.init {
+				r.handler.OnDelMsgQue(r)
+			}
+			r.setAvailable(false)
+			if atomic.LoadInt32(&r.closed) == 0 {
+				r.link.Send(newProxyMsg(r.session, proxyClose, nil))
+			}
+			r.plink.Lock()
+			if r.plink.M[r.session] == r {
+				delete(r.plink.M, r.session)
+			}
+			r.plink.Unlock()
+			r.baseStop()
+		})
+	}
+}
+
+func (r *proxyMsgQue) read() {
+	defer func() {
+		if err := recover(); err != nil {
+			r.app.LogError("msgque read panic id:%v err:%v", r.id, err.(error))
+			LogStack()
+		}
+		r.Stop()
+	}()
+	for !r.IsStop() {
+		select {
+		case <-r.app.stopChanForGo:
+		case <-r.cstop:
+		case m := <-r.cread:
+			if !r.processMsg(r, m) {
+				return
+			}
+		}
+	}
+}
+
+func (r *proxyMsgQue) write() {
+	defer func() {
+		if err := recover(); err != nil {
+			r.app.LogError("msgque write panic id:%v err:%v", r.id, err.(error))
+			LogStack()
+		}
+		r.Stop()
+	}()
+	gm := r.getGMsg(false)
+	tick := time.NewTimer(time.Second)
+	defer tick.Stop()
+	for !r.IsStop() {
+		var m *Message
+		select {
+		case <-r.app.stopChanForGo:
+		case <-r.cstop:
+		case m = <-r.cwrite:
+		case <-gm.c:
+			if gm.fun == nil || gm.fun(r) {
+				m = r.encryptGMsg(gm.msg)
+			}
+			r.addWriting(m)
+			gm = r.getGMsg(true)
+		case <-tick.C: //定时检查连接是否关闭
+			tick.Reset(time.Second)
+		}
+		if m != nil {
+			ok := r.link.Send(newProxyDataMsg(r.session, m))
+			r.doneWriting(m)
+			if !ok {
+				break
+			}
+		}
+	}
+}
+
+func newProxyAccept(app *App, link IMsgQue, plink *proxyLink, session uint32, netType NetType, addr string, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *proxyMsgQue {
+	msgque := proxyMsgQue{
+		msgQue: msgQue{
+			id:             atomic.AddUint32(&msgqueId, 1),
+			app:            app,
+			cwrite:         make(chan *Message, 64),
+			cstop:          make(chan struct{}),
+			msgTyp:         msgtyp,
+			handler:        handler,
+			connTyp:        ConnTypeAccept,
+			gmsgId:         app.getGMsgId(),
+			lastTick:       timestamp(),
+			parserFactory:  parser,
+			realRemoteAddr: addr,
+			netType:        netType,
+		},
+		link:    link,
+		plink:   plink,
+		session: session,
+		cread:   make(chan *Message, 64),
+	}
+	if parser != nil {
+		msgque.parser = parser.Get()
+	}
+	app.addMsgQue(&msgque)
+	app.LogInfo("new proxy msgque id:%d session:%d from addr:%s link:%d", msgque.id, session, addr, link.Id())
+	return &msgque
+}
@@ -0,0 +1,193 @@
+package antnet
+
+import (
+	"testing"
+	"time"
+)
+
+type proxyTestBackend struct {
+	DefMsgHandler
+	trusted bool
+	cnew    chan IMsgQue
+}
+
+func (r *proxyTestBackend) OnNewMsgQue(msgque IMsgQue) bool {
+	if msgque.IsProxy() {
+		r.cnew <- msgque
+	} else if r.trusted {
+		msgque.SetProxyTrusted(true)
+	}
+	return true
+}
+
+type proxyTestClient struct {
+	DefMsgHandler
+	cconn chan bool
+	cdel  chan struct{}
+}
+
+func (r *proxyTestClient) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	r.cconn <- ok
+	return ok
+}
+
+func (r *proxyTestClient) OnDelMsgQue(msgque IMsgQue) {
+	close(r.cdel)
+}
+
+func proxyTestConnect(t *testing.T, app *App, addr string) (IMsgQue, *proxyTestClient) {
+	handler := &proxyTestClient{cconn: make(chan bool, 1), cdel: make(chan struct{})}
+	msgque := app.StartConnect("tcp", addr, MsgTypeMsg, handler, nil, nil)
+	if !<-handler.cconn {
+		t.Fatalf("connect to %v failed", addr)
+	}
+	return msgque, handler
+}
+
+func Test_GatewayProxy(t *testing.T) {
+	backApp, gwApp, cliApp := NewApp(nil), NewApp(nil), NewApp(nil)
+	defer cliApp.Stop()
+	defer gwApp.Stop()
+	defer backApp.Stop()
+
+	cnew := make(chan IMsgQue, 4)
+	echo := func(msgque IMsgQue, msg *Message) bool {
+		msgque.Send(NewMsg(1, 1, msg.Index(), 0, append([]byte(msgque.RemoteAddr()+"|"), msg.Data...)))
+		return true
+	}
+	back := &proxyTestBackend{trusted: true, cnew: cnew}
+	back.Register(1, 1, echo)
+	backAddr := testStartServer(t, backApp, "tcp", MsgTypeMsg, back, nil)
+	untrusted := &proxyTestBackend{cnew: cnew}
+	untrusted.Register(1, 1, echo)
+	untrustedAddr := testStartServer(t, backApp, "tcp", MsgTypeMsg, untrusted, nil)
+
+	gw := NewGateway(gwApp)
+	link := gw.AddBackend("tcp", backAddr)
+	for i := 0; i < 100 && !link.Available(); i++ {
+		Sleep(10)
+	}
+	gwAddr := testStartServer(t, gwApp, "tcp", MsgTypeMsg, gw, nil)
+
+	//网关转发，后端看到客户端的真实地址
+	client, handler := proxyTestConnect(t, cliApp, gwAddr)
+	select {
+	case msgque := <-cnew:
+		if msgque.RemoteAddr() != client.LocalAddr() {
+			t.Fatalf("proxy remote addr %v want %v", msgque.RemoteAddr(), client.LocalAddr())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait proxy msgque timeout")
+	}
+	c := make(chan *Message, 1)
+	client.SendCallback(NewMsg(1, 1, 7, 0, []byte("hello")), c)
+	select {
+	case m := <-c:
+		if m == nil || string(m.Data) != client.LocalAddr()+"|hello" {
+			t.Fatalf("bad reply %v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait reply timeout")
+	}
+
+	//客户端通过网关发送代理消息，后端关闭这个客户端
+	m := NewMsg(1, 1, 8, 0, newProxyMsg(1, proxyOpen, []byte{byte(NetTypeTcp)}).Data)
+	m.Head.Flags |= FlagProxy
+	client.Send(m)
+	select {
+	case <-handler.cdel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy msg through gateway should close client")
+	}
+
+	//直接连接后端伪造代理消息，连接被关闭，也不会产生虚拟消息队列
+	spoof, handler := proxyTestConnect(t, cliApp, untrustedAddr)
+	spoof.Send(newProxyMsg(1, proxyOpen, append([]byte{byte(NetTypeTcp)}, "1.2.3.4:5"...)))
+	select {
+	case <-handler.cdel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("spoofed proxy msg should close msgque")
+	}
+	select {
+	case msgque := <-cnew:
+		t.Fatalf("spoofed proxy msgque created addr:%v", msgque.RemoteAddr())
+	default:
+	}
+}
+
+type proxyTestSlow struct {
+	DefMsgHandler
+	cblock chan struct{}
+	cdone  chan uint32
+}
+
+func (r *proxyTestSlow) OnProcessMsg(msgque IMsgQue, msg *Message) bool {
+	session := msgque.(*proxyMsgQue).session
+	if session == 1 {
+		<-r.cblock
+	}
+	r.cdone <- session
+	return true
+}
+
+func proxyTestOpen(link *tcpMsgQue, session uint32) *proxyMsgQue {
+	link.onProxyMsg(link, newProxyMsg(session, proxyOpen, append([]byte{byte(NetTypeTcp)}, "1.2.3.4:5"...)))
+	link.proxy.Lock()
+	defer link.proxy.Unlock()
+	return link.proxy.M[session]
+}
+
+func Test_ProxySessionDispatch(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	handler := &proxyTestSlow{cblock: make(chan struct{}), cdone: make(chan uint32, 4)}
+	link := newTcpConn(app, "tcp", "127.0.0.1:1", nil, MsgTypeMsg, handler, nil, nil)
+	link.SetSendHighWater(-1)
+	defer link.Stop()
+	slow, fast := proxyTestOpen(link, 1), proxyTestOpen(link, 2)
+	if slow == nil || fast == nil {
+		t.Fatal("proxy msgque not created")
+	}
+
+	//会话1的处理阻塞，不影响连接上的会话2
+	link.onProxyMsg(link, newProxyDataMsg(1, NewMsg(1, 1, 0, 0, []byte("slow"))))
+	link.onProxyMsg(link, newProxyDataMsg(2, NewMsg(1, 1, 0, 0, []byte("fast"))))
+	select {
+	case session := <-handler.cdone:
+		if session != 2 {
+			t.Fatalf("processed session %v first", session)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast session blocked by slow session")
+	}
+	close(handler.cblock)
+	if session := <-handler.cdone; session != 1 {
+		t.Fatalf("processed session %v", session)
+	}
+
+	//全局消息在会话的写协程中加密
+	key := []byte("0123456789abcdef")
+	c, _ := NewAesGcmCipher(key)
+	fast.SetCipher(c)
+	m := NewMsg(1, 2, 0, 0, []byte("global"))
+	m.Head.Flags |= FlagEncrypt
+	app.Send(m, func(msgque IMsgQue) bool {
+		return msgque == fast
+	})
+	select {
+	case pm := <-link.cwrite:
+		session, kind, data, err := parseProxyMsg(pm)
+		if err != nil || session != 2 || kind != proxyData {
+			t.Fatalf("bad proxy msg session:%v kind:%v err:%v", session, kind, err)
+		}
+		em, err := parseProxyDataMsg(data)
+		if err != nil || em.Head.Flags&FlagEncrypt == 0 {
+			t.Fatalf("bad proxy data %v err:%v", em, err)
+		}
+		if plain, err := c.Decrypt(em.Data); err != nil || string(plain) != "global" {
+			t.Fatalf("global msg not encrypted data:%q err:%v", em.Data, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait global msg timeout")
+	}
+}
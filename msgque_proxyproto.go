@@ -0,0 +1,182 @@
+package antnet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAProxy PROXY protocol，四层负载均衡在连接开始时发送客户端的真实地址
+var proxyProtoV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyProtoV1MaxLen = 107
+
+// 读取PROXY protocol v1或v2头，只读取头部，不会多读数据，返回nil表示LOCAL或UNKNOWN，此时使用连接本身的地址
+func readProxyProtocol(reader io.Reader) (net.Addr, error) {
+	head := make([]byte, len(proxyProtoV2Sig))
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(head, proxyProtoV2Sig) {
+		return readProxyProtocolV2(reader)
+	}
+	if bytes.HasPrefix(head, []byte("PROXY ")) {
+		return readProxyProtocolV1(reader, head)
+	}
+	return nil, ErrProxyProtocol
+}
+
+func readProxyProtocolV1(reader io.Reader, head []byte) (net.Addr, error) {
+	line := head
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtoV1MaxLen {
+			return nil, ErrProxyProtocol
+		}
+		if _, err := io.ReadFull(reader, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyProtocol
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrProxyProtocol
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyProtocolV2(reader io.Reader) (net.Addr, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 2 {
+		return nil, ErrProxyProtocol
+	}
+	data := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	cmd := head[0] & 0xF
+	if cmd == 0 {
+		return nil, nil
+	}
+	if cmd != 1 {
+		return nil, ErrProxyProtocol
+	}
+	switch head[1] >> 4 {
+	case 1:
+		if len(data) < 12 {
+			return nil, ErrProxyProtocol
+		}
+		return &net.TCPAddr{IP: net.IP(data[:4]), Port: int(binary.BigEndian.Uint16(data[8:]))}, nil
+	case 2:
+		if len(data) < 36 {
+			return nil, ErrProxyProtocol
+		}
+		return &net.TCPAddr{IP: net.IP(data[:16]), Port: int(binary.BigEndian.Uint16(data[32:]))}, nil
+	}
+	return nil, nil
+}
+
+// 接受的连接在第一次读取或者获取地址时解析PROXY protocol头，不会阻塞Accept
+type proxyProtoListener struct {
+	net.Listener
+}
+
+func (r *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := r.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: c}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	once sync.Once
+	addr net.Addr
+	err  error
+}
+
+func (r *proxyProtoConn) init() {
+	r.once.Do(func() {
+		r.Conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		r.addr, r.err = readProxyProtocol(r.Conn)
+		r.Conn.SetReadDeadline(time.Time{})
+		if r.err != nil {
+			LogError("read proxy protocol failed addr:%v err:%v", r.Conn.RemoteAddr(), r.err)
+		}
+	})
+}
+
+func (r *proxyProtoConn) Read(b []byte) (int, error) {
+	r.init()
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.Conn.Read(b)
+}
+
+func (r *proxyProtoConn) RemoteAddr() net.Addr {
+	r.init()
+	if r.addr != nil {
+		return r.addr
+	}
+	return r.Conn.RemoteAddr()
+}
+
+// 返回PROXY protocol中的客户端地址，没有使用PROXY protocol时返回空字符串
+func proxyProtoAddr(c net.Conn) (string, error) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	pc, ok := c.(*proxyProtoConn)
+	if !ok {
+		return "", nil
+	}
+	pc.init()
+	if pc.err != nil {
+		return "", pc.err
+	}
+	if pc.addr == nil {
+		return "", nil
+	}
+	return pc.addr.String(), nil
+}
+
+// 从X-Forwarded-For或X-Real-IP获取客户端地址，X-Forwarded-For只有ip，端口为0
+// X-Forwarded-For左边的地址可以被客户端伪造，从右边跳过hops-1个可信代理添加的地址，hops小于1按1处理
+func forwardedAddr(header func(key string) string, hops int) string {
+	ip := ""
+	if xff := header("X-Forwarded-For"); xff != "" {
+		if hops < 1 {
+			hops = 1
+		}
+		ips := strings.Split(xff, ",")
+		if len(ips) < hops {
+			return ""
+		}
+		ip = strings.TrimSpace(ips[len(ips)-hops])
+	} else {
+		ip = strings.TrimSpace(header("X-Real-IP"))
+	}
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return net.JoinHostPort(ip, "0")
+}
@@ -0,0 +1,98 @@
+package antnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func Test_ProxyProtocolV1(t *testing.T) {
+	cases := []struct {
+		head string
+		addr string
+		err  bool
+	}{
+		{"PROXY TCP4 192.168.1.2 10.0.0.1 56324 443\r\n", "192.168.1.2:56324", false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\n", "[2001:db8::1]:4000", false},
+		{"PROXY UNKNOWN\r\n", "", false},
+		{"PROXY TCP4 bad 10.0.0.1 1 2\r\n", "", true},
+		{"GET / HTTP/1.1\r\n", "", true},
+	}
+	for _, c := range cases {
+		reader := bytes.NewReader([]byte(c.head + "data"))
+		addr, err := readProxyProtocol(reader)
+		if c.err {
+			if err == nil {
+				t.Fatalf("head:%q want err", c.head)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("head:%q err:%v", c.head, err)
+		}
+		if (addr == nil && c.addr != "") || (addr != nil && addr.String() != c.addr) {
+			t.Fatalf("head:%q addr:%v want:%v", c.head, addr, c.addr)
+		}
+		if reader.Len() != 4 {
+			t.Fatalf("head:%q read too much left:%v", c.head, reader.Len())
+		}
+	}
+}
+
+func Test_ProxyProtocolV2(t *testing.T) {
+	v2 := func(cmd, fam byte, data []byte) []byte {
+		buf := append([]byte{}, proxyProtoV2Sig...)
+		buf = append(buf, 0x20|cmd, fam, 0, 0)
+		binary.BigEndian.PutUint16(buf[14:], uint16(len(data)))
+		return append(buf, data...)
+	}
+	ipv4 := append(append(net.IPv4(10, 1, 2, 3).To4(), 10, 0, 0, 1), 0x1F, 0x90, 0, 80)
+	ipv6 := append(append(net.ParseIP("2001:db8::9"), net.ParseIP("2001:db8::1")...), 0, 22, 0, 80)
+	cases := []struct {
+		head []byte
+		addr string
+	}{
+		{v2(1, 0x11, ipv4), "10.1.2.3:8080"},
+		{v2(1, 0x21, ipv6), "[2001:db8::9]:22"},
+		{v2(0, 0x00, nil), ""},
+		{v2(1, 0x11, append(ipv4, 1, 0, 1, 'x')), "10.1.2.3:8080"},
+	}
+	for i, c := range cases {
+		reader := bytes.NewReader(append(c.head, "data"...))
+		addr, err := readProxyProtocol(reader)
+		if err != nil {
+			t.Fatalf("case:%v err:%v", i, err)
+		}
+		if (addr == nil && c.addr != "") || (addr != nil && addr.String() != c.addr) {
+			t.Fatalf("case:%v addr:%v want:%v", i, addr, c.addr)
+		}
+		if reader.Len() != 4 {
+			t.Fatalf("case:%v read too much left:%v", i, reader.Len())
+		}
+	}
+	if _, err := readProxyProtocol(bytes.NewReader(v2(1, 0x11, ipv4[:6]))); err == nil {
+		t.Fatal("short address want err")
+	}
+}
+
+func Test_ForwardedAddr(t *testing.T) {
+	cases := []struct {
+		header map[string]string
+		hops   int
+		want   string
+	}{
+		{map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.1"}, 0, "10.0.0.1:0"},
+		{map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.1"}, 1, "10.0.0.1:0"},
+		{map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.1"}, 2, "1.2.3.4:0"},
+		{map[string]string{"X-Forwarded-For": "10.0.0.1"}, 2, ""},
+		{map[string]string{"X-Real-IP": "5.6.7.8"}, 1, "5.6.7.8:0"},
+		{map[string]string{"X-Forwarded-For": "unknown"}, 1, ""},
+		{map[string]string{"X-Forwarded-For": "1.2.3.4, unknown"}, 1, ""},
+	}
+	for _, c := range cases {
+		if addr := forwardedAddr(func(k string) string { return c.header[k] }, c.hops); addr != c.want {
+			t.Fatalf("header:%v hops:%v got %q want %q", c.header, c.hops, addr, c.want)
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package antnet
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rudpCmdPush uint8 = 1 //数据
+	rudpCmdAck  uint8 = 2 //确认
+	rudpCmdWask uint8 = 3 //询问对端窗口
+	rudpCmdWins uint8 = 4 //告知本端窗口
+
+	rudpAskSend = 1 << 0 //需要发送窗口询问
+	rudpAskTell = 1 << 1 //需要告知窗口大小
+
+	rudpHeadSize = 18
+)
+
+var RudpMtu uint32 = 1400       //单个udp包的最大长度
+var RudpWnd uint32 = 256        //收发窗口大小，单个消息最多分为RudpWnd-1个分片
+var RudpInterval = 10           //刷新间隔，单位ms
+var RudpMinRto uint32 = 30      //最小重传超时，单位ms
+var RudpMaxRto uint32 = 60000   //最大重传超时，单位ms
+var RudpFastResend uint32 = 2   //被跳过多少次确认后快速重传
+var RudpDeadLink uint32 = 20    //同一分片重传多少次后认为连接断开
+var RudpProbeWait uint32 = 1000 //对端窗口为0时的初始探测间隔，单位ms
+var RudpMaxProbeWait uint32 = 10000
+
+type rudpSegment struct {
+	cmd  uint8
+	frg  uint8
+	wnd  uint16
+	ts   uint32
+	sn   uint32
+	una  uint32
+	data []byte
+
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+}
+
+func (r *rudpSegment) encode(buf []byte) []byte {
+	var head [rudpHeadSize]byte
+	head[0] = r.cmd
+	head[1] = r.frg
+	binary.LittleEndian.PutUint16(head[2:], r.wnd)
+	binary.LittleEndian.PutUint32(head[4:], r.ts)
+	binary.LittleEndian.PutUint32(head[8:], r.sn)
+	binary.LittleEndian.PutUint32(head[12:], r.una)
+	binary.LittleEndian.PutUint16(head[16:], uint16(len(r.data)))
+	buf = append(buf, head[:]...)
+	return append(buf, r.data...)
+}
+
+func rudpDiff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// 可靠udp控制块，实现确认，重传，排序，滑动窗口和拥塞控制，所有方法需要在加锁后调用
+type rudpCB struct {
+	sync.Mutex
+	mss       uint32
+	sndUna    uint32
+	sndNxt    uint32
+	rcvNxt    uint32
+	ssthresh  uint32
+	cwnd      uint32
+	incr      uint32
+	rmtWnd    uint32
+	srtt      int32
+	rttvar    int32
+	rto       uint32
+	probe     uint32
+	probeTs   uint32
+	probeWait uint32
+	dead      bool
+
+	sndQueue []*rudpSegment
+	sndBuf   []*rudpSegment
+	rcvQueue []*rudpSegment
+	rcvBuf   []*rudpSegment
+	ackList  []uint32
+
+	buffer []byte
+	output func(data []byte)
+}
+
+func newRudpCB(output func(data []byte)) *rudpCB {
+	mss := RudpMtu - rudpHeadSize
+	return &rudpCB{
+		mss:      mss,
+		ssthresh: 2,
+		cwnd:     1,
+		incr:     mss,
+		rmtWnd:   RudpWnd,
+		rto:      200,
+		buffer:   make([]byte, 0, RudpMtu),
+		output:   output,
+	}
+}
+
+func (r *rudpCB) send(data []byte) error {
+	count := (len(data) + int(r.mss) - 1) / int(r.mss)
+	if count == 0 {
+		count = 1
+	}
+	if count > 255 || count >= int(RudpWnd) {
+		return ErrMsgLenTooLong
+	}
+	//写协程在等待的分片达到发送窗口时暂停取消息，这里是最后的保护
+	if uint32(r.waitSnd()+count) > 2*RudpWnd {
+		return ErrSendQueueFull
+	}
+	for i := 0; i < count; i++ {
+		size := len(data)
+		if size > int(r.mss) {
+			size = int(r.mss)
+		}
+		seg := &rudpSegment{frg: uint8(count - i - 1), data: make([]byte, size)}
+		copy(seg.data, data[:size])
+		r.sndQueue = append(r.sndQueue, seg)
+		data = data[size:]
+	}
+	return nil
+}
+
+// 停止时不再等待窗口，把队列中的分片都发出去，不等待确认
+func (r *rudpCB) flushAll(now uint32) {
+	for _, s := range r.sndQueue {
+		s.cmd = rudpCmdPush
+		s.sn = r.sndNxt
+		r.sndNxt++
+		r.sndBuf = append(r.sndBuf, s)
+	}
+	r.sndQueue = nil
+	r.flush(now)
+}
+
+func (r *rudpCB) waitSnd() int {
+	return len(r.sndQueue) + len(r.sndBuf)
+}
+
+func (r *rudpCB) recv() []byte {
+	n := 0
+	size := 0
+	for _, seg := range r.rcvQueue {
+		n++
+		size += len(seg.data)
+		if seg.frg == 0 {
+			break
+		}
+	}
+	if n == 0 || r.rcvQueue[n-1].frg != 0 {
+		return nil
+	}
+
+	full := uint32(len(r.rcvQueue)) >= RudpWnd
+	data := make([]byte, 0, size)
+	for _, seg := range r.rcvQueue[:n] {
+		data = append(data, seg.data...)
+	}
+	r.rcvQueue = r.rcvQueue[n:]
+	r.moveRcvBuf()
+	if full && uint32(len(r.rcvQueue)) < RudpWnd {
+		r.probe |= rudpAskTell
+	}
+	return data
+}
+
+func (r *rudpCB) moveRcvBuf() {
+	for len(r.rcvBuf) > 0 {
+		seg := r.rcvBuf[0]
+		if seg.sn != r.rcvNxt || uint32(len(r.rcvQueue)) >= RudpWnd {
+			break
+		}
+		r.rcvBuf = r.rcvBuf[1:]
+		r.rcvQueue = append(r.rcvQueue, seg)
+		r.rcvNxt++
+	}
+}
+
+func (r *rudpCB) wndUnused() uint16 {
+	if n := uint32(len(r.rcvQueue)); n < RudpWnd {
+		return uint16(RudpWnd - n)
+	}
+	return 0
+}
+
+func (r *rudpCB) updateAck(rtt int32) {
+	if r.srtt == 0 {
+		r.srtt = rtt
+		r.rttvar = rtt / 2
+	} else {
+		delta := rtt - r.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		r.rttvar = (3*r.rttvar + delta) / 4
+		r.srtt = (7*r.srtt + rtt) / 8
+		if r.srtt < 1 {
+			r.srtt = 1
+		}
+	}
+	vr := 4 * r.rttvar
+	if vr < int32(RudpInterval) {
+		vr = int32(RudpInterval)
+	}
+	rto := uint32(r.srtt + vr)
+	if rto < RudpMinRto {
+		rto = RudpMinRto
+	} else if rto > RudpMaxRto {
+		rto = RudpMaxRto
+	}
+	r.rto = rto
+}
+
+func (r *rudpCB) shrinkBuf() {
+	if len(r.sndBuf) > 0 {
+		r.sndUna = r.sndBuf[0].sn
+	} else {
+		r.sndUna = r.sndNxt
+	}
+}
+
+func (r *rudpCB) parseUna(una uint32) {
+	n := 0
+	for _, seg := range r.sndBuf {
+		if rudpDiff(una, seg.sn) <= 0 {
+			break
+		}
+		n++
+	}
+	r.sndBuf = r.sndBuf[n:]
+}
+
+func (r *rudpCB) parseAck(sn uint32) {
+	if rudpDiff(sn, r.sndUna) < 0 || rudpDiff(sn, r.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range r.sndBuf {
+		if seg.sn == sn {
+			r.sndBuf = append(r.sndBuf[:i], r.sndBuf[i+1:]...)
+			break
+		}
+		if rudpDiff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+func (r *rudpCB) parseFastack(sn uint32) {
+	if rudpDiff(sn, r.sndUna) < 0 || rudpDiff(sn, r.sndNxt) >= 0 {
+		return
+	}
+	for _, seg := range r.sndBuf {
+		if rudpDiff(sn, seg.sn) < 0 {
+			break
+		} else if sn != seg.sn {
+			seg.fastack++
+		}
+	}
+}
+
+func (r *rudpCB) parseData(seg *rudpSegment) {
+	if rudpDiff(seg.sn, r.rcvNxt+RudpWnd) >= 0 || rudpDiff(seg.sn, r.rcvNxt) < 0 {
+		return
+	}
+	i := len(r.rcvBuf) - 1
+	for ; i >= 0; i-- {
+		if r.rcvBuf[i].sn == seg.sn {
+			return
+		}
+		if rudpDiff(seg.sn, r.rcvBuf[i].sn) > 0 {
+			break
+		}
+	}
+	r.rcvBuf = append(r.rcvBuf, nil)
+	copy(r.rcvBuf[i+2:], r.rcvBuf[i+1:])
+	r.rcvBuf[i+1] = seg
+	r.moveRcvBuf()
+}
+
+func (r *rudpCB) input(data []byte, now uint32) error {
+	prevUna := r.sndUna
+	var maxack uint32
+	ackFlag := false
+	for len(data) > 0 {
+		if len(data) < rudpHeadSize {
+			return ErrMsgLenTooShort
+		}
+		cmd := data[0]
+		frg := data[1]
+		wnd := binary.LittleEndian.Uint16(data[2:])
+		ts := binary.LittleEndian.Uint32(data[4:])
+		sn := binary.LittleEndian.Uint32(data[8:])
+		una := binary.LittleEndian.Uint32(data[12:])
+		length := int(binary.LittleEndian.Uint16(data[16:]))
+		data = data[rudpHeadSize:]
+		if len(data) < length {
+			return ErrMsgLenTooShort
+		}
+		if cmd < rudpCmdPush || cmd > rudpCmdWins {
+			return ErrRudpData
+		}
+
+		r.rmtWnd = uint32(wnd)
+		r.parseUna(una)
+		r.shrinkBuf()
+		switch cmd {
+		case rudpCmdAck:
+			if rudpDiff(now, ts) >= 0 {
+				r.updateAck(rudpDiff(now, ts))
+			}
+			r.parseAck(sn)
+			r.shrinkBuf()
+			if !ackFlag || rudpDiff(sn, maxack) > 0 {
+				ackFlag = true
+				maxack = sn
+			}
+		case rudpCmdPush:
+			if rudpDiff(sn, r.rcvNxt+RudpWnd) < 0 {
+				r.ackList = append(r.ackList, sn, ts)
+				if rudpDiff(sn, r.rcvNxt) >= 0 {
+					seg := &rudpSegment{cmd: cmd, frg: frg, wnd: wnd, ts: ts, sn: sn, una: una, data: make([]byte, length)}
+					copy(seg.data, data[:length])
+					r.parseData(seg)
+				}
+			}
+		case rudpCmdWask:
+			r.probe |= rudpAskTell
+		}
+		data = data[length:]
+	}
+
+	if ackFlag {
+		r.parseFastack(maxack)
+	}
+
+	if rudpDiff(r.sndUna, prevUna) > 0 && r.cwnd < r.rmtWnd {
+		mss := r.mss
+		if r.cwnd < r.ssthresh {
+			r.cwnd++
+			r.incr += mss
+		} else {
+			if r.incr < mss {
+				r.incr = mss
+			}
+			r.incr += (mss*mss)/r.incr + mss/16
+			if (r.cwnd+1)*mss <= r.incr {
+				r.cwnd = (r.incr + mss - 1) / mss
+			}
+		}
+		if r.cwnd > r.rmtWnd {
+			r.cwnd = r.rmtWnd
+			r.incr = r.rmtWnd * mss
+		}
+	}
+	return nil
+}
+
+func (r *rudpCB) flush(now uint32) {
+	buf := r.buffer[:0]
+	write := func(seg *rudpSegment) {
+		if len(buf)+rudpHeadSize+len(seg.data) > int(RudpMtu) {
+			r.output(buf)
+			buf = r.buffer[:0]
+		}
+		buf = seg.encode(buf)
+	}
+
+	seg := rudpSegment{cmd: rudpCmdAck, wnd: r.wndUnused(), una: r.rcvNxt}
+	for i := 0; i+1 < len(r.ackList); i += 2 {
+		seg.sn = r.ackList[i]
+		seg.ts = r.ackList[i+1]
+		write(&seg)
+	}
+	r.ackList = r.ackList[:0]
+
+	if r.rmtWnd == 0 {
+		if r.probeWait == 0 {
+			r.probeWait = RudpProbeWait
+			r.probeTs = now + r.probeWait
+		} else if rudpDiff(now, r.probeTs) >= 0 {
+			r.probeWait += r.probeWait / 2
+			if r.probeWait > RudpMaxProbeWait {
+				r.probeWait = RudpMaxProbeWait
+			}
+			r.probeTs = now + r.probeWait
+			r.probe |= rudpAskSend
+		}
+	} else {
+		r.probeTs = 0
+		r.probeWait = 0
+	}
+	seg.sn = 0
+	seg.ts = 0
+	if r.probe&rudpAskSend > 0 {
+		seg.cmd = rudpCmdWask
+		write(&seg)
+	}
+	if r.probe&rudpAskTell > 0 {
+		seg.cmd = rudpCmdWins
+		write(&seg)
+	}
+	r.probe = 0
+
+	cwnd := RudpWnd
+	if r.rmtWnd < cwnd {
+		cwnd = r.rmtWnd
+	}
+	if r.cwnd < cwnd {
+		cwnd = r.cwnd
+	}
+	for len(r.sndQueue) > 0 && rudpDiff(r.sndNxt, r.sndUna+cwnd) < 0 {
+		s := r.sndQueue[0]
+		r.sndQueue = r.sndQueue[1:]
+		s.cmd = rudpCmdPush
+		s.sn = r.sndNxt
+		r.sndNxt++
+		r.sndBuf = append(r.sndBuf, s)
+	}
+
+	change := false
+	lost := false
+	for _, s := range r.sndBuf {
+		needSend := false
+		if s.xmit == 0 {
+			needSend = true
+			s.rto = r.rto
+			s.resendts = now + s.rto
+		} else if rudpDiff(now, s.resendts) >= 0 {
+			needSend = true
+			s.rto += s.rto / 2
+			if s.rto > RudpMaxRto {
+				s.rto = RudpMaxRto
+			}
+			s.resendts = now + s.rto
+			lost = true
+		} else if s.fastack >= RudpFastResend {
+			needSend = true
+			s.fastack = 0
+			s.resendts = now + s.rto
+			change = true
+		}
+		if needSend {
+			s.xmit++
+			s.ts = now
+			s.wnd = seg.wnd
+			s.una = r.rcvNxt
+			write(s)
+			if s.xmit >= RudpDeadLink {
+				r.dead = true
+			}
+		}
+	}
+	if len(buf) > 0 {
+		r.output(buf)
+	}
+
+	if change {
+		inflight := r.sndNxt - r.sndUna
+		r.ssthresh = inflight / 2
+		if r.ssthresh < 2 {
+			r.ssthresh = 2
+		}
+		r.cwnd = r.ssthresh + RudpFastResend
+		r.incr = r.cwnd * r.mss
+	}
+	if lost {
+		r.ssthresh = cwnd / 2
+		if r.ssthresh < 2 {
+			r.ssthresh = 2
+		}
+		r.cwnd = 1
+		r.incr = r.mss
+	}
+}
+
+func (r *udpMsgQue) readRudp(data []byte) bool {
+	var datas [][]byte
+	r.rudp.Lock()
+	err := r.rudp.input(data, uint32(nowTick()))
+	for d := r.rudp.recv(); d != nil; d = r.rudp.recv() {
+		datas = append(datas, d)
+	}
+	r.rudp.Unlock()
+	if err != nil {
+		r.app.LogError("msgque:%v rudp input err:%v", r.id, err)
+		return false
+	}
+	for _, d := range datas {
+		if !r.processData(d) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *udpMsgQue) writeRudp() {
+	gm := r.getGMsg(false)
+	tick := time.NewTimer(time.Second * time.Duration(r.timeout))
+	flush := time.NewTicker(time.Millisecond * time.Duration(RudpInterval))
+	dead := false
+	for !r.IsStop() {
+		//等待发送的分片达到发送窗口时不再取消息，写入通道满后由发送策略处理
+		r.rudp.Lock()
+		full := uint32(r.rudp.waitSnd()) >= RudpWnd
+		r.rudp.Unlock()
+		cwrite, cgmsg := r.cwrite, gm.c
+		if full {
+			cwrite, cgmsg = nil, nil
+		}
+		var m *Message = nil
+		select {
+		case <-r.app.stopChanForGo:
+		case <-r.cstop:
+		case m = <-cwrite:
+		case <-cgmsg:
+			if gm.fun == nil || gm.fun(r) {
+				m = r.encryptGMsg(gm.msg)
+			}
+			r.addWriting(m)
+			gm = r.getGMsg(true)
+		case <-tick.C:
+			if r.isTimeout(tick) {
+				r.Stop()
+			}
+		case <-flush.C:
+		}
+
+		var data []byte
+		if m != nil {
+			if r.msgTyp == MsgTypeCmd {
+				data = m.Data
+			} else if m.Head != nil || m.Data != nil {
+				data = m.Bytes()
+			}
+		}
+
+		r.rudp.Lock()
+		if data != nil {
+			if err := r.rudp.send(data); err != nil {
+				r.app.LogError("msgque:%v rudp send len:%v err:%v", r.id, len(data), err)
+			}
+		}
+		r.rudp.flush(uint32(nowTick()))
+		dead = r.rudp.dead
+		r.rudp.Unlock()
+		r.doneWriting(m)
+
+		if dead {
+			r.app.LogInfo("msgque close because rudp dead link id:%v", r.id)
+			break
+		}
+		if m != nil {
+			atomic.StoreInt64(&r.lastTick, timestamp())
+		}
+	}
+
+	if !dead {
+		r.rudp.Lock()
+		r.rudp.flushAll(uint32(nowTick()))
+		r.rudp.Unlock()
+	}
+	flush.Stop()
+	tick.Stop()
+}
@@ -0,0 +1,124 @@
+package antnet
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_RudpLossyLink(t *testing.T) {
+	var toB, toA [][]byte
+	rnd := rand.New(rand.NewSource(1))
+	lossy := func(queue *[][]byte) func(data []byte) {
+		return func(data []byte) {
+			if rnd.Intn(100) < 20 {
+				return
+			}
+			pdata := make([]byte, len(data))
+			copy(pdata, data)
+			*queue = append(*queue, pdata)
+		}
+	}
+	a := newRudpCB(lossy(&toB))
+	b := newRudpCB(lossy(&toA))
+
+	count := 200
+	for i := 0; i < count; i++ {
+		size := 1 + i*37%4000
+		a.send(bytes.Repeat([]byte{byte(i)}, size))
+	}
+
+	got := 0
+	for now := uint32(0); now < 60000 && got < count; now += 10 {
+		a.flush(now)
+		for _, d := range toB {
+			if err := b.input(d, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		toB = toB[:0]
+		for data := b.recv(); data != nil; data = b.recv() {
+			size := 1 + got*37%4000
+			if len(data) != size || data[0] != byte(got) || data[size-1] != byte(got) {
+				t.Fatalf("msg %v out of order or broken len:%v", got, len(data))
+			}
+			got++
+		}
+		b.flush(now)
+		for _, d := range toA {
+			if err := a.input(d, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		toA = toA[:0]
+	}
+	if got != count {
+		t.Fatalf("recv %v msgs, want %v", got, count)
+	}
+	if a.dead || b.dead {
+		t.Fatal("link should not be dead")
+	}
+}
+
+func Test_RudpSendQueueLimit(t *testing.T) {
+	cb := newRudpCB(func(data []byte) {})
+	data := make([]byte, cb.mss)
+	for i := 0; i < int(2*RudpWnd); i++ {
+		if err := cb.send(data); err != nil {
+			t.Fatalf("send %v err:%v", i, err)
+		}
+	}
+	if err := cb.send(data); err != ErrSendQueueFull {
+		t.Fatalf("send over limit err:%v", err)
+	}
+
+	//停止时不等待窗口，队列中的分片都发出去
+	cb.flushAll(0)
+	if len(cb.sndQueue) != 0 || len(cb.sndBuf) != int(2*RudpWnd) || cb.sndBuf[len(cb.sndBuf)-1].xmit != 1 {
+		t.Fatalf("flush all queue:%v buf:%v", len(cb.sndQueue), len(cb.sndBuf))
+	}
+}
+
+func Test_RudpLoopback(t *testing.T) {
+	server := NewApp(nil)
+	defer server.Stop()
+	var count int32
+	handler := &DefMsgHandler{}
+	handler.Register(1, 1, func(msgque IMsgQue, msg *Message) bool {
+		msgque.Send(NewMsg(1, 1, msg.Index(), 0, msg.Data))
+		return true
+	})
+	handler.Register(1, 5, func(msgque IMsgQue, msg *Message) bool {
+		atomic.AddInt32(&count, 1)
+		return true
+	})
+	addr := testStartServer(t, server, "rudp", MsgTypeMsg, handler, nil)
+
+	client := NewApp(nil)
+	msgque := callTestConnect(t, client, "rudp", addr)
+	//多个分片的消息
+	data := bytes.Repeat([]byte("rudp"), 4000)
+	for i := 0; i < 20; i++ {
+		var resp []byte
+		if err := msgque.Call(context.Background(), 1, 1, data, &resp); err != nil || !bytes.Equal(resp, data) {
+			t.Fatalf("call %v len:%v err:%v", i, len(resp), err)
+		}
+	}
+
+	//超过发送窗口的消息在写入通道排队，停止时等待全部确认
+	n := 500
+	for i := 0; i < n; i++ {
+		if err := msgque.SendWithPolicy(NewMsg(1, 5, 0, 0, data[:2000]), SendPolicyBlock, 0); err != nil {
+			t.Fatalf("send %v err:%v", i, err)
+		}
+	}
+	client.Stop()
+	for i := 0; i < 100 && atomic.LoadInt32(&count) < int32(n); i++ {
+		Sleep(10)
+	}
+	if c := atomic.LoadInt32(&count); c != int32(n) {
+		t.Fatalf("server recv %v msgs, want %v", c, n)
+	}
+}
@@ -0,0 +1,175 @@
+package antnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type SendPolicy int
+
+const (
+	SendPolicyBlock      SendPolicy = iota //写入通道满时一直阻塞，直到写入或者消息队列关闭
+	SendPolicyNonBlock                     //写入通道满时立即返回ErrSendQueueFull
+	SendPolicyTimeout                      //写入通道满时最多阻塞timeout毫秒，超时返回ErrNetTimeout
+	SendPolicyDropOldest                   //写入通道满时丢弃最早的消息，返回ErrSendDropOldest，本条消息已经写入
+	SendPolicyDisconnect                   //写入通道满时认为对方过慢，关闭连接并返回ErrSlowConsumer
+)
+
+var sendPolicyNames = []string{"block", "nonblock", "timeout", "dropoldest", "disconnect"}
+
+func (r SendPolicy) String() string {
+	if r >= 0 && int(r) < len(sendPolicyNames) {
+		return sendPolicyNames[r]
+	}
+	return "unknown"
+}
+
+// 设置消息队列默认的发送策略，Send和SendString等使用这个策略，timeout只对SendPolicyTimeout有效，单位毫秒
+// 未设置时使用Config.SendPolicy和Config.SendTimeout
+func (r *msgQue) SetSendPolicy(policy SendPolicy, timeout int) {
+	r.sendPolicy.Store(&sendPolicyConf{policy: policy, timeout: timeout})
+}
+
+type sendPolicyConf struct {
+	policy  SendPolicy
+	timeout int
+}
+
+// 设置写入通道的高水位，写入通道中的消息数达到n时调用处理器的OnSendQueueHigh，降到n以下后再次达到会再次调用
+// n为0表示使用写入通道容量的3/4，小于0表示不通知
+func (r *msgQue) SetSendHighWater(n int) {
+	atomic.StoreInt32(&r.sendHighWater, int32(n))
+}
+
+// 每次发送都会调用，不加锁
+func (r *msgQue) getSendPolicy() (SendPolicy, int) {
+	if c, ok := r.sendPolicy.Load().(*sendPolicyConf); ok {
+		return c.policy, c.timeout
+	}
+	return Config.SendPolicy, Config.SendTimeout
+}
+
+// 使用指定的策略发送消息，成功写入返回nil
+func (r *msgQue) SendWithPolicy(m *Message, policy SendPolicy, timeout int) (err error) {
+	if r.discard {
+		r.app.LogWarn("msgque discard msg by setting msgque:%v", r.id)
+		r.metricsAdd(m, metricsDiscard)
+		return nil
+	}
+	if m == nil {
+		return ErrMsgLenTooShort
+	}
+	if atomic.LoadInt32(&r.stop) == 1 {
+		return ErrNetClosed
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = ErrNetClosed
+		}
+	}()
+	r.compressMsg(m)
+	if m.Head != nil && m.Head.Flags&FlagEncrypt > 0 && m.Data != nil {
+		em, wait, err := r.encryptMsg(m, true)
+		if wait {
+			return nil
+		}
+		if err == ErrSendQueueFull {
+			return err
+		}
+		if err != nil {
+			r.app.LogError("msgque encrypt msg failed msgque:%v cmd:%v act:%v err:%v", r.id, m.Head.Cmd, m.Head.Act, err)
+			return ErrMsgEncrypt
+		}
+		m = em
+	}
+	r.addWriting(m)
+	select {
+	case r.cwrite <- m:
+	default:
+		if err = r.sendFull(m, policy, timeout); err != nil && err != ErrSendDropOldest {
+			r.doneWriting(m)
+			return err
+		}
+	}
+	r.metricsSend(m)
+	r.checkHighWater()
+	return err
+}
+
+// 写入通道满时按策略处理，FlagCanDiscard的消息在任何策略下都直接丢弃
+func (r *msgQue) sendFull(m *Message, policy SendPolicy, timeout int) error {
+	canDiscard := m.Head != nil && m.Head.Flags&FlagCanDiscard > 0
+	r.app.LogWarn("msgque write channel full msgque:%v canDiscard:%v policy:%v", r.id, canDiscard, policy)
+	r.metricsAdd(m, metricsChanFull)
+	if canDiscard {
+		r.metricsAdd(m, metricsDiscard)
+		return ErrSendQueueFull
+	}
+	switch policy {
+	case SendPolicyNonBlock:
+		r.metricsAdd(m, metricsDiscard)
+		return ErrSendQueueFull
+	case SendPolicyTimeout:
+		if timeout <= 0 {
+			timeout = DefMsgQueSendTimeout
+		}
+		t := time.NewTimer(time.Millisecond * time.Duration(timeout))
+		defer t.Stop()
+		select {
+		case r.cwrite <- m:
+			return nil
+		case <-r.cstop:
+			return ErrNetClosed
+		case <-t.C:
+			r.metricsAdd(m, metricsDiscard)
+			return ErrNetTimeout
+		}
+	case SendPolicyDropOldest:
+		for {
+			select {
+			case r.cwrite <- m:
+				return ErrSendDropOldest
+			default:
+			}
+			select {
+			case old := <-r.cwrite:
+				if old != nil {
+					r.doneWriting(old)
+					r.metricsAdd(old, metricsDiscard)
+				}
+			default:
+			}
+		}
+	case SendPolicyDisconnect:
+		r.app.LogWarn("msgque close slow consumer msgque:%v", r.id)
+		r.metricsAdd(m, metricsDiscard)
+		r.stopMsgQue()
+		return ErrSlowConsumer
+	}
+	select {
+	case r.cwrite <- m:
+		return nil
+	case <-r.cstop:
+		return ErrNetClosed
+	}
+}
+
+func (r *msgQue) checkHighWater() {
+	n := int(atomic.LoadInt32(&r.sendHighWater))
+	if n < 0 {
+		return
+	}
+	if n == 0 {
+		n = cap(r.cwrite) * 3 / 4
+	}
+	size := len(r.cwrite)
+	if size < n || n == 0 {
+		atomic.StoreInt32(&r.sendHigh, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&r.sendHigh, 0, 1) {
+		if msgque := r.app.getMsgQue(r.id); msgque != nil {
+			r.handler.OnSendQueueHigh(msgque, size, cap(r.cwrite))
+		}
+	}
+}
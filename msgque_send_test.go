@@ -0,0 +1,150 @@
+package antnet
+
+import (
+	"testing"
+	"time"
+)
+
+type sendTestHandler struct {
+	DefMsgHandler
+	chigh chan int
+}
+
+func (r *sendTestHandler) OnSendQueueHigh(msgque IMsgQue, size, capacity int) {
+	select {
+	case r.chigh <- size:
+	default:
+	}
+}
+
+// 没有写协程的消息队列，写入通道满了以后一直保持满的状态
+func newSendTestMsgQue(t *testing.T) (*tcpMsgQue, *sendTestHandler) {
+	app := NewApp(nil)
+	handler := &sendTestHandler{chigh: make(chan int, 4)}
+	msgque := newTcpConn(app, "tcp", "127.0.0.1:1", nil, MsgTypeMsg, handler, nil, nil)
+	msgque.SetSendHighWater(-1)
+	t.Cleanup(func() {
+		msgque.Stop()
+		app.Stop()
+	})
+	return msgque, handler
+}
+
+func sendTestFill(msgque *tcpMsgQue) {
+	for i := len(msgque.cwrite); i < cap(msgque.cwrite); i++ {
+		msgque.cwrite <- NewMsg(1, 1, uint16(i), 0, nil)
+	}
+}
+
+func Test_SendPolicy(t *testing.T) {
+	msgque, _ := newSendTestMsgQue(t)
+	sendTestFill(msgque)
+
+	if err := msgque.SendWithPolicy(NewMsg(1, 2, 0, 0, nil), SendPolicyNonBlock, 0); err != ErrSendQueueFull {
+		t.Fatalf("nonblock err:%v", err)
+	}
+
+	start := time.Now()
+	if err := msgque.SendWithPolicy(NewMsg(1, 2, 0, 0, nil), SendPolicyTimeout, 30); err != ErrNetTimeout || time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("timeout err:%v after %v", err, time.Since(start))
+	}
+
+	if err := msgque.SendWithPolicy(NewMsg(1, 2, 999, 0, nil), SendPolicyDropOldest, 0); err != ErrSendDropOldest {
+		t.Fatalf("drop oldest err:%v", err)
+	}
+	if m := <-msgque.cwrite; m.Index() != 1 {
+		t.Fatalf("oldest msg not dropped index:%v", m.Index())
+	}
+	sendTestFill(msgque)
+
+	//FlagCanDiscard的消息在阻塞策略下也直接丢弃
+	m := NewMsg(1, 2, 0, 0, nil)
+	m.Head.Flags |= FlagCanDiscard
+	if err := msgque.SendWithPolicy(m, SendPolicyBlock, 0); err != ErrSendQueueFull {
+		t.Fatalf("can discard err:%v", err)
+	}
+
+	cerr := make(chan error, 1)
+	go func() {
+		cerr <- msgque.SendWithPolicy(NewMsg(1, 2, 0, 0, nil), SendPolicyBlock, 0)
+	}()
+	select {
+	case err := <-cerr:
+		t.Fatalf("block returned before space err:%v", err)
+	case <-time.After(30 * time.Millisecond):
+	}
+	<-msgque.cwrite
+	select {
+	case err := <-cerr:
+		if err != nil {
+			t.Fatalf("block err:%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("block not woken up")
+	}
+
+	msgque.SetSendPolicy(SendPolicyNonBlock, 0)
+	if msgque.Send(NewMsg(1, 2, 0, 0, nil)) {
+		t.Fatal("send with nonblock default policy should fail")
+	}
+
+	if err := msgque.SendWithPolicy(NewMsg(1, 2, 0, 0, nil), SendPolicyDisconnect, 0); err != ErrSlowConsumer {
+		t.Fatalf("disconnect err:%v", err)
+	}
+	if !msgque.IsStop() {
+		t.Fatal("slow consumer should be stopped")
+	}
+}
+
+func Test_SendQueueHigh(t *testing.T) {
+	msgque, handler := newSendTestMsgQue(t)
+	msgque.SetSendHighWater(4)
+	expectHigh := func(want int) {
+		select {
+		case size := <-handler.chigh:
+			if size != want {
+				t.Fatalf("high water size:%v want %v", size, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait high water timeout")
+		}
+	}
+	expectNone := func() {
+		select {
+		case size := <-handler.chigh:
+			t.Fatalf("unexpected high water size:%v", size)
+		default:
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	}
+	expectNone()
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	expectHigh(4)
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	expectNone()
+
+	//降到高水位以下后再次达到会再次通知
+	for len(msgque.cwrite) > 0 {
+		<-msgque.cwrite
+	}
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	expectNone()
+	for i := 0; i < 3; i++ {
+		msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	}
+	expectHigh(4)
+
+	//默认高水位为容量的3/4
+	msgque.SetSendHighWater(0)
+	for len(msgque.cwrite) > 0 {
+		<-msgque.cwrite
+	}
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	for len(msgque.cwrite) < cap(msgque.cwrite)*3/4 {
+		msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	}
+	expectHigh(cap(msgque.cwrite) * 3 / 4)
+}
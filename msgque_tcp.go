@@ -2,6 +2,7 @@ package antnet
 
 import (
 	"bufio"
+	"crypto/tls"
 	"io"
 	"net"
 	"sync"
@@ -29,27 +30,38 @@ func (r *tcpMsgQue) GetNetType() NetType {
 }
 func (r *tcpMsgQue) Stop() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
-		Go(func() {
+		r.app.Go(func() {
 			if r.init {
 				r.handler.OnDelMsgQue(r)
-				if r.connecting == 1 {
-					r.available = false
+				if atomic.LoadInt32(&r.connecting) == 1 && !r.cancelConnect(&r.connecting) {
+					r.setAvailable(false)
 					return
 				}
 			}
-			r.available = false
+			r.setAvailable(false)
 			r.baseStop()
 		})
+	} else if r.cancelConnect(&r.connecting) {
+		r.app.Go(func() {
+			r.setAvailable(false)
+			r.baseStop()
+		})
+	}
+}
+
+func (r *tcpMsgQue) closeListen() {
+	if r.listener != nil {
+		r.listener.Close()
 	}
 }
 
 func (r *tcpMsgQue) IsStop() bool {
-	if r.stop == 0 {
-		if IsStop() {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
 }
 
 func (r *tcpMsgQue) LocalAddr() string {
@@ -81,17 +93,17 @@ func (r *tcpMsgQue) readMsg() {
 			_, err := io.ReadFull(r.conn, headData)
 			if err != nil {
 				if err != io.EOF {
-					LogDebug("msgque:%v recv data err:%v", r.id, err)
+					r.app.LogDebug("msgque:%v recv data err:%v", r.id, err)
 				}
 				break
 			}
 			if head = NewMessageHead(headData); head == nil {
-				LogError("msgque:%v read msg head failed", r.id)
+				r.app.LogError("msgque:%v read msg head failed", r.id)
 				break
 			}
 			if head.Len == 0 {
 				if !r.processMsg(r, &Message{Head: head}) {
-					LogError("msgque:%v process msg cmd:%v act:%v", r.id, head.Cmd, head.Act)
+					r.app.LogError("msgque:%v process msg cmd:%v act:%v", r.id, head.Cmd, head.Act)
 					break
 				}
 				head = nil
@@ -101,19 +113,19 @@ func (r *tcpMsgQue) readMsg() {
 		} else {
 			_, err := io.ReadFull(r.conn, data)
 			if err != nil {
-				LogError("msgque:%v recv data err:%v", r.id, err)
+				r.app.LogError("msgque:%v recv data err:%v", r.id, err)
 				break
 			}
 
 			if !r.processMsg(r, &Message{Head: head, Data: data}) {
-				LogError("msgque:%v process msg cmd:%v act:%v", r.id, head.Cmd, head.Act)
+				r.app.LogError("msgque:%v process msg cmd:%v act:%v", r.id, head.Cmd, head.Act)
 				break
 			}
 
 			head = nil
 			data = nil
 		}
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
 }
 
@@ -126,16 +138,20 @@ func (r *tcpMsgQue) writeMsg() {
 	for !r.IsStop() || m != nil {
 		if m == nil {
 			select {
-			case <-stopChanForGo:
+			case <-r.app.stopChanForGo:
+			case <-r.cstop:
 			case m = <-r.cwrite:
 				if m != nil {
 					data = m.Bytes()
 				}
 			case <-gm.c:
 				if gm.fun == nil || gm.fun(r) {
-					m = gm.msg
-					data = m.Bytes()
+					m = r.encryptGMsg(gm.msg)
+					if m != nil {
+						data = m.Bytes()
+					}
 				}
+				r.addWriting(m)
 				gm = r.getGMsg(true)
 			case <-tick.C:
 				if r.isTimeout(tick) {
@@ -151,7 +167,7 @@ func (r *tcpMsgQue) writeMsg() {
 		if writeCount < len(data) {
 			n, err := r.conn.Write(data[writeCount:])
 			if err != nil {
-				LogError("msgque write id:%v err:%v", r.id, err)
+				r.app.LogError("msgque write id:%v err:%v", r.id, err)
 				break
 			}
 			writeCount += n
@@ -159,10 +175,12 @@ func (r *tcpMsgQue) writeMsg() {
 
 		if writeCount == len(data) {
 			writeCount = 0
+			r.doneWriting(m)
 			m = nil
 		}
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
+	r.doneWriting(m)
 	tick.Stop()
 }
 
@@ -187,7 +205,7 @@ func (r *tcpMsgQue) readCmd() {
 		if !r.processMsg(r, &Message{Data: data}) {
 			break
 		}
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
 }
 
@@ -199,12 +217,14 @@ func (r *tcpMsgQue) writeCmd() {
 	for !r.IsStop() || m != nil {
 		if m == nil {
 			select {
-			case <-stopChanForGo:
+			case <-r.app.stopChanForGo:
+			case <-r.cstop:
 			case m = <-r.cwrite:
 			case <-gm.c:
 				if gm.fun == nil || gm.fun(r) {
 					m = gm.msg
 				}
+				r.addWriting(m)
 				gm = r.getGMsg(true)
 			case <-tick.C:
 				if r.isTimeout(tick) {
@@ -214,35 +234,37 @@ func (r *tcpMsgQue) writeCmd() {
 		}
 
 		if m == nil || m.Data == nil {
+			r.doneWriting(m)
 			m = nil
 			continue
 		}
 		n, err := r.conn.Write(m.Data[writeCount:])
 		if err != nil {
-			LogError("msgque write id:%v err:%v", r.id, err)
+			r.app.LogError("msgque write id:%v err:%v", r.id, err)
 			break
 		}
 		writeCount += n
 		if writeCount == len(m.Data) {
 			writeCount = 0
+			r.doneWriting(m)
 			m = nil
 		}
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
+	r.doneWriting(m)
 	tick.Stop()
 }
 
 func (r *tcpMsgQue) read() {
 	defer func() {
-		r.wait.Done()
 		if err := recover(); err != nil {
-			LogError("msgque read panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque read panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
 		r.Stop()
+		r.wait.Done()
 	}()
 
-	r.wait.Add(1)
 	if r.msgTyp == MsgTypeCmd {
 		r.readCmd()
 	} else {
@@ -252,17 +274,16 @@ func (r *tcpMsgQue) read() {
 
 func (r *tcpMsgQue) write() {
 	defer func() {
-		r.wait.Done()
 		if err := recover(); err != nil {
-			LogError("msgque write panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque write panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
 		if r.conn != nil {
 			r.conn.Close()
 		}
 		r.Stop()
+		r.wait.Done() //重连等待这里，之后才能替换conn
 	}()
-	r.wait.Add(1)
 	if r.msgTyp == MsgTypeCmd {
 		r.writeCmd()
 	} else {
@@ -272,7 +293,7 @@ func (r *tcpMsgQue) write() {
 
 func (r *tcpMsgQue) listen() {
 	c := make(chan struct{})
-	Go2(func(cstop chan struct{}) {
+	r.app.Go2(func(cstop chan struct{}) {
 		select {
 		case <-cstop:
 		case <-c:
@@ -282,25 +303,37 @@ func (r *tcpMsgQue) listen() {
 	for !r.IsStop() {
 		c, err := r.listener.Accept()
 		if err != nil {
-			if stop == 0 && r.stop == 0 {
-				LogError("accept failed msgque:%v err:%v", r.id, err)
+			if atomic.LoadInt32(&r.app.stop) == 0 && atomic.LoadInt32(&r.stop) == 0 && !r.app.IsDraining() {
+				r.app.LogError("accept failed msgque:%v err:%v", r.id, err)
 			}
 			break
 		} else {
-			Go(func() {
-				msgque := newTcpAccept(c, r.msgTyp, r.handler, r.parserFactory)
+			r.app.Go(func() {
+				realAddr, err := proxyProtoAddr(c)
+				if err != nil {
+					c.Close()
+					return
+				}
+				if err := tlsHandshake(c); err != nil {
+					r.app.LogError("tls handshake failed msgque:%v addr:%v err:%v", r.id, c.RemoteAddr(), err)
+					c.Close()
+					return
+				}
+				msgque := newTcpAccept(r.app, c, r.msgTyp, r.handler, r.parserFactory)
+				msgque.realRemoteAddr = realAddr
 				if r.handler.OnNewMsgQue(msgque) {
 					msgque.init = true
-					msgque.available = true
-					Go(func() {
-						LogInfo("process read for msgque:%d", msgque.id)
+					msgque.setAvailable(true)
+					msgque.wait.Add(2)
+					r.app.Go(func() {
+						r.app.LogInfo("process read for msgque:%d", msgque.id)
 						msgque.read()
-						LogInfo("process read end for msgque:%d", msgque.id)
+						r.app.LogInfo("process read end for msgque:%d", msgque.id)
 					})
-					Go(func() {
-						LogInfo("process write for msgque:%d", msgque.id)
+					r.app.Go(func() {
+						r.app.LogInfo("process write for msgque:%d", msgque.id)
 						msgque.write()
-						LogInfo("process write end for msgque:%d", msgque.id)
+						r.app.LogInfo("process write end for msgque:%d", msgque.id)
 					})
 				} else {
 					msgque.Stop()
@@ -314,28 +347,48 @@ func (r *tcpMsgQue) listen() {
 }
 
 func (r *tcpMsgQue) connect() {
-	LogDebug("connect to addr:%s msgque:%d", r.address, r.id)
-	c, err := net.DialTimeout(r.network, r.address, time.Second)
+	if r.app.IsDraining() {
+		if atomic.CompareAndSwapInt32(&r.connecting, 1, 0) {
+			r.Stop()
+		}
+		return
+	}
+	r.app.LogDebug("connect to addr:%s msgque:%d", r.address, r.id)
+	var c net.Conn
+	var err error
+	if r.network == "tls" {
+		var conf *tls.Config
+		conf, err = newTlsClientConfig(r.address)
+		if err == nil {
+			c, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", r.address, conf)
+		}
+	} else {
+		c, err = net.DialTimeout(r.network, r.address, time.Second)
+	}
 	if err != nil {
-		LogError("connect to addr:%s failed msgque:%d err:%v", r.address, r.id, err)
+		r.app.LogError("connect to addr:%s failed msgque:%d err:%v", r.address, r.id, err)
 		r.handler.OnConnectComplete(r, false)
 		atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
 		r.Stop()
+	} else if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+		c.Close()
 	} else {
 		r.conn = c
-		r.available = true
-		LogDebug("connect to addr:%s ok msgque:%d", r.address, r.id)
+		r.setAvailable(true)
+		r.restartHeartbeat()
+		r.app.LogDebug("connect to addr:%s ok msgque:%d", r.address, r.id)
 		if r.handler.OnConnectComplete(r, true) {
 			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
-			Go(func() {
-				LogInfo("process read for msgque:%d", r.id)
+			r.wait.Add(2) //在协程外增加计数，Reconnect等待时不会漏掉
+			r.app.Go(func() {
+				r.app.LogInfo("process read for msgque:%d", r.id)
 				r.read()
-				LogInfo("process read end for msgque:%d", r.id)
+				r.app.LogInfo("process read end for msgque:%d", r.id)
 			})
-			Go(func() {
-				LogInfo("process write for msgque:%d", r.id)
+			r.app.Go(func() {
+				r.app.LogInfo("process write for msgque:%d", r.id)
 				r.write()
-				LogInfo("process write end for msgque:%d", r.id)
+				r.app.LogInfo("process write end for msgque:%d", r.id)
 			})
 		} else {
 			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
@@ -345,11 +398,11 @@ func (r *tcpMsgQue) connect() {
 }
 
 func (r *tcpMsgQue) Reconnect(t int) {
-	if IsStop() {
+	if r.app.IsStop() || r.app.IsDraining() {
 		return
 	}
 	if r.conn != nil {
-		if r.stop == 0 {
+		if atomic.LoadInt32(&r.stop) == 0 {
 			return
 		}
 	}
@@ -364,37 +417,42 @@ func (r *tcpMsgQue) Reconnect(t int) {
 		}
 	}
 	r.init = true
-	Go(func() {
+	r.app.Go(func() {
 		if len(r.cwrite) == 0 {
 			r.cwrite <- nil
 		}
 		r.wait.Wait()
 		if t > 0 {
-			SetTimeout(t*1000, func(arg ...interface{}) int {
-				r.stop = 0
+			r.app.SetTimeout(t*1000, func(arg ...interface{}) int {
+				if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+					return 0
+				}
+				atomic.StoreInt32(&r.stop, 0)
 				r.connect()
 				return 0
 			})
 		} else {
-			r.stop = 0
+			atomic.StoreInt32(&r.stop, 0)
 			r.connect()
 		}
 
 	})
 }
 
-func newTcpConn(network, addr string, conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) *tcpMsgQue {
+func newTcpConn(app *App, network, addr string, conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) *tcpMsgQue {
 	msgque := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
 			msgTyp:        msgtyp,
 			handler:       handler,
 			timeout:       DefMsgQueTimeout,
 			connTyp:       ConnTypeConn,
-			gmsgId:        gmsgId,
+			gmsgId:        app.getGMsgId(),
 			parserFactory: parser,
-			lastTick:      Timestamp,
+			lastTick:      timestamp(),
 			user:          user,
 		},
 		conn:    conn,
@@ -404,24 +462,26 @@ func newTcpConn(network, addr string, conn net.Conn, msgtyp MsgType, handler IMs
 	if parser != nil {
 		msgque.parser = parser.Get()
 	}
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogDebug("new msgque id:%d connect to addr:%s:%s", msgque.id, network, addr)
+	msgque.netType = msgque.GetNetType()
+	initHeartbeat(&msgque)
+	app.addMsgQue(&msgque)
+	app.LogDebug("new msgque id:%d connect to addr:%s:%s", msgque.id, network, addr)
 	return &msgque
 }
 
-func newTcpAccept(conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *tcpMsgQue {
+func newTcpAccept(app *App, conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *tcpMsgQue {
 	msgque := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
 			msgTyp:        msgtyp,
 			handler:       handler,
 			timeout:       DefMsgQueTimeout,
 			connTyp:       ConnTypeAccept,
-			gmsgId:        gmsgId,
-			lastTick:      Timestamp,
+			gmsgId:        app.getGMsgId(),
+			lastTick:      timestamp(),
 			parserFactory: parser,
 		},
 		conn: conn,
@@ -429,17 +489,18 @@ func newTcpAccept(conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser IPa
 	if parser != nil {
 		msgque.parser = parser.Get()
 	}
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new msgque id:%d from addr:%s", msgque.id, conn.RemoteAddr().String())
+	msgque.netType = msgque.GetNetType()
+	initHeartbeat(&msgque)
+	app.addMsgQue(&msgque)
+	app.LogInfo("new msgque id:%d from addr:%s", msgque.id, conn.RemoteAddr().String())
 	return &msgque
 }
 
-func newTcpListen(listener net.Listener, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr string) *tcpMsgQue {
+func newTcpListen(app *App, listener net.Listener, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr string) *tcpMsgQue {
 	msgque := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
 			parserFactory: parser,
@@ -448,9 +509,8 @@ func newTcpListen(listener net.Listener, msgtyp MsgType, handler IMsgHandler, pa
 		listener: listener,
 	}
 
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new tcp listen id:%d addr:%s", msgque.id, addr)
+	msgque.netType = msgque.GetNetType()
+	app.addMsgQue(&msgque)
+	app.LogInfo("new tcp listen id:%d addr:%s", msgque.id, addr)
 	return &msgque
 }
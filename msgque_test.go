@@ -0,0 +1,165 @@
+package antnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 在随机端口上监听，返回实际监听的地址
+func testStartServer(t *testing.T, app *App, network string, typ MsgType, handler IMsgHandler, parser IParserFactory) string {
+	var last uint32
+	app.msgqueMapSync.Lock()
+	for id := range app.msgqueMap {
+		if id > last {
+			last = id
+		}
+	}
+	app.msgqueMapSync.Unlock()
+	if err := app.StartServer(network+"://127.0.0.1:0", typ, handler, parser); err != nil {
+		t.Fatal(err)
+	}
+	app.msgqueMapSync.Lock()
+	defer app.msgqueMapSync.Unlock()
+	for id, msgque := range app.msgqueMap {
+		if id > last && msgque.GetConnType() == ConnTypeListen {
+			return msgque.LocalAddr()
+		}
+	}
+	t.Fatal("listen msgque not found")
+	return ""
+}
+
+type callTestClient struct {
+	DefMsgHandler
+	cconn chan bool
+}
+
+func (r *callTestClient) OnConnectComplete(msgque IMsgQue, ok bool) bool {
+	r.cconn <- ok
+	return ok
+}
+
+func callTestConnect(t *testing.T, app *App, network, addr string) IMsgQue {
+	handler := &callTestClient{cconn: make(chan bool, 1)}
+	msgque := app.StartConnect(network, addr, MsgTypeMsg, handler, nil, nil)
+	select {
+	case ok := <-handler.cconn:
+		if !ok {
+			t.Fatalf("connect to %v failed", addr)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("connect to %v timeout", addr)
+	}
+	return msgque
+}
+
+// 1号消息回显，2号回复错误码，3号不回复，4号关闭连接
+func callTestHandler() *DefMsgHandler {
+	server := &DefMsgHandler{}
+	server.Register(1, 1, func(msgque IMsgQue, msg *Message) bool {
+		msgque.Send(NewMsg(1, 1, msg.Index(), 0, msg.Data))
+		return true
+	})
+	server.Register(1, 2, func(msgque IMsgQue, msg *Message) bool {
+		msgque.Send(NewMsg(1, 2, msg.Index(), ErrServiceNotFound.Id, nil))
+		return true
+	})
+	server.Register(1, 3, func(msgque IMsgQue, msg *Message) bool {
+		return true
+	})
+	server.Register(1, 4, func(msgque IMsgQue, msg *Message) bool {
+		return false
+	})
+	return server
+}
+
+func callTestServer(t *testing.T, app *App, network string) string {
+	return testStartServer(t, app, network, MsgTypeMsg, callTestHandler(), nil)
+}
+
+func Test_Call(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	msgque := callTestConnect(t, app, "tcp", callTestServer(t, app, "tcp"))
+
+	for i := 0; i < 100; i++ {
+		var resp []byte
+		if err := msgque.Call(context.Background(), 1, 1, []byte("hello"), &resp); err != nil || string(resp) != "hello" {
+			t.Fatalf("call %v resp:%q err:%v", i, resp, err)
+		}
+	}
+	if err := msgque.Call(context.Background(), 1, 2, nil, nil); err != ErrServiceNotFound {
+		t.Fatalf("error reply err:%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := msgque.Call(ctx, 1, 3, nil, nil); err != ErrNetTimeout || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("timeout err:%v after %v", err, time.Since(start))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	if err := msgque.Call(ctx, 1, 3, nil, nil); err != context.Canceled {
+		t.Fatalf("cancel err:%v", err)
+	}
+
+	//超时和取消的回调已经删除，不影响后面的调用
+	var resp []byte
+	if err := msgque.Call(context.Background(), 1, 1, []byte("again"), &resp); err != nil || string(resp) != "again" {
+		t.Fatalf("call after timeout resp:%q err:%v", resp, err)
+	}
+}
+
+func Test_CallClosed(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	addr := callTestServer(t, app, "tcp")
+
+	//对方在调用过程中关闭连接
+	msgque := callTestConnect(t, app, "tcp", addr)
+	if err := msgque.Call(context.Background(), 1, 4, nil, nil); err != ErrNetClosed {
+		t.Fatalf("peer close err:%v", err)
+	}
+
+	//本地在调用过程中停止
+	msgque = callTestConnect(t, app, "tcp", addr)
+	time.AfterFunc(30*time.Millisecond, msgque.Stop)
+	if err := msgque.Call(context.Background(), 1, 3, nil, nil); err != ErrNetClosed {
+		t.Fatalf("local stop err:%v", err)
+	}
+	if err := msgque.Call(context.Background(), 1, 1, nil, nil); err != ErrNetClosed {
+		t.Fatalf("call on stopped msgque err:%v", err)
+	}
+}
+
+type callbackTestHandler struct {
+	DefMsgHandler
+	delivered bool
+}
+
+// 在Send返回前模拟回复到达
+func (r *callbackTestHandler) OnSendQueueHigh(msgque IMsgQue, size, capacity int) {
+	r.delivered = msgque.tryCallback(NewMsg(1, 1, 9, 0, []byte("fast")))
+}
+
+func Test_SendCallbackFastReply(t *testing.T) {
+	app := NewApp(nil)
+	handler := &callbackTestHandler{}
+	msgque := newTcpConn(app, "tcp", "127.0.0.1:1", nil, MsgTypeMsg, handler, nil, nil)
+	defer app.Stop()
+	defer msgque.Stop()
+	msgque.SetSendHighWater(1)
+	c := make(chan *Message, 1)
+	if !msgque.SendCallback(NewMsg(1, 1, 9, 0, nil), c) {
+		t.Fatal("send callback failed")
+	}
+	if !handler.delivered {
+		t.Fatal("reply before callback registered was dropped")
+	}
+	if m := <-c; m == nil || string(m.Data) != "fast" {
+		t.Fatalf("bad reply %v", m)
+	}
+}
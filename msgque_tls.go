@@ -0,0 +1,153 @@
+package antnet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 证书加载器，证书文件修改后自动重新加载，不需要重启监听
+type tlsCertLoader struct {
+	crtPath   string
+	keyPath   string
+	caPath    string
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTime   int64
+	checkTime int64 //上次检查文件的毫秒数
+	lock      sync.Mutex
+}
+
+func (r *tlsCertLoader) fileModTime() int64 {
+	var mod int64
+	for _, p := range []string{r.crtPath, r.keyPath, r.caPath} {
+		if p == "" {
+			continue
+		}
+		if info, err := os.Stat(p); err == nil && info.ModTime().UnixNano() > mod {
+			mod = info.ModTime().UnixNano()
+		}
+	}
+	return mod
+}
+
+func (r *tlsCertLoader) load() error {
+	var cert *tls.Certificate
+	if r.crtPath != "" && r.keyPath != "" {
+		c, err := tls.LoadX509KeyPair(r.crtPath, r.keyPath)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caPath != "" {
+		data, err := ReadFile(r.caPath)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return ErrConfigPath
+		}
+	}
+	r.cert = cert
+	r.pool = pool
+	return nil
+}
+
+func (r *tlsCertLoader) get() (*tls.Certificate, *x509.CertPool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	interval := int64(Config.SSLReloadInterval)
+	if interval <= 0 {
+		interval = 1000
+	}
+	if now := nowTick(); now-r.checkTime >= interval {
+		r.checkTime = now
+		if mod := r.fileModTime(); mod != r.modTime {
+			if err := r.load(); err != nil {
+				LogError("reload tls cert failed crt:%v key:%v ca:%v err:%v", r.crtPath, r.keyPath, r.caPath, err)
+			} else {
+				r.modTime = mod
+				LogInfo("reload tls cert crt:%v key:%v ca:%v", r.crtPath, r.keyPath, r.caPath)
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+// 监听和连接使用不同的CA，监听校验客户端证书，连接校验服务器证书
+func newTlsCertLoader(caPath string) (*tlsCertLoader, error) {
+	loader := &tlsCertLoader{
+		crtPath:   Config.SSLCrtPath,
+		keyPath:   Config.SSLKeyPath,
+		caPath:    caPath,
+		checkTime: nowTick(),
+	}
+	loader.modTime = loader.fileModTime()
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+func newTlsServerConfig() (*tls.Config, error) {
+	if Config.SSLCrtPath == "" || Config.SSLKeyPath == "" {
+		return nil, ErrConfigPath
+	}
+	loader, err := newTlsCertLoader(Config.SSLClientCaPath)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{}
+	conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := loader.get()
+		c := &tls.Config{Certificates: []tls.Certificate{*cert}}
+		if pool != nil {
+			c.ClientCAs = pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c, nil
+	}
+	return conf, nil
+}
+
+func newTlsClientConfig(addr string) (*tls.Config, error) {
+	loader, err := newTlsCertLoader(Config.SSLRootCaPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, pool := loader.get()
+	conf := &tls.Config{
+		RootCAs:            pool,
+		ServerName:         Config.SSLServerName,
+		InsecureSkipVerify: Config.SSLInsecure,
+	}
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			conf.ServerName = host
+		}
+	}
+	if cert != nil {
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := loader.get()
+			return cert, nil
+		}
+	}
+	return conf, nil
+}
+
+func tlsHandshake(conn net.Conn) error {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tc.SetDeadline(time.Now().Add(time.Second * 5))
+	err := tc.Handshake()
+	tc.SetDeadline(time.Time{})
+	return err
+}
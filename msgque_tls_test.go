@@ -0,0 +1,207 @@
+package antnet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用的临时CA
+type tlsTestCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func tlsTestCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func newTlsTestCA(t *testing.T, name string) *tlsTestCA {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	cert, key, crtPem, _ := tlsTestCert(t, tmpl, nil, nil)
+	return &tlsTestCA{cert: cert, key: key, pem: crtPem}
+}
+
+// 签发127.0.0.1的证书，服务器和客户端都可以使用
+func (r *tlsTestCA) issue(t *testing.T, name string) ([]byte, []byte) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	_, _, crtPem, keyPem := tlsTestCert(t, tmpl, r.cert, r.key)
+	return crtPem, keyPem
+}
+
+func tlsTestWrite(t *testing.T, path string, data []byte) string {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 写入证书文件，返回证书和私钥的路径
+func (r *tlsTestCA) write(t *testing.T, dir, name string) (string, string) {
+	crtPem, keyPem := r.issue(t, name)
+	return tlsTestWrite(t, filepath.Join(dir, name+".crt"), crtPem), tlsTestWrite(t, filepath.Join(dir, name+".key"), keyPem)
+}
+
+func tlsTestConfig(t *testing.T) {
+	old := Config
+	t.Cleanup(func() {
+		Config = old
+	})
+}
+
+// 返回连接是否成功，成功时用一次调用确认连接可用
+func tlsTestCall(t *testing.T, app *App, addr string) error {
+	handler := &callTestClient{cconn: make(chan bool, 1)}
+	msgque := app.StartConnect("tls", addr, MsgTypeMsg, handler, nil, nil)
+	defer msgque.Stop()
+	select {
+	case ok := <-handler.cconn:
+		if !ok {
+			return ErrNetClosed
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("connect to %v timeout", addr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var resp []byte
+	if err := msgque.Call(ctx, 1, 1, []byte("tls"), &resp); err != nil {
+		return err
+	}
+	if string(resp) != "tls" {
+		t.Fatalf("bad resp %q", resp)
+	}
+	return nil
+}
+
+func Test_TlsHandshake(t *testing.T) {
+	tlsTestConfig(t)
+	dir := t.TempDir()
+	ca := newTlsTestCA(t, "ca")
+	app := NewApp(nil)
+	defer app.Stop()
+
+	Config.SSLCrtPath, Config.SSLKeyPath = ca.write(t, dir, "server")
+	addr := callTestServer(t, app, "tls")
+
+	Config.SSLCrtPath, Config.SSLKeyPath = "", ""
+	Config.SSLRootCaPath = tlsTestWrite(t, filepath.Join(dir, "ca.crt"), ca.pem)
+	if err := tlsTestCall(t, app, addr); err != nil {
+		t.Fatalf("tls call err:%v", err)
+	}
+
+	//不信任服务器证书的CA
+	Config.SSLRootCaPath = tlsTestWrite(t, filepath.Join(dir, "other.crt"), newTlsTestCA(t, "other").pem)
+	if err := tlsTestCall(t, app, addr); err != ErrNetClosed {
+		t.Fatalf("untrusted server err:%v", err)
+	}
+}
+
+func Test_TlsClientCert(t *testing.T) {
+	tlsTestConfig(t)
+	dir := t.TempDir()
+	ca := newTlsTestCA(t, "ca")
+	other := newTlsTestCA(t, "other")
+	app := NewApp(nil)
+	defer app.Stop()
+
+	//监听校验客户端证书的CA和连接校验服务器的CA分开设置
+	caPath := tlsTestWrite(t, filepath.Join(dir, "ca.crt"), ca.pem)
+	Config.SSLCrtPath, Config.SSLKeyPath = ca.write(t, dir, "server")
+	Config.SSLClientCaPath = caPath
+	addr := callTestServer(t, app, "tls")
+	Config.SSLClientCaPath = ""
+	Config.SSLRootCaPath = caPath
+
+	Config.SSLCrtPath, Config.SSLKeyPath = ca.write(t, dir, "client")
+	if err := tlsTestCall(t, app, addr); err != nil {
+		t.Fatalf("valid client cert err:%v", err)
+	}
+
+	Config.SSLCrtPath, Config.SSLKeyPath = "", ""
+	if err := tlsTestCall(t, app, addr); err != ErrNetClosed {
+		t.Fatalf("missing client cert err:%v", err)
+	}
+
+	Config.SSLCrtPath, Config.SSLKeyPath = other.write(t, dir, "invalid")
+	if err := tlsTestCall(t, app, addr); err != ErrNetClosed {
+		t.Fatalf("invalid client cert err:%v", err)
+	}
+}
+
+func Test_TlsReload(t *testing.T) {
+	tlsTestConfig(t)
+	dir := t.TempDir()
+	ca := newTlsTestCA(t, "ca")
+	other := newTlsTestCA(t, "other")
+	app := NewApp(nil)
+	defer app.Stop()
+	Config.SSLReloadInterval = 10
+
+	//开始时服务器证书不被客户端信任，替换证书文件后新的连接使用新证书
+	crtPath, keyPath := other.write(t, dir, "server")
+	Config.SSLCrtPath, Config.SSLKeyPath = crtPath, keyPath
+	addr := callTestServer(t, app, "tls")
+	Config.SSLCrtPath, Config.SSLKeyPath = "", ""
+	Config.SSLRootCaPath = tlsTestWrite(t, filepath.Join(dir, "ca.crt"), ca.pem)
+	if err := tlsTestCall(t, app, addr); err != ErrNetClosed {
+		t.Fatalf("untrusted server err:%v", err)
+	}
+
+	crtPem, keyPem := ca.issue(t, "server")
+	tlsTestWrite(t, crtPath, crtPem)
+	tlsTestWrite(t, keyPath, keyPem)
+	mod := time.Now().Add(time.Second)
+	os.Chtimes(crtPath, mod, mod)
+	os.Chtimes(keyPath, mod, mod)
+	time.Sleep(50 * time.Millisecond)
+	if err := tlsTestCall(t, app, addr); err != nil {
+		t.Fatalf("rotated cert err:%v", err)
+	}
+}
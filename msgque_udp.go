@@ -9,9 +9,14 @@ import (
 
 type udpMsgQue struct {
 	msgQue
-	conn  *net.UDPConn //连接
-	cread chan []byte  //写入通道
-	addr  *net.UDPAddr
+	conn       *net.UDPConn //连接
+	cread      chan []byte  //写入通道
+	addr       *net.UDPAddr
+	address    string
+	reliable   bool    //是否为可靠udp
+	rudp       *rudpCB //可靠udp控制块
+	wait       sync.WaitGroup
+	connecting int32
 	sync.Mutex
 }
 
@@ -23,35 +28,61 @@ type udpMsgQueHelper struct {
 }
 
 func (r *udpMsgQue) GetNetType() NetType {
+	if r.reliable {
+		return NetTypeRudp
+	}
 	return NetTypeUdp
 }
 
 func (r *udpMsgQue) Stop() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
-		Go(func() {
+		r.app.Go(func() {
 			if r.init {
 				r.handler.OnDelMsgQue(r)
+				if r.connTyp == ConnTypeConn && atomic.LoadInt32(&r.connecting) == 1 && !r.cancelConnect(&r.connecting) {
+					r.setAvailable(false)
+					return
+				}
 			}
-			r.available = false
-			if r.cread != nil {
-				close(r.cread)
+			r.setAvailable(false)
+			if r.connTyp == ConnTypeAccept {
+				if r.cread != nil {
+					close(r.cread)
+				}
+				r.app.udpMapLock.Lock()
+				delete(r.app.udpMap, r.addr.String())
+				r.app.udpMapLock.Unlock()
 			}
-
-			udpMapLock.Lock()
-			delete(udpMap, r.addr.String())
-			udpMapLock.Unlock()
+			r.baseStop()
+		})
+	} else if r.cancelConnect(&r.connecting) {
+		r.app.Go(func() {
+			r.setAvailable(false)
 			r.baseStop()
 		})
 	}
 }
 
+func (r *udpMsgQue) drained() bool {
+	if !r.msgQue.drained() {
+		return false
+	}
+	if atomic.LoadInt32(&r.stop) == 1 || r.rudp == nil {
+		return true
+	}
+	r.rudp.Lock()
+	n := r.rudp.waitSnd()
+	r.rudp.Unlock()
+	return n == 0
+}
+
 func (r *udpMsgQue) IsStop() bool {
-	if r.stop == 0 {
-		if IsStop() {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
 }
 
 func (r *udpMsgQue) LocalAddr() string {
@@ -68,16 +99,42 @@ func (r *udpMsgQue) RemoteAddr() string {
 	if r.addr != nil {
 		return r.addr.String()
 	}
-	return ""
+	return r.address
+}
+
+func (r *udpMsgQue) processData(data []byte) bool {
+	var msg *Message
+	if r.msgTyp == MsgTypeCmd {
+		msg = &Message{Data: data}
+	} else {
+		head := MessageHeadFromByte(data)
+		if head == nil {
+			return false
+		}
+		if head.Len > 0 {
+			msg = &Message{Head: head, Data: data[MsgHeadSize:]}
+		} else {
+			msg = &Message{Head: head}
+		}
+	}
+	if !r.init {
+		if !r.handler.OnNewMsgQue(r) {
+			return false
+		}
+		r.init = true
+	}
+
+	return r.processMsg(r, msg)
 }
 
 func (r *udpMsgQue) read() {
 	defer func() {
 		if err := recover(); err != nil {
-			LogError("msgque read panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque read panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
 		r.Stop()
+		r.wait.Done()
 	}()
 	var data []byte
 	for !r.IsStop() {
@@ -87,53 +144,54 @@ func (r *udpMsgQue) read() {
 		if data == nil {
 			break
 		}
-		var msg *Message
-		if r.msgTyp == MsgTypeCmd {
-			msg = &Message{Data: data}
-		} else {
-			head := MessageHeadFromByte(data)
-			if head == nil {
-				break
-			}
-			if head.Len > 0 {
-				msg = &Message{Head: head, Data: data[MsgHeadSize:]}
-			} else {
-				msg = &Message{Head: head}
-			}
-		}
-		r.lastTick = Timestamp
-		if !r.init {
-			if !r.handler.OnNewMsgQue(r) {
+		atomic.StoreInt64(&r.lastTick, timestamp())
+		if r.rudp != nil {
+			if !r.readRudp(data) {
 				break
 			}
-			r.init = true
-		}
-
-		if !r.processMsg(r, msg) {
+		} else if !r.processData(data) {
 			break
 		}
 	}
 }
 
+func (r *udpMsgQue) writeTo(data []byte) {
+	if r.connTyp == ConnTypeConn {
+		r.conn.Write(data)
+	} else {
+		r.conn.WriteToUDP(data, r.addr)
+	}
+}
+
 func (r *udpMsgQue) write() {
 	defer func() {
 		if err := recover(); err != nil {
-			LogError("msgque write panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque write panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
+		if r.connTyp == ConnTypeConn && r.conn != nil {
+			r.conn.Close()
+		}
 		r.Stop()
+		r.wait.Done()
 	}()
+	if r.rudp != nil {
+		r.writeRudp()
+		return
+	}
 	gm := r.getGMsg(false)
 	tick := time.NewTimer(time.Second * time.Duration(r.timeout))
 	for !r.IsStop() {
 		var m *Message = nil
 		select {
-		case <-stopChanForGo:
+		case <-r.app.stopChanForGo:
+		case <-r.cstop:
 		case m = <-r.cwrite:
 		case <-gm.c:
 			if gm.fun == nil || gm.fun(r) {
-				m = gm.msg
+				m = r.encryptGMsg(gm.msg)
 			}
+			r.addWriting(m)
 			gm = r.getGMsg(true)
 		case <-tick.C:
 			if r.isTimeout(tick) {
@@ -147,15 +205,16 @@ func (r *udpMsgQue) write() {
 
 		if r.msgTyp == MsgTypeCmd {
 			if m.Data != nil {
-				r.conn.WriteToUDP(m.Data, r.addr)
+				r.writeTo(m.Data)
 			}
 		} else {
 			if m.Head != nil || m.Data != nil {
-				r.conn.WriteToUDP(m.Bytes(), r.addr)
+				r.writeTo(m.Bytes())
 			}
 		}
+		r.doneWriting(m)
 
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
 
 	tick.Stop()
@@ -177,9 +236,6 @@ func (r *udpMsgQue) sendRead(data []byte, n int) (re bool) {
 	return
 }
 
-var udpMap map[string]*udpMsgQueHelper = map[string]*udpMsgQueHelper{}
-var udpMapLock sync.Mutex
-
 func (r *udpMsgQue) listenTrue() {
 	data := make([]byte, 1<<16)
 	for !r.IsStop() {
@@ -198,37 +254,41 @@ func (r *udpMsgQue) listenTrue() {
 		}
 
 		addrStr := addr.String()
-		udpMapLock.Lock()
-		helper, ok := udpMap[addrStr]
+		r.app.udpMapLock.Lock()
+		helper, ok := r.app.udpMap[addrStr]
 		if !ok {
+			if r.app.IsDraining() {
+				r.app.udpMapLock.Unlock()
+				continue
+			}
 			helper = &udpMsgQueHelper{null: true}
-			udpMap[addrStr] = helper
+			r.app.udpMap[addrStr] = helper
 		}
-		udpMapLock.Unlock()
+		r.app.udpMapLock.Unlock()
 
 		if helper.null {
 			helper.Lock()
 			if atomic.CompareAndSwapInt32(&helper.init, 0, 1) {
-				helper.udpMsgQue = newUdpAccept(r.conn, r.msgTyp, r.handler, r.parserFactory, addr)
+				helper.udpMsgQue = newUdpAccept(r.app, r.conn, r.msgTyp, r.handler, r.parserFactory, addr, r.reliable)
 				helper.null = false
 			}
 			helper.Unlock()
 		}
 
 		if !helper.sendRead(data, n) {
-			LogError("drop msg because msgque full msgqueid:%v", helper.id)
+			r.app.LogError("drop msg because msgque full msgqueid:%v", helper.id)
 		}
 	}
 }
 
 func (r *udpMsgQue) listen() {
 	for i := 0; i < Config.UdpServerGoCnt; i++ {
-		Go(func() {
+		r.app.Go(func() {
 			r.listenTrue()
 		})
 	}
 	c := make(chan struct{})
-	Go2(func(cstop chan struct{}) {
+	r.app.Go2(func(cstop chan struct{}) {
 		select {
 		case <-cstop:
 		case <-c:
@@ -240,63 +300,236 @@ func (r *udpMsgQue) listen() {
 	r.Stop()
 }
 
-func newUdpAccept(conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr *net.UDPAddr) *udpMsgQue {
+func (r *udpMsgQue) readConn() {
+	cread := r.cread
+	defer func() {
+		if err := recover(); err != nil {
+			r.app.LogError("msgque read conn panic id:%v err:%v", r.id, err.(error))
+			LogStack()
+		}
+		close(cread)
+		r.Stop()
+		r.wait.Done()
+	}()
+	data := make([]byte, 1<<16)
+	for !r.IsStop() {
+		n, err := r.conn.Read(data)
+		if err != nil {
+			if !r.IsStop() {
+				r.app.LogDebug("msgque:%v recv data err:%v", r.id, err)
+			}
+			break
+		}
+		if n <= 0 {
+			continue
+		}
+		if len(cread) < cap(cread) {
+			pdata := make([]byte, n)
+			copy(pdata, data)
+			cread <- pdata
+		} else {
+			r.app.LogError("drop msg because msgque full msgqueid:%v", r.id)
+		}
+	}
+}
+
+// udp没有握手，DialUDP只检查地址，不确认对端存在，所以OnConnectComplete总是成功
+// 对端没有监听时发送后会收到端口不可达，读出错关闭消息队列，也可以通过心跳或者第一个回复确认对端
+func (r *udpMsgQue) connect() {
+	if r.app.IsDraining() {
+		if atomic.CompareAndSwapInt32(&r.connecting, 1, 0) {
+			r.Stop()
+		}
+		return
+	}
+	r.app.LogDebug("connect to addr:%s msgque:%d", r.address, r.id)
+	naddr, err := net.ResolveUDPAddr("udp", r.address)
+	var c *net.UDPConn
+	if err == nil {
+		c, err = net.DialUDP("udp", nil, naddr)
+	}
+	if err != nil {
+		r.app.LogError("connect to addr:%s failed msgque:%d err:%v", r.address, r.id, err)
+		r.handler.OnConnectComplete(r, false)
+		atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
+		r.Stop()
+	} else if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+		c.Close()
+	} else {
+		r.conn = c
+		r.addr = naddr
+		r.cread = make(chan []byte, 64)
+		atomic.StoreInt64(&r.lastTick, timestamp())
+		if r.reliable {
+			r.rudp = newRudpCB(r.writeTo)
+		}
+		r.setAvailable(true)
+		r.restartHeartbeat()
+		r.app.LogDebug("connect to addr:%s ok msgque:%d", r.address, r.id)
+		if r.handler.OnConnectComplete(r, true) {
+			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
+			r.wait.Add(3)
+			r.app.Go(func() {
+				r.app.LogInfo("process read for msgque:%d", r.id)
+				r.read()
+				r.app.LogInfo("process read end for msgque:%d", r.id)
+			})
+			r.app.Go(func() {
+				r.app.LogInfo("process write for msgque:%d", r.id)
+				r.write()
+				r.app.LogInfo("process write end for msgque:%d", r.id)
+			})
+			r.app.Go(func() {
+				r.app.LogInfo("process read conn for msgque:%d", r.id)
+				r.readConn()
+				r.app.LogInfo("process read conn end for msgque:%d", r.id)
+			})
+		} else {
+			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
+			c.Close()
+			r.Stop()
+		}
+	}
+}
+
+func (r *udpMsgQue) Reconnect(t int) {
+	if r.connTyp != ConnTypeConn {
+		return
+	}
+	if r.app.IsStop() || r.app.IsDraining() {
+		return
+	}
+	if r.conn != nil {
+		if atomic.LoadInt32(&r.stop) == 0 {
+			return
+		}
+	}
+
+	if !atomic.CompareAndSwapInt32(&r.connecting, 0, 1) {
+		return
+	}
+
+	if r.init {
+		if t < 1 {
+			t = 1
+		}
+	}
+	r.init = true
+	r.app.Go(func() {
+		if len(r.cwrite) == 0 {
+			r.cwrite <- nil
+		}
+		r.wait.Wait()
+		if t > 0 {
+			r.app.SetTimeout(t*1000, func(arg ...interface{}) int {
+				if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+					return 0
+				}
+				atomic.StoreInt32(&r.stop, 0)
+				r.connect()
+				return 0
+			})
+		} else {
+			atomic.StoreInt32(&r.stop, 0)
+			r.connect()
+		}
+	})
+}
+
+func newUdpConn(app *App, addr string, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, user interface{}, reliable bool) *udpMsgQue {
+	msgque := udpMsgQue{
+		msgQue: msgQue{
+			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
+			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
+			msgTyp:        msgtyp,
+			handler:       handler,
+			timeout:       DefMsgQueTimeout,
+			connTyp:       ConnTypeConn,
+			gmsgId:        app.getGMsgId(),
+			parserFactory: parser,
+			lastTick:      timestamp(),
+			user:          user,
+		},
+		address:  addr,
+		reliable: reliable,
+	}
+	if parser != nil {
+		msgque.parser = parser.Get()
+	}
+	msgque.netType = msgque.GetNetType()
+	initHeartbeat(&msgque)
+	app.addMsgQue(&msgque)
+	app.LogDebug("new msgque id:%d connect to addr:udp:%s", msgque.id, addr)
+	return &msgque
+}
+
+func newUdpAccept(app *App, conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr *net.UDPAddr, reliable bool) *udpMsgQue {
 	msgque := udpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
 			msgTyp:        msgtyp,
 			handler:       handler,
-			available:     true,
+			available:     1,
 			timeout:       DefMsgQueTimeout,
 			connTyp:       ConnTypeAccept,
-			gmsgId:        gmsgId,
+			gmsgId:        app.getGMsgId(),
 			parserFactory: parser,
-			lastTick:      Timestamp,
+			lastTick:      timestamp(),
 		},
-		conn:  conn,
-		cread: make(chan []byte, 64),
-		addr:  addr,
+		conn:     conn,
+		cread:    make(chan []byte, 64),
+		addr:     addr,
+		reliable: reliable,
 	}
 	if parser != nil {
 		msgque.parser = parser.Get()
 	}
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
+	if reliable {
+		msgque.rudp = newRudpCB(msgque.writeTo)
+	}
+	msgque.netType = msgque.GetNetType()
+	initHeartbeat(&msgque)
+	app.addMsgQue(&msgque)
 
-	Go(func() {
-		LogInfo("process read for msgque:%d", msgque.id)
+	msgque.wait.Add(2)
+	app.Go(func() {
+		app.LogInfo("process read for msgque:%d", msgque.id)
 		msgque.read()
-		LogInfo("process read end for msgque:%d", msgque.id)
+		app.LogInfo("process read end for msgque:%d", msgque.id)
 	})
-	Go(func() {
-		LogInfo("process write for msgque:%d", msgque.id)
+	app.Go(func() {
+		app.LogInfo("process write for msgque:%d", msgque.id)
 		msgque.write()
-		LogInfo("process write end for msgque:%d", msgque.id)
+		app.LogInfo("process write end for msgque:%d", msgque.id)
 	})
 
-	LogInfo("new msgque id:%d from addr:%s", msgque.id, addr.String())
+	app.LogInfo("new msgque id:%d from addr:%s", msgque.id, addr.String())
 	return &msgque
 }
 
-func newUdpListen(conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr string) *udpMsgQue {
+func newUdpListen(app *App, conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, addr string, reliable bool) *udpMsgQue {
 	msgque := udpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
-			available:     true,
+			available:     1,
 			parserFactory: parser,
 			connTyp:       ConnTypeListen,
 		},
-		conn: conn,
+		conn:     conn,
+		reliable: reliable,
 	}
 	conn.SetReadBuffer(1 << 24)
 	conn.SetWriteBuffer(1 << 24)
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new udp listen id:%d addr:%s", msgque.id, addr)
+	msgque.netType = msgque.GetNetType()
+	app.addMsgQue(&msgque)
+	app.LogInfo("new udp listen id:%d addr:%s reliable:%v", msgque.id, addr, reliable)
 	return &msgque
 }
@@ -0,0 +1,74 @@
+package antnet
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type udpTestClient struct {
+	callTestClient
+	cdel chan struct{}
+}
+
+func (r *udpTestClient) OnDelMsgQue(msgque IMsgQue) {
+	r.cdel <- struct{}{}
+	msgque.Reconnect(1)
+}
+
+func udpTestCall(t *testing.T, msgque IMsgQue) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var resp []byte
+	if err := msgque.Call(ctx, 1, 1, []byte("udp"), &resp); err != nil || string(resp) != "udp" {
+		t.Fatalf("udp call resp:%q err:%v", resp, err)
+	}
+}
+
+func udpTestWait(t *testing.T, c chan bool, want bool) {
+	select {
+	case ok := <-c:
+		if ok != want {
+			t.Fatalf("connect complete %v want %v", ok, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait connect complete timeout")
+	}
+}
+
+func Test_UdpConnect(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	msgque := callTestConnect(t, app, "udp", callTestServer(t, app, "udp"))
+	udpTestCall(t, msgque)
+}
+
+func Test_UdpReconnect(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	app := NewApp(nil)
+	defer app.Stop()
+	//udp没有握手，对端没有监听时连接也是成功的
+	handler := &udpTestClient{callTestClient: callTestClient{cconn: make(chan bool, 1)}, cdel: make(chan struct{}, 1)}
+	msgque := app.StartConnect("udp", addr, MsgTypeMsg, handler, nil, nil)
+	udpTestWait(t, handler.cconn, true)
+
+	//发送后收到端口不可达，读出错关闭消息队列，在OnDelMsgQue中重连
+	msgque.Send(NewMsg(1, 1, 0, 0, nil))
+	select {
+	case <-handler.cdel:
+	case <-time.After(3 * time.Second):
+		t.Fatal("msgque without peer should be closed")
+	}
+	if err := app.StartServer("udp://"+addr, MsgTypeMsg, callTestHandler(), nil); err != nil {
+		t.Fatal(err)
+	}
+	udpTestWait(t, handler.cconn, true)
+	udpTestCall(t, msgque)
+}
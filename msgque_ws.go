@@ -1,7 +1,10 @@
 package antnet
 
 import (
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,13 +14,71 @@ import (
 
 type wsMsgQue struct {
 	msgQue
-	conn     *websocket.Conn
-	upgrader *websocket.Upgrader
-	addr     string
-	url      string
+	conn       *websocket.Conn
+	upgrader   *websocket.Upgrader
+	addr       string
+	url        string
 	wait       sync.WaitGroup
 	connecting int32
-	listener *http.Server
+	listener   *http.Server
+	conf       *WsConfig
+	secure     bool
+	subproto   string
+}
+
+// websocket监听的配置
+type WsConfig struct {
+	Origins           []string       //允许的Origin，为空不检查，可以是完整的Origin，也可以是域名，支持*.example.com通配
+	Subprotocols      []string       //支持的子协议，按顺序选择第一个客户端也支持的
+	EnableCompression bool           //开启permessage-deflate压缩
+	CompressionLevel  int            //压缩等级，0使用默认等级
+	MaxMessageSize    int64          //最大消息长度，0表示MaxMsgDataSize加消息头长度
+	ReadBufferSize    int            //读缓存大小
+	WriteBufferSize   int            //写缓存大小
+	HandshakeTimeout  int            //握手超时，单位毫秒
+	PingInterval      int            //发送ping的间隔，单位毫秒，0表示使用Config.HeartbeatInterval
+	PingMiss          int            //连续多少次没有收到pong断开连接，0表示使用Config.HeartbeatMiss
+	ServeMux          *http.ServeMux //挂到调用者的ServeMux上，不会监听端口，由调用者启动http服务
+	Server            *http.Server   //使用调用者的Server监听，可以设置TLSConfig和各种超时，Handler必须为nil或者*http.ServeMux
+}
+
+var DefWsConfig = WsConfig{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+func (r *WsConfig) checkOrigin(hr *http.Request) bool {
+	if len(r.Origins) == 0 {
+		return true
+	}
+	origin := hr.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, v := range r.Origins {
+		v = strings.ToLower(v)
+		if v == "*" || v == strings.ToLower(origin) || v == host {
+			return true
+		}
+		if strings.HasPrefix(v, "*.") && strings.HasSuffix(host, v[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *WsConfig) maxMessageSize() int64 {
+	if r.MaxMessageSize > 0 {
+		return r.MaxMessageSize
+	}
+	return int64(MaxMsgDataSize) + MsgHeadSize
+}
+
+// 协商后的子协议
+func (r *wsMsgQue) GetSubprotocol() string {
+	return r.subproto
 }
 
 func (r *wsMsgQue) GetNetType() NetType {
@@ -26,23 +87,38 @@ func (r *wsMsgQue) GetNetType() NetType {
 
 func (r *wsMsgQue) Stop() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
-		Go(func() {
+		r.app.Go(func() {
 			if r.init {
 				r.handler.OnDelMsgQue(r)
+				if atomic.LoadInt32(&r.connecting) == 1 && !r.cancelConnect(&r.connecting) {
+					r.setAvailable(false)
+					return
+				}
 			}
-			r.available = false
+			r.setAvailable(false)
 			r.baseStop()
 		})
+	} else if r.cancelConnect(&r.connecting) {
+		r.app.Go(func() {
+			r.setAvailable(false)
+			r.baseStop()
+		})
+	}
+}
+
+func (r *wsMsgQue) closeListen() {
+	if r.listener != nil {
+		r.listener.Close()
 	}
 }
 
 func (r *wsMsgQue) IsStop() bool {
-	if r.stop == 0 {
-		if IsStop() {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
 }
 
 func (r *wsMsgQue) LocalAddr() string {
@@ -66,16 +142,85 @@ func (r *wsMsgQue) readCmd() {
 	for !r.IsStop() {
 		_, data, err := r.conn.ReadMessage()
 		if err != nil {
-			LogError("msgque:%v recv data err:%v", r.id, err)
+			r.app.LogError("msgque:%v recv data err:%v", r.id, err)
 			break
 		}
 		if !r.processMsg(r, &Message{Data: data}) {
 			break
 		}
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
 }
 
+func (r *wsMsgQue) readMsg() {
+	for !r.IsStop() {
+		_, data, err := r.conn.ReadMessage()
+		if err != nil {
+			r.app.LogError("msgque:%v recv data err:%v", r.id, err)
+			break
+		}
+		head := NewMessageHead(data)
+		if head == nil {
+			r.app.LogError("msgque:%v read msg head failed", r.id)
+			break
+		}
+		if int(head.Len) != len(data)-MsgHeadSize {
+			r.app.LogError("msgque:%v read msg len not match head:%v data:%v", r.id, head.Len, len(data)-MsgHeadSize)
+			break
+		}
+		msg := &Message{Head: head}
+		if head.Len > 0 {
+			msg.Data = data[MsgHeadSize:]
+		}
+		if !r.processMsg(r, msg) {
+			r.app.LogError("msgque:%v process msg cmd:%v act:%v", r.id, head.Cmd, head.Act)
+			break
+		}
+		atomic.StoreInt64(&r.lastTick, timestamp())
+	}
+}
+
+func (r *wsMsgQue) writeMsg() {
+	var m *Message
+	gm := r.getGMsg(false)
+	tick := time.NewTimer(time.Second * time.Duration(r.timeout))
+	for !r.IsStop() || m != nil {
+		if m == nil {
+			select {
+			case <-r.app.stopChanForGo:
+			case <-r.cstop:
+			case m = <-r.cwrite:
+			case <-gm.c:
+				if gm.fun == nil || gm.fun(r) {
+					m = r.encryptGMsg(gm.msg)
+				}
+				r.addWriting(m)
+				gm = r.getGMsg(true)
+			case <-tick.C:
+				if r.isTimeout(tick) {
+					r.Stop()
+				}
+			}
+		}
+
+		if m == nil || (m.Head == nil && m.Data == nil) {
+			r.doneWriting(m)
+			m = nil
+			continue
+		}
+		err := r.conn.WriteMessage(websocket.BinaryMessage, m.Bytes())
+		if err != nil {
+			r.app.LogError("msgque write id:%v err:%v", r.id, err)
+			break
+		}
+		r.doneWriting(m)
+		m = nil
+		atomic.StoreInt64(&r.lastTick, timestamp())
+	}
+	r.doneWriting(m)
+	tick.Stop()
+}
+
 func (r *wsMsgQue) writeCmd() {
 	var m *Message
 	gm := r.getGMsg(false)
@@ -83,12 +228,14 @@ func (r *wsMsgQue) writeCmd() {
 	for !r.IsStop() || m != nil {
 		if m == nil {
 			select {
-			case <-stopChanForGo:
+			case <-r.app.stopChanForGo:
+			case <-r.cstop:
 			case m = <-r.cwrite:
 			case <-gm.c:
 				if gm.fun == nil || gm.fun(r) {
 					m = gm.msg
 				}
+				r.addWriting(m)
 				gm = r.getGMsg(true)
 			case <-tick.C:
 				if r.isTimeout(tick) {
@@ -98,126 +245,201 @@ func (r *wsMsgQue) writeCmd() {
 		}
 
 		if m == nil || m.Data == nil {
+			r.doneWriting(m)
 			m = nil
 			continue
 		}
 		err := r.conn.WriteMessage(websocket.BinaryMessage, m.Data)
 		if err != nil {
-			LogError("msgque write id:%v err:%v", r.id, err)
+			r.app.LogError("msgque write id:%v err:%v", r.id, err)
 			break
 		}
+		r.doneWriting(m)
 		m = nil
-		r.lastTick = Timestamp
+		atomic.StoreInt64(&r.lastTick, timestamp())
 	}
+	r.doneWriting(m)
 	tick.Stop()
 }
 
 func (r *wsMsgQue) read() {
 	defer func() {
 		if err := recover(); err != nil {
-			LogError("msgque read panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque read panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
 		r.Stop()
+		r.wait.Done()
 	}()
 
-	r.readCmd()
+	if r.msgTyp == MsgTypeCmd {
+		r.readCmd()
+	} else {
+		r.readMsg()
+	}
 }
 
 func (r *wsMsgQue) write() {
 	defer func() {
 		if err := recover(); err != nil {
-			LogError("msgque write panic id:%v err:%v", r.id, err.(error))
+			r.app.LogError("msgque write panic id:%v err:%v", r.id, err.(error))
 			LogStack()
 		}
 		if r.conn != nil {
 			r.conn.Close()
 		}
 		r.Stop()
+		r.wait.Done() //重连等待这里，之后才能替换conn
 	}()
 
-	r.writeCmd()
+	if r.msgTyp == MsgTypeCmd {
+		r.writeCmd()
+	} else {
+		r.writeMsg()
+	}
 }
 
-func (r *wsMsgQue) listen() {
-	Go2(func(cstop chan struct{}) {
-		select {
-		case <-cstop:
-		}
-		r.listener.Close()
-	})
-
+// 注册到ServeMux，同一个ServeMux上路径不能重复
+func (r *wsMsgQue) handle(mux *http.ServeMux) (err error) {
 	r.upgrader = &websocket.Upgrader{
-		ReadBufferSize:  4096,
-		WriteBufferSize: 4096,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		ReadBufferSize:    r.conf.ReadBufferSize,
+		WriteBufferSize:   r.conf.WriteBufferSize,
+		HandshakeTimeout:  time.Millisecond * time.Duration(r.conf.HandshakeTimeout),
+		Subprotocols:      r.conf.Subprotocols,
+		EnableCompression: r.conf.EnableCompression,
+		CheckOrigin:       r.conf.checkOrigin,
 	}
+	defer func() {
+		if e := recover(); e != nil {
+			r.app.LogError("ws listen register url:%v failed msgque:%v err:%v", r.url, r.id, e)
+			err = ErrWsPathConflict
+		}
+	}()
+	mux.HandleFunc(r.url, r.serveHTTP)
+	return nil
+}
 
-	http.HandleFunc(r.url, func(hw http.ResponseWriter, hr *http.Request) {
-		c, err := r.upgrader.Upgrade(hw, hr, nil)
-		if err != nil {
-			if stop == 0 && r.stop == 0 {
-				LogError("accept failed msgque:%v err:%v", r.id, err)
-			}
-		} else {
-			Go(func() {
-				msgque := newWsAccept(c, r.msgTyp, r.handler, r.parserFactory)
-				if r.handler.OnNewMsgQue(msgque) {
-					msgque.init = true
-					msgque.available = true
-					Go(func() {
-						LogInfo("process read for msgque:%d", msgque.id)
-						msgque.read()
-						LogInfo("process read end for msgque:%d", msgque.id)
-					})
-					Go(func() {
-						LogInfo("process write for msgque:%d", msgque.id)
-						msgque.write()
-						LogInfo("process write end for msgque:%d", msgque.id)
-					})
-				} else {
-					msgque.Stop()
-				}
+func (r *wsMsgQue) serveHTTP(hw http.ResponseWriter, hr *http.Request) {
+	if atomic.LoadInt32(&r.stop) == 1 || r.app.IsDraining() {
+		http.Error(hw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	c, err := r.upgrader.Upgrade(hw, hr, nil)
+	if err != nil {
+		if atomic.LoadInt32(&r.app.stop) == 0 && atomic.LoadInt32(&r.stop) == 0 {
+			r.app.LogError("accept failed msgque:%v err:%v", r.id, err)
+		}
+		return
+	}
+	realAddr := ""
+	if Config.ProxyProtocol {
+		realAddr = hr.RemoteAddr
+	}
+	if Config.TrustForwarded {
+		//多个X-Forwarded-For头按顺序合并，代理总是追加到最后
+		header := func(key string) string { return strings.Join(hr.Header.Values(key), ",") }
+		if addr := forwardedAddr(header, Config.ForwardedHops); addr != "" {
+			realAddr = addr
+		}
+	}
+	r.app.Go(func() {
+		msgque := newWsAccept(r.app, c, r.conf, r.msgTyp, r.handler, r.parserFactory)
+		msgque.realRemoteAddr = realAddr
+		if r.handler.OnNewMsgQue(msgque) {
+			msgque.init = true
+			msgque.setAvailable(true)
+			msgque.wait.Add(2)
+			r.app.Go(func() {
+				r.app.LogInfo("process read for msgque:%d", msgque.id)
+				msgque.read()
+				r.app.LogInfo("process read end for msgque:%d", msgque.id)
+			})
+			r.app.Go(func() {
+				r.app.LogInfo("process write for msgque:%d", msgque.id)
+				msgque.write()
+				r.app.LogInfo("process write end for msgque:%d", msgque.id)
 			})
+		} else {
+			msgque.Stop()
+		}
+	})
+}
+
+func (r *wsMsgQue) listen(listen net.Listener) {
+	c := make(chan struct{})
+	r.app.Go2(func(cstop chan struct{}) {
+		select {
+		case <-cstop:
+		case <-c:
 		}
+		r.listener.Close()
 	})
 
-	if Config.EnableWss {
-		if Config.SSLCrtPath != "" && Config.SSLKeyPath != "" {
-			r.listener.ListenAndServeTLS(Config.SSLCrtPath, Config.SSLKeyPath)
+	if r.secure {
+		if (Config.SSLCrtPath != "" && Config.SSLKeyPath != "") || r.listener.TLSConfig != nil {
+			r.listener.ServeTLS(listen, Config.SSLCrtPath, Config.SSLKeyPath)
 		} else {
-			LogError("start wss failed ssl path not set please set now auto change to ws")
-			r.listener.ListenAndServe()
+			r.app.LogError("start wss failed ssl path not set please set now auto change to ws")
+			r.listener.Serve(listen)
 		}
 	} else {
-		r.listener.ListenAndServe()
+		r.listener.Serve(listen)
 	}
+	close(c)
+	r.Stop()
 }
+
+// 设置读取限制，压缩和ping pong处理
+func (r *wsMsgQue) initConn() {
+	r.conn.SetPingHandler(r.onPing)
+	r.conn.SetPongHandler(r.onPong)
+	if r.conf == nil {
+		return
+	}
+	r.subproto = r.conn.Subprotocol()
+	r.conn.SetReadLimit(r.conf.maxMessageSize())
+	if r.conf.EnableCompression {
+		r.conn.EnableWriteCompression(true)
+		if r.conf.CompressionLevel != 0 {
+			r.conn.SetCompressionLevel(r.conf.CompressionLevel)
+		}
+	}
+}
+
 func (r *wsMsgQue) connect() {
-	LogInfo("connect to addr:%s msgque:%d",r.addr, r.id)
+	if r.app.IsDraining() {
+		if atomic.CompareAndSwapInt32(&r.connecting, 1, 0) {
+			r.Stop()
+		}
+		return
+	}
+	r.app.LogInfo("connect to addr:%s msgque:%d", r.addr, r.id)
 	c, _, err := websocket.DefaultDialer.Dial(r.addr, nil)
 	if err != nil {
-		LogInfo("connect to addr:%s failed msgque:%d err:%v ", r.addr, r.id, err)
+		r.app.LogInfo("connect to addr:%s failed msgque:%d err:%v ", r.addr, r.id, err)
 		r.handler.OnConnectComplete(r, false)
 		atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
 		r.Stop()
+	} else if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+		c.Close()
 	} else {
 		r.conn = c
-		r.available = true
-		LogInfo("connect to addr:%s ok msgque:%d", r.addr, r.id)
+		r.initConn()
+		r.setAvailable(true)
+		r.restartHeartbeat()
+		r.app.LogInfo("connect to addr:%s ok msgque:%d", r.addr, r.id)
 		if r.handler.OnConnectComplete(r, true) {
 			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
-			Go(func() {
-				LogInfo("process read for msgque:%d", r.id)
+			r.wait.Add(2) //在协程外增加计数，Reconnect等待时不会漏掉
+			r.app.Go(func() {
+				r.app.LogInfo("process read for msgque:%d", r.id)
 				r.read()
-				LogInfo("process read end for msgque:%d", r.id)
+				r.app.LogInfo("process read end for msgque:%d", r.id)
 			})
-			Go(func() {
-				LogInfo("process write for msgque:%d", r.id)
+			r.app.Go(func() {
+				r.app.LogInfo("process write for msgque:%d", r.id)
 				r.write()
-				LogInfo("process write end for msgque:%d", r.id)
+				r.app.LogInfo("process write end for msgque:%d", r.id)
 			})
 		} else {
 			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
@@ -226,13 +448,12 @@ func (r *wsMsgQue) connect() {
 	}
 }
 
-
 func (r *wsMsgQue) Reconnect(t int) {
-	if IsStop() {
+	if r.app.IsStop() || r.app.IsDraining() {
 		return
 	}
 	if r.conn != nil {
-		if r.stop == 0 {
+		if atomic.LoadInt32(&r.stop) == 0 {
 			return
 		}
 	}
@@ -247,81 +468,99 @@ func (r *wsMsgQue) Reconnect(t int) {
 		}
 	}
 	r.init = true
-	Go(func() {
+	r.app.Go(func() {
 		if len(r.cwrite) == 0 {
 			r.cwrite <- nil
 		}
 		r.wait.Wait()
 		if t > 0 {
-			SetTimeout(t*1000, func(arg ...interface{}) int {
-				r.stop = 0
+			r.app.SetTimeout(t*1000, func(arg ...interface{}) int {
+				if atomic.LoadInt32(&r.connecting) == 0 { //停止服务时已经取消
+					return 0
+				}
+				atomic.StoreInt32(&r.stop, 0)
 				r.connect()
 				return 0
 			})
 		} else {
-			r.stop = 0
+			atomic.StoreInt32(&r.stop, 0)
 			r.connect()
 		}
 
 	})
 }
 
-func newWsConn(addr string, conn *websocket.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) *wsMsgQue {
+func newWsConn(app *App, addr string, conn *websocket.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory, user interface{}) *wsMsgQue {
 	msgque := wsMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
 			msgTyp:        msgtyp,
 			handler:       handler,
 			timeout:       DefMsgQueTimeout,
 			connTyp:       ConnTypeConn,
-			gmsgId:        gmsgId,
+			gmsgId:        app.getGMsgId(),
 			parserFactory: parser,
-			lastTick:      Timestamp,
+			lastTick:      timestamp(),
 			user:          user,
 		},
-		conn:    conn,
+		conn: conn,
 		addr: addr,
 	}
 	if parser != nil {
 		msgque.parser = parser.Get()
 	}
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new msgque id:%d connect to addr:%s", msgque.id, addr)
+	msgque.netType = msgque.GetNetType()
+	initHeartbeat(&msgque)
+	app.addMsgQue(&msgque)
+	app.LogInfo("new msgque id:%d connect to addr:%s", msgque.id, addr)
 	return &msgque
 }
 
-func newWsAccept(conn *websocket.Conn, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *wsMsgQue {
+func newWsAccept(app *App, conn *websocket.Conn, conf *WsConfig, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *wsMsgQue {
 	msgque := wsMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			cwrite:        make(chan *Message, 64),
+			cstop:         make(chan struct{}),
 			msgTyp:        msgtyp,
 			handler:       handler,
 			timeout:       DefMsgQueTimeout,
 			connTyp:       ConnTypeAccept,
-			gmsgId:        gmsgId,
-			lastTick:      Timestamp,
+			gmsgId:        app.getGMsgId(),
+			lastTick:      timestamp(),
 			parserFactory: parser,
 		},
 		conn: conn,
+		conf: conf,
 	}
 	if parser != nil {
 		msgque.parser = parser.Get()
 	}
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new msgque id:%d from addr:%s", msgque.id, conn.RemoteAddr().String())
+	msgque.initConn()
+	msgque.netType = msgque.GetNetType()
+	if conf != nil && conf.PingInterval > 0 {
+		miss := conf.PingMiss
+		if miss <= 0 {
+			miss = Config.HeartbeatMiss
+		}
+		msgque.SetHeartbeat(conf.PingInterval, miss)
+	} else {
+		initHeartbeat(&msgque)
+	}
+	app.addMsgQue(&msgque)
+	app.LogInfo("new msgque id:%d from addr:%s", msgque.id, conn.RemoteAddr().String())
 	return &msgque
 }
 
-func newWsListen(addr, url string, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *wsMsgQue {
+func newWsListen(app *App, addr, url string, conf *WsConfig, server *http.Server, msgtyp MsgType, handler IMsgHandler, parser IParserFactory) *wsMsgQue {
 	msgque := wsMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgqueId, 1),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
 			parserFactory: parser,
@@ -329,12 +568,12 @@ func newWsListen(addr, url string, msgtyp MsgType, handler IMsgHandler, parser I
 		},
 		addr:     addr,
 		url:      url,
-		listener: &http.Server{Addr: addr},
+		listener: server,
+		conf:     conf,
 	}
 
-	msgqueMapSync.Lock()
-	msgqueMap[msgque.id] = &msgque
-	msgqueMapSync.Unlock()
-	LogInfo("new ws listen id:%d addr:%s url:%s", msgque.id, addr, url)
+	msgque.netType = msgque.GetNetType()
+	app.addMsgQue(&msgque)
+	app.LogInfo("new ws listen id:%d addr:%s url:%s", msgque.id, addr, url)
 	return &msgque
 }
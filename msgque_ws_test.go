@@ -0,0 +1,191 @@
+package antnet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func Test_WsCheckOrigin(t *testing.T) {
+	conf := &WsConfig{Origins: []string{"*.example.com", "https://game.test", "Local.Host"}}
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://h5.example.com", true},
+		{"http://a.b.example.com:8080", true},
+		{"https://example.com", false},
+		{"https://evilexample.com", false},
+		{"https://example.com.evil.com", false},
+		{"https://game.test", true},
+		{"http://game.test", false},
+		{"http://localhost", false},
+		{"http://local.host:80", true},
+		{"", false},
+		{"://bad", false},
+	}
+	for _, c := range cases {
+		hr := httptest.NewRequest("GET", "/ws", nil)
+		if c.origin != "" {
+			hr.Header.Set("Origin", c.origin)
+		}
+		if got := conf.checkOrigin(hr); got != c.want {
+			t.Fatalf("origin:%q got %v want %v", c.origin, got, c.want)
+		}
+	}
+	if !(&WsConfig{}).checkOrigin(httptest.NewRequest("GET", "/ws", nil)) {
+		t.Fatal("empty origins should allow all")
+	}
+	any := &WsConfig{Origins: []string{"*"}}
+	if any.checkOrigin(httptest.NewRequest("GET", "/ws", nil)) {
+		t.Fatal("wildcard origin without header should be rejected")
+	}
+	hr := httptest.NewRequest("GET", "/ws", nil)
+	hr.Header.Set("Origin", "https://any.host")
+	if !any.checkOrigin(hr) {
+		t.Fatal("wildcard origin should allow any host")
+	}
+}
+
+func Test_WsPathConflict(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	h := &EchoMsgHandler{}
+	mux := http.NewServeMux()
+	if err := app.StartWsServer("ws://:0/game", &WsConfig{ServeMux: mux}, MsgTypeMsg, h, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.StartWsServer("ws://:0/game", &WsConfig{ServeMux: mux}, MsgTypeMsg, h, nil); err != ErrWsPathConflict {
+		t.Fatalf("same path on one mux err:%v", err)
+	}
+	if err := app.StartWsServer("ws://:0/chat", &WsConfig{ServeMux: mux}, MsgTypeMsg, h, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.NotFoundHandler()}
+	if err := app.StartWsServer("ws://127.0.0.1:0/game", &WsConfig{Server: server}, MsgTypeMsg, h, nil); err != ErrWsPathConflict {
+		t.Fatalf("server with non mux handler err:%v", err)
+	}
+}
+
+type wsTestHandler struct {
+	EchoMsgHandler
+	csub chan string
+}
+
+func (r *wsTestHandler) OnNewMsgQue(msgque IMsgQue) bool {
+	r.csub <- msgque.GetSubprotocol()
+	return true
+}
+
+func Test_WsSubprotocol(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	h := &wsTestHandler{csub: make(chan string, 1)}
+	addr := wsTestServer(t, app, &WsConfig{Subprotocols: []string{"v2", "v1"}}, h)
+	cases := []struct {
+		client []string
+		want   string
+	}{
+		{[]string{"v1", "v2"}, "v2"},
+		{[]string{"v1"}, "v1"},
+		{[]string{"v3"}, ""},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		dialer := &websocket.Dialer{Subprotocols: c.client, HandshakeTimeout: time.Second}
+		conn, resp, err := dialer.Dial(addr, nil)
+		if err != nil {
+			t.Fatal(c.client, err)
+		}
+		if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != c.want {
+			t.Fatalf("client:%v negotiated %q want %q", c.client, got, c.want)
+		}
+		select {
+		case got := <-h.csub:
+			if got != c.want {
+				t.Fatalf("client:%v server subprotocol %q want %q", c.client, got, c.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("wait new msgque timeout")
+		}
+		conn.Close()
+	}
+}
+
+// 在ServeMux上监听，由httptest的服务器提供随机端口，返回连接地址
+func wsTestServer(t *testing.T, app *App, conf *WsConfig, handler IMsgHandler) string {
+	mux := http.NewServeMux()
+	conf.ServeMux = mux
+	if err := app.StartWsServer("ws://:0/ws", conf, MsgTypeMsg, handler, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return "ws://" + server.Listener.Addr().String() + "/ws"
+}
+
+func Test_WsMsgRoundTrip(t *testing.T) {
+	app := NewApp(nil)
+	defer app.Stop()
+	msgque := callTestConnect(t, app, "ws", wsTestServer(t, app, &WsConfig{}, callTestHandler()))
+
+	//带消息头的消息在ws帧中完整往返，空消息和大消息都可以
+	for _, data := range [][]byte{nil, []byte("hello"), bytes.Repeat([]byte("ws"), 32*1024)} {
+		var resp []byte
+		if err := msgque.Call(context.Background(), 1, 1, data, &resp); err != nil || !bytes.Equal(resp, data) {
+			t.Fatalf("call len:%v resp len:%v err:%v", len(data), len(resp), err)
+		}
+	}
+	if err := msgque.Call(context.Background(), 1, 2, nil, nil); err != ErrServiceNotFound {
+		t.Fatalf("error reply err:%v", err)
+	}
+
+	//同一个index不同cmd act的回复按tag匹配到各自的回调
+	c1, c2 := make(chan *Message, 1), make(chan *Message, 1)
+	msgque.SendCallback(NewMsg(1, 2, 5, 0, nil), c2)
+	msgque.SendCallback(NewMsg(1, 1, 5, 0, []byte("tag")), c1)
+	for _, c := range []chan *Message{c1, c2} {
+		select {
+		case m := <-c:
+			if m.Act() == 1 && (m.Index() != 5 || string(m.Data) != "tag") || m.Act() == 2 && m.Head.Error != ErrServiceNotFound.Id {
+				t.Fatalf("bad tag reply %v data:%q", m.Head, m.Data)
+			}
+			if (c == c1) != (m.Act() == 1) {
+				t.Fatalf("reply act:%v matched wrong callback", m.Act())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("wait tag reply timeout")
+		}
+	}
+
+	//并发调用各自收到自己的回复
+	var wg sync.WaitGroup
+	cerr := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				req := []byte(strconv.Itoa(i*100 + j))
+				var resp []byte
+				if err := msgque.Call(context.Background(), 1, 1, req, &resp); err != nil || !bytes.Equal(resp, req) {
+					cerr <- fmt.Errorf("call %s resp:%q err:%v", req, resp, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(cerr)
+	for err := range cerr {
+		t.Fatal(err)
+	}
+}
@@ -81,6 +81,11 @@ type IParser interface {
 	GetRemindMsg(err error, t MsgType) *Message
 }
 
+// 可选接口，解析器实现后可以被Call用来解析返回消息
+type IMsgUnPacker interface {
+	UnPackMsg(data []byte, v interface{}) error
+}
+
 type IParserFactory interface {
 	Get() IParser
 }
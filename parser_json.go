@@ -46,6 +46,10 @@ func (r *JsonParser) PackMsg(v interface{}) []byte {
 	return data
 }
 
+func (r *JsonParser) UnPackMsg(data []byte, v interface{}) error {
+	return JsonUnPack(data, v)
+}
+
 func (r *JsonParser) GetRemindMsg(err error, t MsgType) *Message {
 	if t == MsgTypeMsg {
 		return NewErrMsg(err)
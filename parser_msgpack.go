@@ -48,6 +48,13 @@ func (r *MsgpackParser) PackMsg(v interface{}) []byte {
 	return data
 }
 
+func (r *MsgpackParser) UnPackMsg(data []byte, v interface{}) error {
+	if err := MsgPackUnPack(data, v); err != nil {
+		return ErrMsgPackUnPack
+	}
+	return nil
+}
+
 func (r *MsgpackParser) GetRemindMsg(err error, t MsgType) *Message {
 	if t == MsgTypeMsg {
 		return NewErrMsg(err)
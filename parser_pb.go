@@ -48,6 +48,10 @@ func (r *PBParser) PackMsg(v interface{}) []byte {
 	return data
 }
 
+func (r *PBParser) UnPackMsg(data []byte, v interface{}) error {
+	return PBUnPack(data, v)
+}
+
 func (r *PBParser) GetRemindMsg(err error, t MsgType) *Message {
 	if t == MsgTypeMsg {
 		return NewErrMsg(err)
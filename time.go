@@ -1,6 +1,7 @@
 package antnet
 
 import (
+	"sync/atomic"
 	"time"
 )
 
@@ -52,30 +53,32 @@ func Sleep(ms int) {
 	time.Sleep(time.Millisecond * time.Duration(ms))
 }
 
-func SetTimeout(inteval int, fn func(...interface{}) int, args ...interface{}) {
-	if inteval < 0 {
-		LogError("new timeout inteval:%v ms", inteval)
-		return
-	}
-	LogInfo("new timeout inteval:%v ms", inteval)
-
-	Go2(func(cstop chan struct{}) {
-		tick := time.NewTimer(time.Millisecond * time.Duration(inteval))
-		for inteval > 0 {
-			select {
-			case <-cstop:
-				inteval = 0
-			case <-tick.C:
-				inteval = fn(args...)
-				if inteval > 0 {
-					tick.Reset(time.Millisecond * time.Duration(inteval))
-				}
-			}
-		}
-		tick.Stop()
-	})
+// 定时器，fn返回值大于0表示间隔多少毫秒再次执行，单位毫秒
+func SetTimeout(inteval int, fn func(...interface{}) int, args ...interface{}) *Timer {
+	return newTimeout(inteval, fn, args, nil, nil)
+}
+
+// 每隔inteval毫秒执行一次，直到定时器停止
+func SetInterval(inteval int, fn func(...interface{}), args ...interface{}) *Timer {
+	return newInterval(inteval, fn, args, nil, nil)
+}
+
+// 按cron表达式执行，格式为：分 时 日 月 周
+func SetCron(spec string, fn func(...interface{}), args ...interface{}) (*Timer, error) {
+	return newCron(spec, fn, args, nil, nil)
 }
 
+// 当前毫秒，由定时协程更新，包内通过这个函数读取
+func nowTick() int64 {
+	return atomic.LoadInt64(&NowTick)
+}
+
+// 当前秒数，由定时协程更新，包内通过这个函数读取
+func timestamp() int64 {
+	return atomic.LoadInt64(&Timestamp)
+}
+
+// 时间和时间轮由单独的协程驱动，和进程的生命周期一致，不属于任何实例，停止DefApp不会影响其他实例的定时器
 func timerTick() {
 	now := time.Now()
 	StartTick = now.UnixNano() / 1000000
@@ -83,22 +86,25 @@ func timerTick() {
 	Timestamp = NowTick / 1000
 	TimeString = now.Format("2006-01-02 15:04:05")
 	lastTimestamp := Timestamp
+	timerWheel.Lock()
+	timerWheel.tick = NowTick
+	timerWheel.Unlock()
 	var ticker = time.NewTicker(time.Millisecond)
-	Go(func() {
-		for IsRuning() {
-			select {
-			case <-ticker.C:
-				now := time.Now()
-				NowTick = now.UnixNano() / 1000000
-				Timestamp = NowTick / 1000
-				if Timestamp != lastTimestamp {
-					lastTimestamp = Timestamp
-					TimeString = now.Format("2006-01-02 15:04:05")
-				}
+	go func() {
+		for range ticker.C {
+			now := time.Now()
+			tick := now.UnixNano() / 1000000
+			atomic.StoreInt64(&NowTick, tick)
+			atomic.StoreInt64(&Timestamp, tick/1000)
+			if tick/1000 != lastTimestamp {
+				lastTimestamp = tick / 1000
+				TimeString = now.Format("2006-01-02 15:04:05")
 			}
+			Try(func() {
+				timerWheelTick(tick)
+			}, nil)
 		}
-		ticker.Stop()
-	})
+	}()
 }
 
 /**
@@ -107,7 +113,7 @@ func timerTick() {
 * @return uint32_t 距离下个小时的时间，单位s
  */
 func GetNextHourIntervalS() int {
-	return int(3600 - (Timestamp % 3600))
+	return int(3600 - (timestamp() % 3600))
 }
 
 /**
@@ -224,6 +230,12 @@ func IsDiffWeek(now, old int64, hour, timezone int) bool {
 	return nw != ow && diffHour
 }
 
+// 本地时区，单位小时
+func LocalTimeZone() int {
+	_, offset := time.Now().Zone()
+	return offset / 3600
+}
+
 /* 今天零点
  * timezone 时区
  * return 零点时间
@@ -0,0 +1,459 @@
+package antnet
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 分层时间轮，精度1毫秒，第一层256格，后面4层每层64格
+const (
+	timerRootBits  = 8
+	timerLevelBits = 6
+	timerLevels    = 4
+	timerRootSize  = 1 << timerRootBits
+	timerLevelSize = 1 << timerLevelBits
+	timerRootMask  = timerRootSize - 1
+	timerLevelMask = timerLevelSize - 1
+	timerMaxDelay  = 1<<(timerRootBits+timerLevels*timerLevelBits) - 1
+)
+
+type Timer struct {
+	expire   int64
+	fn       func(...interface{}) int
+	args     []interface{}
+	cron     *cronSchedule
+	actor    *Actor
+	app      *App //不为nil时在实例的协程池中执行
+	owner    *timerOwner
+	stop     int32
+	prev     *Timer
+	next     *Timer
+	wheeling bool
+}
+
+// 停止定时器，返回false表示已经停止
+func (r *Timer) Stop() bool {
+	if !atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
+		return false
+	}
+	timerWheel.Lock()
+	timerWheel.remove(r)
+	timerWheel.Unlock()
+	if r.owner != nil {
+		r.owner.remove(r)
+	}
+	return true
+}
+
+func (r *Timer) IsStop() bool {
+	return atomic.LoadInt32(&r.stop) == 1
+}
+
+// 定时器的下次执行时间，单位毫秒
+func (r *Timer) Expire() int64 {
+	timerWheel.Lock()
+	defer timerWheel.Unlock()
+	return r.expire
+}
+
+func (r *Timer) run() {
+	if r.IsStop() {
+		return
+	}
+	next := r.fn(r.args...)
+	if r.cron != nil {
+		next = 0
+		if t := r.cron.next(time.Now()); !t.IsZero() {
+			next = int(time.Until(t) / time.Millisecond)
+			if next < 1 {
+				next = 1
+			}
+		}
+	}
+	if next > 0 && !r.IsStop() {
+		timerWheel.Lock()
+		if !r.IsStop() {
+			r.expire = timerWheel.now() + int64(next)
+			timerWheel.add(r)
+		}
+		timerWheel.Unlock()
+		return
+	}
+	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) && r.owner != nil {
+		r.owner.remove(r)
+	}
+}
+
+func (r *Timer) fire() {
+	if r.actor != nil {
+		if r.actor.post(r.run, 0, false) != nil {
+			r.Stop()
+		}
+	} else if r.app != nil {
+		r.app.Go(r.run)
+	} else {
+		Go(r.run)
+	}
+}
+
+type timerList struct {
+	head Timer
+}
+
+func newTimerList() *timerList {
+	l := &timerList{}
+	l.head.prev = &l.head
+	l.head.next = &l.head
+	return l
+}
+
+func (r *timerList) push(t *Timer) {
+	t.prev = r.head.prev
+	t.next = &r.head
+	r.head.prev.next = t
+	r.head.prev = t
+	t.wheeling = true
+}
+
+// 取出所有定时器
+func (r *timerList) take() *Timer {
+	if r.head.next == &r.head {
+		return nil
+	}
+	first := r.head.next
+	r.head.prev.next = nil
+	r.head.prev = &r.head
+	r.head.next = &r.head
+	return first
+}
+
+type timerWheelT struct {
+	sync.Mutex
+	tick   int64 //下一个需要处理的毫秒
+	root   [timerRootSize]*timerList
+	levels [timerLevels][timerLevelSize]*timerList
+}
+
+func newTimerWheel(tick int64) *timerWheelT {
+	w := &timerWheelT{tick: tick}
+	for i := range w.root {
+		w.root[i] = newTimerList()
+	}
+	for i := range w.levels {
+		for j := range w.levels[i] {
+			w.levels[i][j] = newTimerList()
+		}
+	}
+	return w
+}
+
+var timerWheel = newTimerWheel(time.Now().UnixNano() / 1000000)
+
+// 最后处理过的毫秒，和NowTick一致，需要在加锁后调用
+func (r *timerWheelT) now() int64 {
+	return r.tick - 1
+}
+
+func (r *timerWheelT) add(t *Timer) {
+	delay := t.expire - r.tick
+	expire := t.expire
+	var l *timerList
+	if delay < 0 {
+		l = r.root[r.tick&timerRootMask]
+	} else if delay < timerRootSize {
+		l = r.root[expire&timerRootMask]
+	} else {
+		if delay > timerMaxDelay {
+			expire = r.tick + timerMaxDelay
+		}
+		for i := 0; i < timerLevels; i++ {
+			shift := uint(timerRootBits + i*timerLevelBits)
+			if delay < 1<<(shift+timerLevelBits) || i == timerLevels-1 {
+				l = r.levels[i][(expire>>shift)&timerLevelMask]
+				break
+			}
+		}
+	}
+	l.push(t)
+}
+
+func (r *timerWheelT) remove(t *Timer) {
+	if !t.wheeling {
+		return
+	}
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev = nil
+	t.next = nil
+	t.wheeling = false
+}
+
+// 把上层的定时器重新分配到下层，返回格子索引，为0表示需要继续处理更上一层
+func (r *timerWheelT) cascade(level int) int64 {
+	index := (r.tick >> uint(timerRootBits+level*timerLevelBits)) & timerLevelMask
+	for t := r.levels[level][index].take(); t != nil; {
+		next := t.next
+		t.wheeling = false
+		r.add(t)
+		t = next
+	}
+	return index
+}
+
+// 推进到now，返回到期的定时器
+func (r *timerWheelT) advance(now int64) []*Timer {
+	var expired []*Timer
+	for r.tick <= now {
+		index := r.tick & timerRootMask
+		if index == 0 {
+			for i := 0; i < timerLevels && r.cascade(i) == 0; i++ {
+			}
+		}
+		r.tick++
+		for t := r.root[index].take(); t != nil; {
+			next := t.next
+			t.prev = nil
+			t.next = nil
+			t.wheeling = false
+			expired = append(expired, t)
+			t = next
+		}
+	}
+	return expired
+}
+
+func timerWheelTick(now int64) {
+	timerWheel.Lock()
+	expired := timerWheel.advance(now)
+	timerWheel.Unlock()
+	for _, t := range expired {
+		t.fire()
+	}
+}
+
+// 定时器的所有者，所有者关闭时定时器自动停止
+type timerOwner struct {
+	lock   sync.Mutex
+	stop   bool
+	timers map[*Timer]struct{}
+}
+
+func (r *timerOwner) add(t *Timer) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stop {
+		return false
+	}
+	if r.timers == nil {
+		r.timers = map[*Timer]struct{}{}
+	}
+	r.timers[t] = struct{}{}
+	return true
+}
+
+func (r *timerOwner) remove(t *Timer) {
+	r.lock.Lock()
+	delete(r.timers, t)
+	r.lock.Unlock()
+}
+
+func (r *timerOwner) stopTimers() {
+	r.lock.Lock()
+	r.stop = true
+	timers := r.timers
+	r.timers = nil
+	r.lock.Unlock()
+	for t := range timers {
+		t.Stop()
+	}
+}
+
+func (r *timerOwner) Len() int {
+	r.lock.Lock()
+	n := len(r.timers)
+	r.lock.Unlock()
+	return n
+}
+
+func startTimer(t *Timer, inteval int) *Timer {
+	if t.owner != nil && !t.owner.add(t) {
+		t.stop = 1
+		return t
+	}
+	timerWheel.Lock()
+	t.expire = timerWheel.now() + int64(inteval)
+	timerWheel.add(t)
+	timerWheel.Unlock()
+	return t
+}
+
+func newTimeout(inteval int, fn func(...interface{}) int, args []interface{}, actor *Actor, owner *timerOwner) *Timer {
+	if inteval < 0 {
+		LogError("new timeout inteval:%v ms", inteval)
+		return &Timer{stop: 1}
+	}
+	return startTimer(&Timer{fn: fn, args: args, actor: actor, owner: owner}, inteval)
+}
+
+func newCron(spec string, fn func(...interface{}), args []interface{}, actor *Actor, owner *timerOwner) (*Timer, error) {
+	cron, err := parseCron(spec)
+	if err != nil {
+		LogError("new cron spec:%v err:%v", spec, err)
+		return nil, err
+	}
+	next := cron.next(time.Now())
+	if next.IsZero() {
+		return nil, ErrTimerCron
+	}
+	t := &Timer{
+		fn: func(args ...interface{}) int {
+			fn(args...)
+			return 0
+		},
+		args:  args,
+		cron:  cron,
+		actor: actor,
+		owner: owner,
+	}
+	inteval := int(time.Until(next) / time.Millisecond)
+	if inteval < 1 {
+		inteval = 1
+	}
+	return startTimer(t, inteval), nil
+}
+
+func newInterval(inteval int, fn func(...interface{}), args []interface{}, actor *Actor, owner *timerOwner) *Timer {
+	if inteval <= 0 {
+		LogError("new interval inteval:%v ms", inteval)
+		return &Timer{stop: 1}
+	}
+	return newTimeout(inteval, func(args ...interface{}) int {
+		fn(args...)
+		return inteval
+	}, args, actor, owner)
+}
+
+// cron表达式，格式为：分 时 日 月 周，支持* , - /，也支持@yearly @monthly @weekly @daily @hourly，使用本地时区
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	domAll bool
+	dowAll bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if s, ok := cronDescriptors[spec]; ok {
+		spec = s
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrTimerCron
+	}
+	c := &cronSchedule{}
+	var err error
+	ranges := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	bits := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if *bits[i], err = parseCronField(f, ranges[i][0], ranges[i][1]); err != nil {
+			return nil, err
+		}
+	}
+	if c.dow&(1<<7) > 0 {
+		c.dow |= 1
+	}
+	c.domAll = fields[2] == "*" || fields[2] == "?"
+	c.dowAll = fields[4] == "*" || fields[4] == "?"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, ErrTimerCron
+			}
+			step = s
+			part = part[:i]
+		}
+		begin, end := min, max
+		if part != "*" && part != "?" {
+			if i := strings.Index(part, "-"); i >= 0 {
+				b, err1 := strconv.Atoi(part[:i])
+				e, err2 := strconv.Atoi(part[i+1:])
+				if err1 != nil || err2 != nil {
+					return 0, ErrTimerCron
+				}
+				begin, end = b, e
+			} else {
+				b, err := strconv.Atoi(part)
+				if err != nil {
+					return 0, ErrTimerCron
+				}
+				begin = b
+				if step == 1 {
+					end = b
+				}
+			}
+		}
+		if begin < min || end > max || begin > end {
+			return 0, ErrTimerCron
+		}
+		for v := begin; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (r *cronSchedule) dayMatch(t time.Time) bool {
+	dom := r.dom&(1<<uint(t.Day())) > 0
+	dow := r.dow&(1<<uint(t.Weekday())) > 0
+	if r.domAll || r.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// 返回t之后的下一次执行时间，5年内没有匹配返回零值
+func (r *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if r.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !r.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if r.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if r.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,143 @@
+package antnet
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_TimerWheel(t *testing.T) {
+	start := int64(1000003)
+	w := newTimerWheel(start)
+	delays := []int64{0, 1, 255, 256, 300, 16383, 16384, 70000, 1 << 20, 20000000, 1<<26 + 7}
+	timers := map[*Timer]int64{}
+	for _, d := range delays {
+		tm := &Timer{expire: start + d}
+		w.add(tm)
+		timers[tm] = start + d
+	}
+	stop := &Timer{expire: start + 500}
+	w.add(stop)
+	w.remove(stop)
+
+	fired := 0
+	now := start
+	last := start + delays[len(delays)-1]
+	for step := int64(1); now <= last+step; now += step {
+		if now-start > 1<<21 {
+			step = 997
+		}
+		for _, tm := range w.advance(now) {
+			if tm == stop {
+				t.Fatal("removed timer fired")
+			}
+			if expire := timers[tm]; expire > now || now-expire >= step {
+				t.Fatalf("timer expire:%v fired at:%v", expire, now)
+			}
+			fired++
+		}
+	}
+	if fired != len(delays) {
+		t.Fatalf("fired:%v want:%v", fired, len(delays))
+	}
+}
+
+func Test_Cron(t *testing.T) {
+	loc := time.UTC
+	base := time.Date(2024, 2, 28, 23, 58, 30, 0, loc)
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 2, 28, 23, 59, 0, 0, loc)},
+		{"*/15 * * * *", time.Date(2024, 2, 29, 0, 0, 0, 0, loc)},
+		{"30 4 * * *", time.Date(2024, 2, 29, 4, 30, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, loc)},
+		{"0 12 * * 1-5", time.Date(2024, 2, 29, 12, 0, 0, 0, loc)},
+		{"0 9 1,15 * 0", time.Date(2024, 3, 1, 9, 0, 0, 0, loc)},
+		{"@monthly", time.Date(2024, 3, 1, 0, 0, 0, 0, loc)},
+		{"0 0 * * 7", time.Date(2024, 3, 3, 0, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.spec)
+		if err != nil {
+			t.Fatalf("parse %v err:%v", c.spec, err)
+		}
+		if got := s.next(base); !got.Equal(c.want) {
+			t.Fatalf("spec:%v got:%v want:%v", c.spec, got, c.want)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Fatalf("spec:%q should fail", spec)
+		}
+	}
+}
+
+func Test_TimerStop(t *testing.T) {
+	cfire := make(chan int)
+	cresume := make(chan struct{})
+	tm := SetTimeout(10, func(...interface{}) int {
+		cfire <- 1
+		<-cresume
+		return 10
+	})
+	for i := 0; i < 2; i++ {
+		<-cfire
+		if i == 1 {
+			//在回调执行中停止，回调返回后不能再次调度
+			tm.Stop()
+		}
+		cresume <- struct{}{}
+	}
+	select {
+	case <-cfire:
+		t.Fatal("timer fired after stop")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	owner := &timerOwner{}
+	newTimeout(10, func(...interface{}) int {
+		cfire <- 2
+		return 0
+	}, nil, nil, owner)
+	owner.stopTimers()
+	if owner.Len() != 0 {
+		t.Fatal("owner timer not removed")
+	}
+	select {
+	case <-cfire:
+		t.Fatal("owner timer not stopped")
+	case <-time.After(time.Millisecond * 30):
+	}
+}
+
+// 在子进程中停止DefApp，其他实例的定时器和时间仍然继续
+func Test_TimerAfterDefAppStop(t *testing.T) {
+	if os.Getenv("ANTNET_TEST_DEFAPP_STOP") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^Test_TimerAfterDefAppStop$")
+		cmd.Env = append(os.Environ(), "ANTNET_TEST_DEFAPP_STOP=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+	DefApp.Stop()
+	app := NewApp(nil)
+	defer app.Stop()
+	start := nowTick()
+	c := make(chan struct{}, 1)
+	app.SetTimeout(20, func(...interface{}) int {
+		c <- struct{}{}
+		return 0
+	})
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatal("app timer not fired after DefApp stop")
+	}
+	if nowTick() <= start {
+		t.Fatalf("tick stopped start:%v now:%v", start, nowTick())
+	}
+}